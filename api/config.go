@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fockker/container"
+)
+
+// fockkerd监听的套接字与API约定
+var (
+	DefaultSocketPath string = "/var/run/fockker.sock" // fockkerd默认监听的unix套接字
+	ContentTypeJSON   string = "application/json"
+	ContentTypeStream string = "application/x-ndjson"  // 流式输出，每行一个Message或LogEntry
+	ContentTypeTar    string = "application/x-tar"     // 归档内容
+	UpgradeProtocol   string = "tcp"                   // attach升级连接后直接转发原始字节流，exec升级后输出按帧返回
+	HeaderPathIsDir   string = "X-Fockker-Path-Is-Dir" // 容器内路径是否为目录，HEAD archive时返回
+)
+
+// exec升级连接后fockkerd写入的帧类型，客户端的输入仍为原始字节流
+var (
+	FrameStdout  byte = 1         // 命令的标准输出
+	FrameStderr  byte = 2         // 命令的标准错误
+	FrameExit    byte = 3         // 命令的退出码，内容为十进制数字，为最后一帧
+	FrameError   byte = 4         // 执行异常，内容为错误信息，为最后一帧
+	maxFrameSize int  = 32 * 1024 // 单帧内容的最大长度，超过时拆分为多帧
+)
+
+// ErrorResponse 请求失败时返回的错误信息
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// Message 流式输出中的一条消息，Stream为输出内容，Error不为空时表示操作失败，为最后一条消息
+type Message struct {
+	Stream string `json:"stream,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// IDResponse 创建镜像、容器等操作返回的ID
+type IDResponse struct {
+	ID string `json:"id"`
+}
+
+// WaitResponse 等待容器退出后返回的容器状态
+type WaitResponse struct {
+	Status string `json:"status"`
+}
+
+// RunRequest 创建并启动容器的请求
+type RunRequest struct {
+	Container  *container.ContainerInfo `json:"container"`  // 用户指定的镜像、命令、挂载、网络等运行参数
+	Entrypoint []string                 `json:"entrypoint"` // 为null时使用镜像的Entrypoint，为[]时清除
+}
+
+// CommitRequest 将容器提交为镜像的请求
+type CommitRequest struct {
+	Ref     string   `json:"ref"`     // 新镜像的名称
+	Author  string   `json:"author"`  // 作者
+	Message string   `json:"message"` // 提交说明
+	Changes []string `json:"changes"` // 对镜像配置的修改，如 CMD ["sh"]
+	Pause   bool     `json:"pause"`   // 提交期间是否冻结运行中的容器
+}
+
+// ExecRequest 在容器中执行命令的请求
+type ExecRequest struct {
+	Cmd []string `json:"cmd"`
+}
+
+// TagRequest 为镜像打标签的请求
+type TagRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// NetworkCreateRequest 创建网络的请求
+type NetworkCreateRequest struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`   // bridge、host、none，为空时为bridge
+	Subnet string `json:"subnet"` // 网段，为空时自动分配
+}
+
+// Volume 容器挂载的数据卷
+type Volume struct {
+	Container   string `json:"container"`   // 挂载该数据卷的容器名
+	Source      string `json:"source"`      // 宿主机路径
+	Destination string `json:"destination"` // 容器内路径
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// exec升级连接后，fockkerd写入的内容以帧为单位：1字节帧类型、3字节保留、4字节大端的内容长度，之后为内容
+
+// FrameWriter 按帧写入连接，命令的标准输出与标准错误可同时写入，帧之间不会交错
+type FrameWriter struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+// NewFrameWriter 创建按帧写入writer的FrameWriter
+func NewFrameWriter(writer io.Writer) *FrameWriter {
+	return &FrameWriter{writer: writer}
+}
+
+// WriteFrame 写入一帧，内容超过maxFrameSize时拆分为多帧
+func (f *FrameWriter) WriteFrame(frameType byte, data []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for {
+		n := min(len(data), maxFrameSize)
+		header := make([]byte, 8, 8+n)
+		header[0] = frameType
+		binary.BigEndian.PutUint32(header[4:], uint32(n))
+		if _, err := f.writer.Write(append(header, data[:n]...)); err != nil {
+			return err
+		}
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// Stream 返回将写入的内容作为frameType类型的帧写入的io.Writer
+func (f *FrameWriter) Stream(frameType byte) io.Writer {
+	return &frameStream{frames: f, frameType: frameType}
+}
+
+type frameStream struct {
+	frames    *FrameWriter
+	frameType byte
+}
+
+func (s *frameStream) Write(p []byte) (int, error) {
+	if err := s.frames.WriteFrame(s.frameType, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrame 读取一帧，返回帧类型与内容
+func ReadFrame(reader io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[4:])
+	if size > uint32(maxFrameSize) {
+		return 0, nil, fmt.Errorf("帧长度 %d 超过上限 %d", size, maxFrameSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 标准输出与标准错误交替写入，超过maxFrameSize的内容拆分为多帧，读取后按类型还原
+func TestFrameRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	frames := NewFrameWriter(&buffer)
+	large := bytes.Repeat([]byte("x"), maxFrameSize+10)
+	_, _ = frames.Stream(FrameStdout).Write([]byte("out\n"))
+	_, _ = frames.Stream(FrameStderr).Write([]byte("err\n"))
+	_, _ = frames.Stream(FrameStdout).Write(large)
+	_ = frames.WriteFrame(FrameExit, []byte("7"))
+
+	outputs := map[byte][]byte{}
+	var count int
+	for {
+		frameType, data, err := ReadFrame(&buffer)
+		if err != nil {
+			t.Fatalf("读取帧失败: %v", err)
+		}
+		count++
+		if frameType == FrameExit {
+			if string(data) != "7" {
+				t.Errorf("退出码为 %q，应为 7", data)
+			}
+			break
+		}
+		outputs[frameType] = append(outputs[frameType], data...)
+	}
+	if count != 5 {
+		t.Errorf("共 %d 帧，应为5帧", count)
+	}
+	if !bytes.Equal(outputs[FrameStdout], append([]byte("out\n"), large...)) {
+		t.Errorf("标准输出长度为 %d，应为 %d", len(outputs[FrameStdout]), len(large)+4)
+	}
+	if string(outputs[FrameStderr]) != "err\n" {
+		t.Errorf("标准错误为 %q，应为 \"err\\n\"", outputs[FrameStderr])
+	}
+}
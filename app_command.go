@@ -1,15 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"fockker/api"
 	"fockker/container"
 	"fockker/container/cgroups"
+	"fockker/image"
 	"fockker/network"
 	"fockker/nsenter"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"time"
 )
 
 // InitCommand 不可显式调用。容器在执行/proc/self/exe后触发的方法
@@ -18,7 +24,7 @@ var InitCommand = cli.Command{
 	Action: func(context *cli.Context) error {
 		err := container.RunContainerInitProcess()
 		if err != nil {
-			// 启动容器进程时的异常，由daemon进行处理
+			// 启动容器进程时的异常，由shim进行处理
 		}
 		return err
 	},
@@ -27,7 +33,7 @@ var InitCommand = cli.Command{
 // RunCommand 用户显式调用的方法。基于镜像运行容器
 var RunCommand = cli.Command{
 	Name:  "run",
-	Usage: `基于镜像创建一个容器，包含namespace隔离、cgroup资源限制：fockker run -it [image] [command]`,
+	Usage: `基于镜像创建一个容器，包含namespace隔离、cgroup资源限制：fockker run -it [image] [command]，未指定command时使用镜像的Entrypoint与Cmd`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "it",
@@ -37,6 +43,10 @@ var RunCommand = cli.Command{
 			Name:  "d",
 			Usage: `后台运行`,
 		},
+		cli.BoolFlag{
+			Name:  "i",
+			Usage: `后台运行时保持标准输入打开，可通过attach输入`,
+		},
 		cli.StringFlag{
 			Name:  "name",
 			Usage: `容器名称`,
@@ -47,7 +57,7 @@ var RunCommand = cli.Command{
 		},
 		cli.StringFlag{
 			Name:  "net",
-			Usage: `连接到容器网络`,
+			Usage: `连接到容器网络，host为共享宿主机网络，none为仅有回环接口`,
 		},
 		cli.StringFlag{
 			Name:  "p",
@@ -69,11 +79,70 @@ var RunCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset限制",
 		},
-		// TODO start 启动进入stopped状态的容器
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "容器主机名",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "容器内运行用户: user[:group]",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "容器内工作目录",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "进程资源限制: nofile=1024:2048",
+		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Usage: "日志驱动: json-file、none、syslog、fluentd",
+			Value: container.LogDriverJSONFile,
+		},
+		cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "日志驱动选项: max-size=10m、max-file=3、mode=non-blocking、syslog-address=udp://127.0.0.1:514",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "替换镜像的Entrypoint，为空字符串时清除",
+		},
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "分离终端的按键，容器转为后台运行",
+			Value: container.DetachKeys,
+		},
+		cli.StringFlag{
+			Name:  "restart",
+			Usage: "容器退出后的重启策略: no、on-failure[:最大重启次数]、always、unless-stopped",
+			Value: container.RestartNo,
+		},
+		cli.StringFlag{
+			Name:  "health-cmd",
+			Usage: "健康检查命令，在容器中以shell执行，退出码为0时健康；未指定时使用镜像的健康检查，为NONE时禁用",
+		},
+		cli.DurationFlag{
+			Name:  "health-interval",
+			Usage: "健康检查的间隔，未指定时使用镜像的配置，默认30s",
+		},
+		cli.DurationFlag{
+			Name:  "health-timeout",
+			Usage: "单次健康检查的超时时间，未指定时使用镜像的配置，默认30s",
+		},
+		cli.IntFlag{
+			Name:  "health-retries",
+			Usage: "健康检查连续失败该次数后为unhealthy，未指定时使用镜像的配置，默认3",
+		},
+		cli.StringFlag{
+			Name:  "health-on-failure",
+			Usage: "unhealthy时的处理: none只记录状态、restart终止容器并重新启动",
+			Value: container.HealthOnFailureNone,
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
-			return fmt.Errorf(`缺少command参数`)
+			return fmt.Errorf(`缺少镜像名`)
 		}
 
 		// 提取输入的参数
@@ -86,23 +155,142 @@ var RunCommand = cli.Command{
 		cmdArry = cmdArry[1:]
 
 		// 入参解析
-		createTTY := context.Bool("it")         // 是否创建可交互终端
-		detach := context.Bool("d")             // 是否分离父子进程（即后台运行）
-		containerName := context.String("name") // 容器运行名称
-		volume := context.String("v")           // 宿主机与容器挂载
-		portMapping := context.StringSlice("p") // 宿主机与容器端口映射
-		network := context.String("net")        // 连接到容器网络
-		envSlice := context.StringSlice("e")    // 设置环境变量
-		resourceConf := &cgroups.ResourceConfig{
-			MemoryLimit: context.String("m"),
-			CPUSet:      context.String("cpuset"),
-			CPUShares:   context.String("cpushare"),
+		createTTY := context.Bool("it") // 是否创建可交互终端
+		detach := context.Bool("d")     // 是否分离父子进程（即后台运行）
+		var rlimits []container.Rlimit
+		for _, ulimit := range context.StringSlice("ulimit") {
+			rlimit, err := container.ParseUlimit(ulimit)
+			if err != nil {
+				return err
+			}
+			rlimits = append(rlimits, rlimit)
+		}
+		logOpts, err := container.ParseLogOpts(context.String("log-driver"), context.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
+		if _, _, err = container.ParseRestartPolicy(context.String("restart")); err != nil {
+			return err
+		}
+		if _, err = container.ParseHealthOnFailure(context.String("health-on-failure")); err != nil {
+			return err
+		}
+		// 用户指定的健康检查，未指定的项使用镜像的配置
+		var healthcheck *image.HealthConfig
+		if context.IsSet("health-cmd") || context.IsSet("health-interval") || context.IsSet("health-timeout") || context.IsSet("health-retries") {
+			healthcheck = &image.HealthConfig{
+				Interval: context.Duration("health-interval"),
+				Timeout:  context.Duration("health-timeout"),
+				Retries:  context.Int("health-retries"),
+			}
+			if healthCmd := context.String("health-cmd"); healthCmd == container.HealthTestNone {
+				healthcheck.Test = []string{container.HealthTestNone}
+			} else if healthCmd != "" {
+				healthcheck.Test = []string{container.HealthTestShell, healthCmd}
+			}
+		}
+		containerInfo := &container.ContainerInfo{
+			Name:        context.String("name"),       // 容器运行名称
+			Image:       imgName,                      // 镜像名称
+			Cmd:         cmdArry,                      // cmd参数列表
+			Volume:      context.String("v"),          // 宿主机与容器挂载
+			PortMapping: context.StringSlice("p"),     // 宿主机与容器端口映射
+			NetworkName: context.String("net"),        // 连接到容器网络
+			Env:         context.StringSlice("e"),     // 设置环境变量
+			Hostname:    context.String("hostname"),   // 容器主机名
+			User:        context.String("u"),          // 容器内运行用户
+			WorkingDir:  context.String("w"),          // 容器内工作目录
+			Rlimits:     rlimits,                      // 进程资源限制
+			OpenStdin:   context.Bool("i"),            // 保持标准输入打开
+			Tty:         createTTY,                    // 创建伪终端
+			LogDriver:   context.String("log-driver"), // 日志驱动
+			LogOpts:     logOpts,                      // 日志选项
+
+			RestartPolicy:   context.String("restart"),           // 重启策略
+			Healthcheck:     healthcheck,                         // 健康检查
+			HealthOnFailure: context.String("health-on-failure"), // unhealthy时的处理
+			Resource: &cgroups.ResourceConfig{
+				MemoryLimit: context.String("m"),
+				CPUSet:      context.String("cpuset"),
+				CPUShares:   context.String("cpushare"),
+			},
+		}
+
+		// 用户指定的entrypoint，未指定时为nil
+		var entrypoint []string
+		if context.IsSet("entrypoint") {
+			entrypoint = []string{}
+			if context.String("entrypoint") != "" {
+				entrypoint = append(entrypoint, context.String("entrypoint"))
+			}
 		}
 
 		if createTTY && detach {
 			return fmt.Errorf(`不可同时指定 'it' 创建终端 与 'd' 后台运行`)
 		}
-		RunC(cmdArry, imgName, containerName, createTTY, volume, network, portMapping, envSlice, resourceConf)
+		detachKeys, err := container.ParseDetachKeys(context.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		created, err := createContainer(containerInfo, entrypoint)
+		if err != nil {
+			return err
+		}
+		if !createTTY {
+			fmt.Printf("容器 %s 启动成功\n", created.Name)
+			return nil
+		}
+		// 在宿主机终端与容器伪终端之间转发输入输出，直到容器退出或输入分离按键
+		detached, err := attachContainer(created.Name, true, detachKeys)
+		if err != nil {
+			return err
+		}
+		if detached {
+			// 分离后容器继续在后台运行，可通过attach重新连接
+			fmt.Printf("\r\n容器 %s 已分离，转为后台运行\r\n", created.Name)
+			return nil
+		}
+		// 未分离的容器在退出后删除，设置了重启策略的容器保留并按策略重新启动
+		if _, err = fockkerClient.Wait(created.Name); err != nil {
+			return err
+		}
+		if policy, _, _ := container.ParseRestartPolicy(containerInfo.RestartPolicy); policy != container.RestartNo {
+			return nil
+		}
+		return fockkerClient.Remove(created.Name)
+	},
+}
+
+var AttachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "连接到运行中容器的标准输入输出，按下分离按键断开连接：fockker attach container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "断开连接的按键，容器继续运行",
+			Value: container.DetachKeys,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		detachKeys, err := container.ParseDetachKeys(context.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		containerName := context.Args().Get(0)
+		containerInfo, err := fockkerClient.Inspect(containerName)
+		if err != nil {
+			return err
+		}
+		detached, err := attachContainer(containerName, containerInfo.Tty, detachKeys)
+		if err != nil {
+			return err
+		}
+		if detached {
+			fmt.Printf("\r\n已断开与容器 %s 的连接\r\n", containerName)
+		}
 		return nil
 	},
 }
@@ -111,20 +299,93 @@ var ListCommand = cli.Command{
 	Name:  "ps",
 	Usage: "显示所有容器",
 	Action: func(context *cli.Context) error {
-		container.ListContainers()
+		containers, err := fockkerClient.List()
+		if err != nil {
+			return err
+		}
+		container.PrintContainers(os.Stdout, containers)
+		return nil
+	},
+}
+
+var InspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "显示容器详细信息",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		containerName := context.Args().Get(0)
+		containerInfo, err := fockkerClient.Inspect(containerName)
+		if err != nil {
+			return err
+		}
+		return printJSON(containerInfo)
+	},
+}
+
+var StartCommand = cli.Command{
+	Name:  "start",
+	Usage: "启动进入stopped、exited状态的容器",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		containerName := context.Args().Get(0)
+		if err := fockkerClient.Start(containerName); err != nil {
+			return err
+		}
+		fmt.Printf("容器 %s 启动成功\n", containerName)
 		return nil
 	},
 }
 
 var StopCommand = cli.Command{
 	Name:  "stop",
-	Usage: "停止正在运行的容器",
+	Usage: "停止正在运行的容器，发送镜像配置的停止信号（默认SIGTERM），超时仍未退出时发送SIGKILL：fockker stop -t 10 container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "等待容器退出的秒数，超过后强制终止",
+			Value: int(container.DefaultStopTimeout / time.Second),
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		if context.Int("t") < 0 {
+			return fmt.Errorf("无效的等待时间 %d", context.Int("t"))
+		}
+		containerName := context.Args().Get(0)
+		containerInfo, err := fockkerClient.Stop(containerName, time.Duration(context.Int("t"))*time.Second)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("容器: %s, ID: %s, 已进入%s\n", containerName, containerInfo.Id, containerInfo.Status)
+		return nil
+	},
+}
+
+var KillCommand = cli.Command{
+	Name:  "kill",
+	Usage: "向运行中的容器发送信号，不改变容器状态：fockker kill -s SIGHUP container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Usage: "信号名或信号值，如 SIGHUP、HUP、1",
+			Value: "SIGKILL",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("缺少容器名")
 		}
 		containerName := context.Args().Get(0)
-		container.StopContainer(containerName)
+		if err := fockkerClient.Kill(containerName, context.String("s")); err != nil {
+			return err
+		}
+		fmt.Printf("已向容器 %s 发送信号 %s\n", containerName, context.String("s"))
 		return nil
 	},
 }
@@ -136,8 +397,14 @@ var RemoveCommand = cli.Command{
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("缺少容器名")
 		}
-		containerName := context.Args().Get(0)
-		container.RemoveContainer(containerName)
+		containerInfo, err := fockkerClient.Inspect(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		if err = fockkerClient.Remove(containerInfo.Name); err != nil {
+			return err
+		}
+		fmt.Printf("容器: %s, ID: %s, 已删除\n", containerInfo.Name, containerInfo.Id)
 		return nil
 	},
 }
@@ -162,7 +429,14 @@ var ExecCommand = cli.Command{
 		for _, arg := range context.Args().Tail() {
 			cmdArry = append(cmdArry, arg)
 		}
-		container.ExecContainer(containerName, cmdArry)
+		exitCode, err := fockkerClient.Exec(containerName, cmdArry, os.Stdin, os.Stdout, os.Stderr)
+		if err != nil {
+			return err
+		}
+		// 以容器中命令的退出码退出
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
 		return nil
 	},
 }
@@ -170,13 +444,65 @@ var ExecCommand = cli.Command{
 var LogCommand = cli.Command{
 	Name:  "logs",
 	Usage: "打印容器日志",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f,follow",
+			Usage: "持续输出新的日志，直到容器退出",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Usage: "只显示最后的行数",
+			Value: "all",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "只显示该时间之后的日志，如 2024-01-02T15:04:05Z、1700000000、10m",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "只显示该时间之前的日志，格式同 --since",
+		},
+		cli.BoolFlag{
+			Name:  "t,timestamps",
+			Usage: "显示每行日志的时间",
+		},
+		cli.StringFlag{
+			Name:  "stream",
+			Usage: "只显示指定输出流的日志：stdout、stderr",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("请输入容器名")
 		}
-		containerName := context.Args().Get(0)
-		container.GetLogContent(containerName)
-		return nil
+		options := container.LogOptions{
+			Follow:     context.Bool("f"),
+			Tail:       -1,
+			Timestamps: context.Bool("t"),
+			Stream:     context.String("stream"),
+		}
+		if options.Stream != "" && options.Stream != container.StreamStdout && options.Stream != container.StreamStderr {
+			return fmt.Errorf("不支持的输出流 %s", options.Stream)
+		}
+		if tail := context.String("tail"); tail != "all" {
+			n, err := strconv.Atoi(tail)
+			if err != nil || n < 0 {
+				return fmt.Errorf("无效的行数 %s", tail)
+			}
+			options.Tail = n
+		}
+		// 相对时间以客户端的当前时间计算
+		now := time.Now()
+		var err error
+		if options.Since, err = container.ParseLogTime(context.String("since"), now); err != nil {
+			return err
+		}
+		if options.Until, err = container.ParseLogTime(context.String("until"), now); err != nil {
+			return err
+		}
+		return fockkerClient.Logs(context.Args().Get(0), options, func(entry *container.LogEntry) error {
+			return container.WriteLogEntry(entry, options.Timestamps, os.Stdout, os.Stderr)
+		})
 	},
 }
 
@@ -190,7 +516,7 @@ var NetwormCommand = cli.Command{
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "type",
-					Usage: "network driver",
+					Usage: "network driver: bridge、host、none",
 				},
 				cli.StringFlag{
 					Name:  "subnet",
@@ -201,28 +527,19 @@ var NetwormCommand = cli.Command{
 				if len(context.Args()) < 1 {
 					return fmt.Errorf("缺少网络名称")
 				}
-				networkName := context.Args()[0]
-				netType := context.String("type")
-				ipRange := context.String("subnet")
-				// 未指定网段则使用默认
-				var networkType network.NetworkType
-				if netType == "" {
-					networkType = network.Bridge
-				} else {
-					networkType = network.NetworkType(netType)
-				}
-				err := network.CreateNetwork(networkName, networkType, ipRange)
-				if err != nil {
-					return fmt.Errorf("网络创建异常: %v", err)
-				}
-				return nil
+				// 未指定网络类型则使用bridge，未指定网段则自动分配
+				return fockkerClient.CreateNetwork(context.Args()[0], context.String("type"), context.String("subnet"), os.Stdout)
 			},
 		},
 		{
 			Name:  "ls",
 			Usage: "显示当前所有容器网络",
 			Action: func(context *cli.Context) error {
-				network.ListNetwork()
+				networks, err := fockkerClient.Networks()
+				if err != nil {
+					return err
+				}
+				network.PrintNetworks(os.Stdout, networks)
 				return nil
 			},
 		},
@@ -233,29 +550,446 @@ var NetwormCommand = cli.Command{
 				if len(context.Args()) < 1 {
 					return fmt.Errorf("缺少网络名称")
 				}
-				networkName := context.Args()[0]
-				err := network.DistoryNetwork(networkName)
-				if err != nil {
-					return fmt.Errorf("网络删除异常: %v", err)
+				return fockkerClient.RemoveNetwork(context.Args()[0], os.Stdout)
+			},
+		},
+	},
+}
+
+var ImageCommand = cli.Command{
+	Name:  "image",
+	Usage: "镜像管理命令行",
+	Subcommands: []cli.Command{
+		{
+			Name:  "ls",
+			Usage: "显示镜像存储中的镜像",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "a",
+					Usage: "同时显示未打标签的中间镜像",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				query := url.Values{}
+				if context.Bool("a") {
+					query.Set("all", "1")
+				}
+				var images []image.ImageSummary
+				if err := fockkerClient.Call(http.MethodGet, "/images", query, nil, &images); err != nil {
+					return err
+				}
+				image.PrintImages(os.Stdout, images)
+				return nil
+			},
+		},
+		{
+			Name:  "tag",
+			Usage: "为镜像打上新的标签：fockker image tag [image] [name[:tag]]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 2 {
+					return fmt.Errorf("缺少镜像名或新的标签")
+				}
+				request := api.TagRequest{Source: context.Args().Get(0), Target: context.Args().Get(1)}
+				return fockkerClient.Call(http.MethodPost, "/images/tag", nil, request, nil)
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "显示镜像的运行配置与各层信息",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("缺少镜像名")
+				}
+				var detail image.ImageDetail
+				if err := fockkerClient.Call(http.MethodGet, "/images/inspect", url.Values{"ref": {context.Args().Get(0)}}, nil, &detail); err != nil {
+					return err
+				}
+				return printJSON(detail)
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "删除未被容器使用的镜像",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("缺少镜像名")
+				}
+				return fockkerClient.Stream(http.MethodDelete, "/images", url.Values{"ref": context.Args()}, nil, "", os.Stdout)
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "删除未打标签且未被容器使用的镜像，以及不再被引用的镜像层",
+			Action: func(context *cli.Context) error {
+				return fockkerClient.Stream(http.MethodPost, "/images/prune", nil, nil, "", os.Stdout)
+			},
+		},
+	},
+}
+
+var PullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "从镜像仓库拉取镜像：fockker pull [registry/]repo[:tag]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "insecure",
+			Usage: "使用HTTP访问镜像仓库，localhost等回环地址默认允许",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少镜像名")
+		}
+		query := url.Values{"ref": {context.Args().Get(0)}}
+		if context.Bool("insecure") {
+			query.Set("insecure", "1")
+		}
+		return fockkerClient.Stream(http.MethodPost, "/images/pull", query, nil, "", os.Stdout)
+	},
+}
+
+var LoadCommand = cli.Command{
+	Name:  "load",
+	Usage: "从OCI镜像布局或docker save格式的归档导入镜像：fockker load -i archive.tar",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "镜像归档路径，默认从标准输入读取",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		reader := os.Stdin
+		if input := context.String("i"); input != "" {
+			file, err := os.Open(input)
+			if err != nil {
+				return fmt.Errorf("镜像归档 %s 打开异常: %v", input, err)
+			}
+			defer func() {
+				_ = file.Close()
+			}()
+			reader = file
+		}
+		return fockkerClient.Stream(http.MethodPost, "/images/load", nil, reader, api.ContentTypeTar, os.Stdout)
+	},
+}
+
+var SaveCommand = cli.Command{
+	Name:  "save",
+	Usage: "将镜像导出为同时兼容OCI镜像布局与docker save格式的归档：fockker save -o archive.tar [image...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "镜像归档路径，默认写入标准输出",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少镜像名")
+		}
+		return downloadDaemon("/images/save", url.Values{"ref": context.Args()}, context.String("o"))
+	},
+}
+
+var DiffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "显示容器相对镜像的文件变更，A新增、C修改、D删除：fockker diff [--format json] container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "输出格式，json以JSON数组输出",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		format := context.String("format")
+		if format != "" && format != "json" {
+			return fmt.Errorf("不支持的输出格式 %s", format)
+		}
+		var changes []image.Change
+		if err := fockkerClient.Call(http.MethodGet, "/containers/"+url.PathEscape(context.Args().Get(0))+"/changes", nil, nil, &changes); err != nil {
+			return err
+		}
+		if format == "json" {
+			return printJSON(changes)
+		}
+		for _, change := range changes {
+			fmt.Printf("%s %s\n", change.Kind, change.Path)
+		}
+		return nil
+	},
+}
+
+var CopyCommand = cli.Command{
+	Name:  "cp",
+	Usage: "在宿主机与容器之间复制文件：fockker cp src container:dst 或 fockker cp container:src dst，- 表示以tar格式使用标准输入输出",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("缺少源路径或目标路径")
+		}
+		src, dst := context.Args().Get(0), context.Args().Get(1)
+		srcContainer, srcPath := container.SplitContainerPath(src)
+		dstContainer, dstPath := container.SplitContainerPath(dst)
+		var err error
+		switch {
+		case srcContainer != "" && dstContainer == "":
+			err = copyFromContainer(srcContainer, srcPath, dstPath)
+		case srcContainer == "" && dstContainer != "":
+			err = copyToContainer(dstContainer, srcPath, dstPath)
+		default:
+			return fmt.Errorf("源路径与目标路径中必须有且只有一个为 container:path 格式")
+		}
+		if err != nil {
+			return fmt.Errorf("文件复制异常: %v", err)
+		}
+		return nil
+	},
+}
+
+var ExportCommand = cli.Command{
+	Name:  "export",
+	Usage: "将容器文件系统导出为tar包：fockker export -o rootfs.tar container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "tar包路径，默认写入标准输出",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		containerName := context.Args().Get(0)
+		return downloadDaemon("/containers/"+url.PathEscape(containerName)+"/export", nil, context.String("o"))
+	},
+}
+
+var ImportCommand = cli.Command{
+	Name:  "import",
+	Usage: "将文件系统tar包导入为单层镜像：fockker import [-c change] [-m message] rootfs.tar|- [image[:tag]]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "c,change",
+			Usage: "设置镜像配置，支持ENV、CMD、ENTRYPOINT、WORKDIR、USER、EXPOSE、LABEL、STOPSIGNAL，如 -c 'CMD [\"sh\"]'",
+		},
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "导入说明",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少tar包路径")
+		}
+		source := context.Args().Get(0)
+		query := url.Values{
+			"source":  {source},
+			"message": {context.String("m")},
+			"change":  context.StringSlice("c"),
+		}
+		if len(context.Args()) > 1 {
+			query.Set("ref", context.Args().Get(1))
+		}
+		reader := os.Stdin
+		if source != "-" {
+			file, err := os.Open(source)
+			if err != nil {
+				return fmt.Errorf("tar包 %s 打开异常: %v", source, err)
+			}
+			defer func() {
+				_ = file.Close()
+			}()
+			reader = file
+		}
+		resp, err := fockkerClient.Do(http.MethodPost, "/images/import", query, reader, api.ContentTypeTar)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var created api.IDResponse
+		if err = json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			return err
+		}
+		fmt.Println(created.ID)
+		return nil
+	},
+}
+
+var CommitCommand = cli.Command{
+	Name:  "commit",
+	Usage: "将容器的修改提交为新镜像：fockker commit [-a author] [-m message] [-c change] container image[:tag]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "a",
+			Usage: "作者",
+		},
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "提交说明",
+		},
+		cli.StringSliceFlag{
+			Name:  "c,change",
+			Usage: "修改镜像配置，支持ENV、CMD、ENTRYPOINT、WORKDIR、USER、EXPOSE、LABEL、STOPSIGNAL，如 -c 'CMD [\"sh\"]'",
+		},
+		cli.BoolTFlag{
+			Name:  "pause",
+			Usage: "提交期间冻结运行中的容器，默认开启",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("缺少容器名或镜像名")
+		}
+		request := api.CommitRequest{
+			Ref:     context.Args().Get(1),
+			Author:  context.String("a"),
+			Message: context.String("m"),
+			Changes: context.StringSlice("c"),
+			Pause:   context.BoolT("pause"),
+		}
+		var created api.IDResponse
+		err := fockkerClient.Call(http.MethodPost, "/containers/"+url.PathEscape(context.Args().Get(0))+"/commit", nil, request, &created)
+		if err != nil {
+			return fmt.Errorf("容器提交异常: %v", err)
+		}
+		fmt.Println(created.ID)
+		return nil
+	},
+}
+
+var BuildCommand = cli.Command{
+	Name:  "build",
+	Usage: "根据Fockerfile构建镜像：fockker build -f Fockerfile -t name .",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "f",
+			Usage: "Fockerfile路径，默认为构建上下文下的Fockerfile",
+		},
+		cli.StringFlag{
+			Name:  "t",
+			Usage: "镜像名",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "不使用构建缓存",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		contextDir := "."
+		if len(context.Args()) > 0 {
+			contextDir = context.Args().Get(0)
+		}
+		if context.String("t") == "" {
+			return fmt.Errorf("缺少镜像名")
+		}
+		return buildImage(contextDir, context.String("f"), context.String("t"), context.Bool("no-cache"))
+	},
+}
+
+var EventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "显示容器事件，未指定 --until 时持续输出新的事件",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "只显示该时间之后的事件，如 2024-01-02T15:04:05Z、1700000000、10m",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "只显示该时间之前的事件，格式同 --since",
+		},
+		cli.StringFlag{
+			Name:  "container",
+			Usage: "只显示指定容器的事件，容器名或ID",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		options := container.EventOptions{Name: context.String("container")}
+		now := time.Now()
+		var err error
+		if options.Since, err = container.ParseLogTime(context.String("since"), now); err != nil {
+			return err
+		}
+		if options.Until, err = container.ParseLogTime(context.String("until"), now); err != nil {
+			return err
+		}
+		options.Follow = options.Until.IsZero() || options.Until.After(now)
+		// 持续输出且未指定 --since 时只显示新的事件
+		if options.Follow && options.Since.IsZero() {
+			options.Since = now
+		}
+		return fockkerClient.Events(options, func(event *container.Event) error {
+			_, err := fmt.Printf("%s container %s %s (name=%s, image=%s)\n",
+				event.Time.Local().Format(time.RFC3339Nano), event.Action, event.Id, event.Name, event.Image)
+			return err
+		})
+	},
+}
+
+var VolumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "数据卷命令行",
+	Subcommands: []cli.Command{
+		{
+			Name:  "ls",
+			Usage: "显示容器挂载的数据卷",
+			Action: func(context *cli.Context) error {
+				var volumes []api.Volume
+				if err := fockkerClient.Call(http.MethodGet, "/volumes", nil, nil, &volumes); err != nil {
+					return err
 				}
+				printVolumes(volumes)
 				return nil
 			},
 		},
 	},
 }
 
-var DaemonCommand = cli.Command{
-	Name:  "daemon",
-	Usage: "停止正在运行的容器",
+// FockkerdCommand 常驻运行的fockkerd，持有容器、镜像、网络的全部状态，在unix套接字上提供HTTP/JSON API
+var FockkerdCommand = cli.Command{
+	Name:  "fockkerd",
+	Usage: "启动fockkerd，其他命令均通过fockkerd执行：fockkerd [--host socket]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "host",
+			Usage: "监听的unix套接字",
+			Value: api.DefaultSocketPath,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return RunFockkerd(context.String("host"))
+	},
+}
+
+var ShimCommand = cli.Command{
+	Name:  "shim",
+	Usage: "容器的shim进程，由fockkerd启动，不可显式调用",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "host-network",
+			Usage: "容器与宿主机共享网络栈",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		if len(context.Args()) < 3 {
-			return fmt.Errorf("缺少容器PID、cgroupPath, 容器名")
-		}
-		pid := context.Args().Get(0)
-		cgroupPath := context.Args().Get(1)
-		containerName := context.Args().Get(2)
-		intPid, _ := strconv.Atoi(pid)
-		container.RunDaemon(intPid, cgroupPath, containerName)
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("缺少容器名")
+		}
+		container.RunShim(context.Args().Get(0), context.Bool("host-network"))
+		return nil
+	},
+}
+
+var ArchiveCommand = cli.Command{
+	Name:  "archive",
+	Usage: "在容器根目录中打包、解压文件的辅助进程，由cp启动，不可显式调用",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("缺少容器根目录与操作")
+		}
+		container.RunArchiveHelper(context.Args().Get(0), context.Args().Tail())
 		return nil
 	},
 }
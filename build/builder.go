@@ -0,0 +1,511 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fockker/container"
+	"fockker/errdefs"
+	"fockker/image"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Builder 根据Fockerfile构建镜像
+type Builder struct {
+	ContextDir string    // 构建上下文目录，COPY、ADD的源路径基于该目录
+	Fockerfile string    // Fockerfile路径
+	Tag        string    // 生成的镜像名，name[:tag]
+	NoCache    bool      // 是否跳过构建缓存
+	Out        io.Writer // 构建步骤与RUN的输出，默认为标准输出
+
+	state    stepState // 当前步骤完成后的状态
+	cacheKey string    // 当前步骤的缓存key，作为下一步骤的父key
+}
+
+// NewBuilder 创建构建器
+func NewBuilder(contextDir string, fockerfile string, tag string, noCache bool) *Builder {
+	if fockerfile == "" {
+		fockerfile = filepath.Join(contextDir, DefaultFockerfile)
+	}
+	return &Builder{
+		ContextDir: contextDir,
+		Fockerfile: fockerfile,
+		Tag:        tag,
+		NoCache:    noCache,
+		Out:        os.Stdout,
+	}
+}
+
+// Build 逐条执行Fockerfile指令，最终将镜像层与镜像配置写入镜像存储
+func (b *Builder) Build() error {
+	if name, tag := image.ParseReference(b.Tag); name == "" || tag == "" || strings.ContainsAny(b.Tag, " \t@") {
+		return fmt.Errorf("镜像名 %q 不合法", b.Tag)
+	}
+	file, err := os.Open(b.Fockerfile)
+	if err != nil {
+		return fmt.Errorf("Fockerfile %s 打开异常: %v", b.Fockerfile, err)
+	}
+	instructions, err := ParseFockerfile(file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+
+	for i, instruction := range instructions {
+		fmt.Fprintf(b.Out, "Step %d/%d : %s\n", i+1, len(instructions), instruction.Original)
+		if err = b.step(instruction); err != nil {
+			return fmt.Errorf("第%d行 %s 执行失败: %v", instruction.Line, instruction.Command, err)
+		}
+	}
+	if err = b.commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.Out, "镜像 %s 构建成功\n", b.Tag)
+	return nil
+}
+
+// 执行单条指令，命中缓存时直接复用缓存中的结果
+func (b *Builder) step(instruction Instruction) error {
+	if instruction.Command == "FROM" {
+		return b.from(instruction)
+	}
+	extra, err := b.sourceDigest(instruction)
+	if err != nil {
+		return err
+	}
+	key := digest(b.cacheKey, instruction.Original, extra)
+	if !b.NoCache {
+		if state, err := loadStep(key); err == nil {
+			fmt.Fprintf(b.Out, " ---> 使用缓存 %s\n", key[:12])
+			b.state = *state
+			b.cacheKey = key
+			return nil
+		}
+	}
+
+	cacheDir := fmt.Sprintf(BuildCachePath, key)
+	// 清理同key的残留缓存（如上次构建中断）
+	_ = os.RemoveAll(cacheDir)
+	if err = os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+	state := b.state
+	state.Layers = append([]string{}, b.state.Layers...)
+	switch instruction.Command {
+	case "RUN", "COPY", "ADD":
+		// 产生文件变更的指令，在父步骤的镜像层之上增加一层
+		var layer string
+		if instruction.Command == "RUN" {
+			layer, err = b.run(key, shellArgs(instruction))
+		} else {
+			layer, err = b.copy(key, instruction)
+		}
+		state.Layers = append(state.Layers, layer)
+	default:
+		state.Config = applyConfig(state.Config, instruction)
+	}
+	if err != nil {
+		_ = os.RemoveAll(cacheDir)
+		return err
+	}
+	if err = saveStep(key, &state); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.Out, " ---> %s\n", key[:12])
+	b.state = state
+	b.cacheKey = key
+	return nil
+}
+
+// ApplyChanges 将ENV、WORKDIR、CMD等配置类指令应用到镜像配置，用于commit、import的 --change 参数
+func ApplyChanges(config image.ImageConfig, changes []string) (image.ImageConfig, error) {
+	for _, change := range changes {
+		instruction, err := parseInstruction(change, 0)
+		if err != nil {
+			return config, err
+		}
+		if !configInstructions[instruction.Command] {
+			return config, fmt.Errorf("不支持的配置变更 %s", instruction.Command)
+		}
+		config = applyConfig(config, instruction)
+	}
+	return config, nil
+}
+
+// 将配置类指令应用到镜像配置，返回新的配置，不修改原配置中的切片与map
+func applyConfig(config image.ImageConfig, instruction Instruction) image.ImageConfig {
+	switch instruction.Command {
+	case "ENV":
+		config.Env = container.MergeEnv(config.Env, instruction.Args)
+	case "WORKDIR":
+		workDir := instruction.Args[0]
+		if !path.IsAbs(workDir) {
+			workDir = path.Join("/", config.WorkingDir, workDir)
+		}
+		config.WorkingDir = path.Clean(workDir)
+	case "CMD":
+		config.Cmd = shellArgs(instruction)
+	case "ENTRYPOINT":
+		config.Entrypoint = shellArgs(instruction)
+	case "USER":
+		config.User = instruction.Args[0]
+	case "EXPOSE":
+		ports := map[string]struct{}{}
+		for port := range config.ExposedPorts {
+			ports[port] = struct{}{}
+		}
+		for _, port := range instruction.Args {
+			// 未指定协议时默认为tcp
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			ports[port] = struct{}{}
+		}
+		config.ExposedPorts = ports
+	case "LABEL":
+		labels := map[string]string{}
+		for key, value := range config.Labels {
+			labels[key] = value
+		}
+		for _, label := range instruction.Args {
+			key, value, _ := strings.Cut(label, "=")
+			labels[key] = value
+		}
+		config.Labels = labels
+	case "STOPSIGNAL":
+		config.StopSignal = instruction.Args[0]
+	}
+	return config
+}
+
+// FROM 以已有镜像或scratch空白镜像作为基础镜像
+func (b *Builder) from(instruction Instruction) error {
+	imgName := instruction.Args[0]
+	if imgName == scratchImage {
+		b.state = stepState{}
+		b.cacheKey = digest("", instruction.Original, "")
+		return nil
+	}
+	img, err := container.ResolveImage(imgName)
+	if errors.Is(err, errdefs.ErrNotFound) {
+		// 本地不存在的基础镜像从镜像仓库拉取，拉取进度写入构建输出
+		img, err = image.Pull(imgName, false, b.Out)
+	}
+	if err != nil {
+		return fmt.Errorf("基础镜像 %s 不存在: %v", imgName, err)
+	}
+	// 基础镜像的层与配置作为初始状态
+	b.state = stepState{Layers: img.Layers, Config: img.Config}
+	// 镜像ID作为key的一部分，镜像被重新构建后缓存失效
+	b.cacheKey = digest("", instruction.Original, img.ID)
+	fmt.Fprintf(b.Out, " ---> %s\n", b.cacheKey[:12])
+	return nil
+}
+
+// RUN 在临时容器中执行命令，容器层即为本步骤产生的新层，返回新层的摘要
+func (b *Builder) run(key string, cmdArry []string) (string, error) {
+	// 以父步骤的状态生成未打标签的中间镜像，作为临时容器的镜像
+	parent, err := image.CreateImage(b.state.Layers, b.state.Config)
+	if err != nil {
+		return "", err
+	}
+	containerName := "build-" + key[:12]
+	containerInfo := &container.ContainerInfo{
+		Id:         containerName,
+		Name:       containerName,
+		Image:      parent.ID,
+		ImageID:    parent.ID,
+		Cmd:        cmdArry,
+		Env:        b.state.Config.Env,
+		WorkingDir: b.state.Config.WorkingDir,
+		User:       b.state.Config.User,
+		Hostname:   containerName,
+	}
+	// 构建容器使用宿主机网络，便于RUN中下载依赖
+	processCmd, writePipe, stdio := container.NewContainerProcess(parent.ID, containerName, false, false, "", true)
+	if processCmd == nil {
+		return "", fmt.Errorf("临时容器 %s 创建失败", containerName)
+	}
+	defer func() {
+		container.DeleteWorkSpace("", containerName)
+		_ = container.RemoveContainerDirs(containerName)
+	}()
+	// 构建输出直接写入构建的输出，不使用守护进程转发
+	stdio.Close()
+	processCmd.Stdout = b.Out
+	processCmd.Stderr = b.Out
+	if err := processCmd.Start(); err != nil {
+		return "", fmt.Errorf("临时容器 %s 启动失败: %v", containerName, err)
+	}
+	if err := container.SendInitSpec(container.NewInitSpec(containerInfo), writePipe); err != nil {
+		_ = processCmd.Process.Kill()
+		_ = processCmd.Wait()
+		return "", err
+	}
+	if err := processCmd.Wait(); err != nil {
+		return "", fmt.Errorf("命令 %q 执行失败: %v", cmdArry, err)
+	}
+
+	upperDir := fmt.Sprintf(container.WriteLayerPath, containerName)
+	layer, err := image.CreateLayerFromDir(upperDir)
+	if err != nil {
+		return "", fmt.Errorf("容器层 %s 导入异常: %v", upperDir, err)
+	}
+	return layer, nil
+}
+
+// COPY、ADD 将构建上下文中的文件复制到临时目录，作为新层导入镜像存储，返回新层的摘要
+func (b *Builder) copy(key string, instruction Instruction) (string, error) {
+	sources := instruction.Args[:len(instruction.Args)-1]
+	dest := instruction.Args[len(instruction.Args)-1]
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.state.Config.WorkingDir, dest)
+	}
+	layerDir := filepath.Join(fmt.Sprintf(BuildCachePath, key), stepLayerName)
+	if err := os.MkdirAll(layerDir, 0755); err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = os.RemoveAll(layerDir)
+	}()
+	// 目标以/结尾、为 . 、已是目录或存在多个源时，目标视为目录
+	rawDest := instruction.Args[len(instruction.Args)-1]
+	destIsDir := strings.HasSuffix(rawDest, "/") || rawDest == "." || strings.HasSuffix(rawDest, "/.") || len(sources) > 1 ||
+		b.isDir(dest)
+	target := filepath.Join(layerDir, dest)
+	for _, source := range sources {
+		if instruction.Command == "ADD" && isURL(source) {
+			if err := download(source, target, destIsDir); err != nil {
+				return "", err
+			}
+			continue
+		}
+		sourcePath, err := b.contextPath(source)
+		if err != nil {
+			return "", err
+		}
+		if instruction.Command == "ADD" && isArchive(sourcePath) {
+			// ADD的本地压缩包自动解压到目标目录
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return "", err
+			}
+			if output, err := exec.Command("tar", "--unlink-first", "-xf", sourcePath, "-C", target).CombinedOutput(); err != nil {
+				return "", fmt.Errorf("解压 %s 异常: %v %s", source, err, output)
+			}
+			continue
+		}
+		if err = copyPath(sourcePath, target, destIsDir); err != nil {
+			return "", err
+		}
+	}
+	layer, err := image.CreateLayerFromDir(layerDir)
+	if err != nil {
+		return "", fmt.Errorf("新层导入异常: %v", err)
+	}
+	return layer, nil
+}
+
+// 判断镜像中的路径是否为目录，由顶层向底层查找第一个包含该路径的层
+func (b *Builder) isDir(dest string) bool {
+	for i := len(b.state.Layers) - 1; i >= 0; i-- {
+		info, err := os.Lstat(filepath.Join(image.LayerDiffPath(b.state.Layers[i]), dest))
+		if err == nil {
+			return info.IsDir()
+		}
+	}
+	return false
+}
+
+// 将最终的镜像层与配置写入镜像存储，并打上构建指定的标签
+func (b *Builder) commit() error {
+	img, err := image.CreateImage(b.state.Layers, b.state.Config, b.Tag)
+	if err != nil {
+		return fmt.Errorf("镜像 %s 创建异常: %v", b.Tag, err)
+	}
+	fmt.Fprintf(b.Out, " ---> %s\n", img.ID)
+	return nil
+}
+
+// 解析构建上下文中的路径，禁止通过 .. 访问上下文之外的文件
+func (b *Builder) contextPath(source string) (string, error) {
+	contextDir, err := filepath.Abs(b.ContextDir)
+	if err != nil {
+		return "", err
+	}
+	sourcePath := filepath.Join(contextDir, source)
+	if sourcePath != contextDir && !strings.HasPrefix(sourcePath, contextDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("源路径 %s 超出构建上下文", source)
+	}
+	return sourcePath, nil
+}
+
+// COPY、ADD的源文件内容摘要，源文件变化时缓存失效
+func (b *Builder) sourceDigest(instruction Instruction) (string, error) {
+	if instruction.Command != "COPY" && instruction.Command != "ADD" {
+		return "", nil
+	}
+	hash := sha256.New()
+	for _, source := range instruction.Args[:len(instruction.Args)-1] {
+		if isURL(source) {
+			hash.Write([]byte(source))
+			continue
+		}
+		sourcePath, err := b.contextPath(source)
+		if err != nil {
+			return "", err
+		}
+		err = filepath.WalkDir(sourcePath, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(sourcePath, filePath)
+			_, _ = fmt.Fprintf(hash, "%s %s %d\n", rel, info.Mode(), info.Size())
+			if info.Mode().IsRegular() {
+				file, err := os.Open(filePath)
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(hash, file)
+				_ = file.Close()
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, _ := os.Readlink(filePath)
+				hash.Write([]byte(target))
+			}
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("源路径 %s 读取异常: %v", source, err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 将RUN、CMD、ENTRYPOINT的shell形式转换为 /bin/sh -c 执行
+func shellArgs(instruction Instruction) []string {
+	if instruction.JSONForm {
+		return instruction.Args
+	}
+	return []string{"/bin/sh", "-c", instruction.Args[0]}
+}
+
+// 以父步骤key、指令文本与附加内容计算缓存key
+func digest(parentKey string, original string, extra string) string {
+	hash := sha256.Sum256([]byte(parentKey + "\n" + original + "\n" + extra))
+	return hex.EncodeToString(hash[:])
+}
+
+// 读取缓存的步骤状态
+func loadStep(key string) (*stepState, error) {
+	content, err := os.ReadFile(filepath.Join(fmt.Sprintf(BuildCachePath, key), stepFileName))
+	if err != nil {
+		return nil, err
+	}
+	state := &stepState{}
+	if err = json.Unmarshal(content, state); err != nil {
+		return nil, err
+	}
+	// 缓存引用的镜像层已被删除时视为未命中
+	for _, layer := range state.Layers {
+		if !image.LayerExists(layer) {
+			return nil, fmt.Errorf("缓存镜像层 %s 不存在", layer)
+		}
+	}
+	return state, nil
+}
+
+// 保存步骤状态，step.json写入后该缓存才视为有效
+func saveStep(key string, state *stepState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(fmt.Sprintf(BuildCachePath, key), stepFileName), content, 0644)
+}
+
+// 将源路径复制到目标路径；源为目录时复制目录下的内容
+func copyPath(source string, dest string, destIsDir bool) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if err = os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		source = source + "/."
+	} else {
+		if destIsDir {
+			dest = filepath.Join(dest, filepath.Base(source))
+		}
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+	}
+	// --remove-destination 先删除再写入，多个源写入同一目标时后者覆盖前者
+	if output, err := exec.Command("cp", "-a", "--remove-destination", source, dest).CombinedOutput(); err != nil {
+		return fmt.Errorf("复制 %s 异常: %v %s", source, err, output)
+	}
+	return nil
+}
+
+// 下载ADD指令中的URL文件
+func download(rawURL string, dest string, destIsDir bool) error {
+	if destIsDir {
+		parsed, _ := url.Parse(rawURL)
+		dest = filepath.Join(dest, path.Base(parsed.Path))
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		return fmt.Errorf("下载 %s 异常: %v", rawURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载 %s 异常: %s", rawURL, resp.Status)
+	}
+	_ = os.Remove(dest)
+	file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	_, err = io.Copy(file, resp.Body)
+	return err
+}
+
+// 判断ADD的源是否为URL
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// 判断ADD的本地源是否为需要解压的压缩包
+func isArchive(source string) bool {
+	for _, suffix := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tar.xz"} {
+		if strings.HasSuffix(source, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package build
+
+import (
+	"fockker/container"
+	"fockker/image"
+)
+
+// 构建相关路径
+var (
+	BuildCachePath    string = container.RootPath + "/buildCache/%s" // 构建缓存路径，%s为缓存key
+	DefaultFockerfile string = "Fockerfile"                          // 默认的构建文件名
+	stepFileName      string = "step.json"                           // 构建步骤的缓存信息文件名
+	stepLayerName     string = "layer"                               // COPY、ADD准备新层内容的临时目录名
+	scratchImage      string = "scratch"                             // 空白基础镜像
+)
+
+// Instruction Fockerfile中的一条指令
+type Instruction struct {
+	Command  string   // 指令名，统一为大写，如RUN
+	Args     []string // 指令参数
+	JSONForm bool     // 参数是否为 ["a", "b"] 形式
+	Original string   // 原始指令文本，作为构建缓存key的一部分
+	Line     int      // 指令所在行号
+}
+
+// 构建过程中每一步的状态，缓存在step.json中
+type stepState struct {
+	Layers []string          `json:"layers"` // 该步骤完成后的镜像层，由底层到顶层
+	Config image.ImageConfig `json:"config"` // 该步骤完成后的镜像配置，由ENV、WORKDIR、CMD、ENTRYPOINT、USER、EXPOSE、LABEL、STOPSIGNAL生成
+}
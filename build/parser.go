@@ -0,0 +1,189 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 支持的Fockerfile指令
+var supportedInstructions = map[string]bool{
+	"FROM":       true,
+	"RUN":        true,
+	"COPY":       true,
+	"ADD":        true,
+	"ENV":        true,
+	"WORKDIR":    true,
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"USER":       true,
+	"EXPOSE":     true,
+	"LABEL":      true,
+	"STOPSIGNAL": true,
+}
+
+// 只修改镜像配置、不产生新层的指令
+var configInstructions = map[string]bool{
+	"ENV":        true,
+	"WORKDIR":    true,
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"USER":       true,
+	"EXPOSE":     true,
+	"LABEL":      true,
+	"STOPSIGNAL": true,
+}
+
+// ParseFockerfile 解析Fockerfile，支持 # 注释与行尾 \ 续行
+func ParseFockerfile(reader io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	startLine := 0
+	var buffer strings.Builder
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if buffer.Len() == 0 {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			startLine = lineNum
+		} else if strings.HasPrefix(line, "#") {
+			// 续行中的注释直接忽略
+			continue
+		}
+		// 行尾的 \ 表示指令在下一行继续
+		if strings.HasSuffix(line, "\\") {
+			buffer.WriteString(strings.TrimSuffix(line, "\\"))
+			buffer.WriteString(" ")
+			continue
+		}
+		buffer.WriteString(line)
+		instruction, err := parseInstruction(buffer.String(), startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		buffer.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if buffer.Len() > 0 {
+		return nil, fmt.Errorf("第%d行: 指令续行未结束", startLine)
+	}
+	if len(instructions) == 0 || instructions[0].Command != "FROM" {
+		return nil, fmt.Errorf("Fockerfile的第一条指令必须为FROM")
+	}
+	return instructions, nil
+}
+
+// 解析单条指令
+func parseInstruction(text string, line int) (Instruction, error) {
+	command, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+	command = strings.ToUpper(command)
+	rest = strings.TrimSpace(rest)
+	if !supportedInstructions[command] {
+		return Instruction{}, fmt.Errorf("第%d行: 不支持的指令 %s", line, command)
+	}
+	instruction := Instruction{
+		Command:  command,
+		Original: command + " " + rest,
+		Line:     line,
+	}
+	if rest == "" {
+		return Instruction{}, fmt.Errorf("第%d行: %s 缺少参数", line, command)
+	}
+	switch command {
+	case "RUN", "CMD", "ENTRYPOINT", "COPY", "ADD":
+		// exec形式：["executable", "param1"]
+		if strings.HasPrefix(rest, "[") {
+			var args []string
+			if err := json.Unmarshal([]byte(rest), &args); err != nil {
+				return Instruction{}, fmt.Errorf("第%d行: %s 参数格式错误: %v", line, command, err)
+			}
+			instruction.Args = args
+			instruction.JSONForm = true
+			break
+		}
+		if command == "COPY" || command == "ADD" {
+			instruction.Args = strings.Fields(rest)
+		} else {
+			// shell形式，整体交给/bin/sh -c执行
+			instruction.Args = []string{rest}
+		}
+	case "ENV", "LABEL":
+		env, err := parseEnv(rest)
+		if err != nil {
+			return Instruction{}, fmt.Errorf("第%d行: %v", line, err)
+		}
+		instruction.Args = env
+	default:
+		instruction.Args = strings.Fields(rest)
+	}
+	if (command == "COPY" || command == "ADD") && len(instruction.Args) < 2 {
+		return Instruction{}, fmt.Errorf("第%d行: %s 需要至少一个源路径与一个目标路径", line, command)
+	}
+	return instruction, nil
+}
+
+// 解析ENV、LABEL指令，支持 ENV key value 与 ENV key1=value1 key2="value 2" 两种形式，返回 key=value 列表
+func parseEnv(rest string) ([]string, error) {
+	fields, err := splitQuoted(rest)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(fields[0], "=") {
+		// ENV key value，值为剩余全部内容
+		key, value, _ := strings.Cut(rest, " ")
+		return []string{key + "=" + strings.TrimSpace(value)}, nil
+	}
+	var env []string
+	for _, field := range fields {
+		if !strings.Contains(field, "=") {
+			return nil, fmt.Errorf("ENV 参数格式错误 %s", field)
+		}
+		env = append(env, field)
+	}
+	return env, nil
+}
+
+// 按空白分割字符串，支持单双引号包裹的整体
+func splitQuoted(text string) ([]string, error) {
+	var fields []string
+	var current strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("引号未闭合: %s", text)
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fockker/api"
+	"fockker/client"
+	"fockker/container"
+	"fockker/image"
+	"fockker/ioutils"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// CLI访问fockkerd的客户端，由全局参数 --host 指定套接字路径
+var fockkerClient = client.NewDaemonClient(api.DefaultSocketPath)
+
+// 以JSON格式输出到标准输出
+func printJSON(value interface{}) error {
+	jsonBytes, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+// 创建并启动容器，镜像在本地不存在时从镜像仓库拉取后重试，拉取进度输出到标准输出
+func createContainer(containerInfo *container.ContainerInfo, entrypoint []string) (*container.ContainerInfo, error) {
+	created, err := fockkerClient.Create(containerInfo, entrypoint)
+	if !errors.Is(err, client.ErrNotFound) {
+		return created, err
+	}
+	// 网络等其他资源不存在时同样返回ErrNotFound，镜像存在时直接返回创建失败的原因
+	query := url.Values{"ref": {containerInfo.Image}}
+	var detail image.ImageDetail
+	if inspectErr := fockkerClient.Call(http.MethodGet, "/images/inspect", query, nil, &detail); !errors.Is(inspectErr, client.ErrNotFound) {
+		return nil, err
+	}
+	fmt.Printf("本地不存在镜像 %s，从镜像仓库拉取\n", containerInfo.Image)
+	if err = fockkerClient.Stream(http.MethodPost, "/images/pull", query, nil, "", os.Stdout); err != nil {
+		return nil, err
+	}
+	return fockkerClient.Create(containerInfo, entrypoint)
+}
+
+// 连接到运行中容器的标准输入输出，返回是否为输入分离按键断开
+func attachContainer(containerName string, tty bool, detachKeys []byte) (bool, error) {
+	conn, err := fockkerClient.Attach(containerName)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	resize := func(height uint16, width uint16) {
+		_ = fockkerClient.Resize(containerName, height, width)
+	}
+	return container.AttachTerminal(conn, tty, detachKeys, resize), nil
+}
+
+// 下载归档内容，output为空时写入标准输出
+func downloadDaemon(path string, query url.Values, output string) error {
+	resp, err := fockkerClient.Do(http.MethodGet, path, query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if output == "" {
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	return ioutils.WriteFileAtomic(output, resp.Body)
+}
+
+// 将容器内的文件或目录复制到宿主机，dstPath为 - 时以tar格式写入标准输出
+// dstPath为已存在的目录时复制到该目录下，否则以dstPath为新文件名
+func copyFromContainer(containerName string, srcPath string, dstPath string) error {
+	archivePath := "/containers/" + url.PathEscape(containerName) + "/archive"
+	if dstPath == "-" {
+		return downloadDaemon(archivePath, url.Values{"path": {srcPath}}, "")
+	}
+	dstDir, name := dstPath, ""
+	if info, err := os.Stat(dstPath); err != nil || !info.IsDir() {
+		dstDir, name = filepath.Dir(dstPath), filepath.Base(dstPath)
+	}
+	if info, err := os.Stat(dstDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("目标目录 %s 不存在", dstDir)
+	}
+	resp, err := fockkerClient.Do(http.MethodGet, archivePath, url.Values{"path": {srcPath}, "name": {name}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	return image.ExtractTar(resp.Body, dstDir)
+}
+
+// 将宿主机的文件或目录复制到容器内，srcPath为 - 时从标准输入读取tar并解压到容器内的目录
+// dstPath为容器内已存在的目录时复制到该目录下，否则以dstPath为新文件名
+func copyToContainer(containerName string, srcPath string, dstPath string) error {
+	archivePath := "/containers/" + url.PathEscape(containerName) + "/archive"
+	if srcPath == "-" {
+		return callDaemonBody(http.MethodPut, archivePath, url.Values{"path": {dstPath}}, os.Stdin)
+	}
+	if _, err := os.Lstat(srcPath); err != nil {
+		return fmt.Errorf("路径 %s 不存在", srcPath)
+	}
+	dstDir, name := dstPath, filepath.Base(srcPath)
+	resp, err := fockkerClient.Do(http.MethodHead, archivePath, url.Values{"path": {dstPath}}, nil, "")
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil || resp.Header.Get(api.HeaderPathIsDir) != "true" {
+		// 目标不是目录时复制到其父目录下，以最后一级为新文件名
+		dstDir, name = filepath.Dir(filepath.Clean("/"+dstPath)), filepath.Base(dstPath)
+	}
+	// 边打包边上传，不经过临时文件
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(image.TarPath(srcPath, name, writer))
+	}()
+	err = callDaemonBody(http.MethodPut, archivePath, url.Values{"path": {dstDir}}, reader)
+	_ = reader.CloseWithError(err)
+	return err
+}
+
+// 发送以tar为请求体的请求，不读取响应内容
+func callDaemonBody(method string, path string, query url.Values, body io.Reader) error {
+	resp, err := fockkerClient.Do(method, path, query, body, api.ContentTypeTar)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// 将构建上下文打包发送给fockkerd构建镜像，fockerfile为空时使用上下文中的Fockerfile
+func buildImage(contextDir string, fockerfile string, tag string, noCache bool) error {
+	query := url.Values{"t": {tag}}
+	if noCache {
+		query.Set("nocache", "1")
+	}
+	if fockerfile != "" {
+		// fockkerd只能读取构建上下文中的文件
+		absContext, err := filepath.Abs(contextDir)
+		if err != nil {
+			return err
+		}
+		absFockerfile, err := filepath.Abs(fockerfile)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absContext, absFockerfile)
+		if err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("Fockerfile %s 不在构建上下文 %s 中", fockerfile, contextDir)
+		}
+		query.Set("f", rel)
+	}
+	if info, err := os.Stat(contextDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("构建上下文 %s 不存在", contextDir)
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(image.TarDir(contextDir, writer))
+	}()
+	err := fockkerClient.Stream(http.MethodPost, "/build", query, reader, api.ContentTypeTar, os.Stdout)
+	_ = reader.CloseWithError(err)
+	return err
+}
+
+// 以表格格式输出数据卷
+func printVolumes(volumes []api.Volume) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "CONTAINER\tSOURCE\tDESTINATION\n")
+	for _, volume := range volumes {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", volume.Container, volume.Source, volume.Destination)
+	}
+	_ = w.Flush()
+}
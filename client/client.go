@@ -0,0 +1,74 @@
+// Package client 以Go接口驱动fockker，返回容器信息、网络等结构体与可判断类型的错误
+// NewDaemonClient 通过unix套接字访问fockkerd，NewLocalClient 在当前进程内直接调用容器与网络模块
+package client
+
+import (
+	"errors"
+	"fockker/container"
+	"fockker/network"
+	"io"
+	"net"
+	"time"
+)
+
+// Client fockker的容器与网络操作，失败时返回的错误均为 *Error
+type Client interface {
+	// List 获取全部容器信息
+	List() ([]*container.ContainerInfo, error)
+	// Inspect 获取容器信息
+	Inspect(name string) (*container.ContainerInfo, error)
+	// Create 根据containerInfo中的镜像、命令、挂载、网络等运行参数创建并启动容器，返回填写了ID、PID等信息的容器信息
+	// entrypoint不为nil时替换镜像的Entrypoint
+	Create(containerInfo *container.ContainerInfo, entrypoint []string) (*container.ContainerInfo, error)
+	// Start 重新启动已停止的容器
+	Start(name string) error
+	// Stop 向运行中的容器发送停止信号，超过timeout仍未退出时强制终止，容器退出后返回停止后的容器信息
+	// 等待重启中的容器不再重启
+	Stop(name string, timeout time.Duration) (*container.ContainerInfo, error)
+	// Kill 向运行中的容器发送信号，signal为信号名或信号值，为空时为SIGKILL，不改变容器状态
+	Kill(name string, signal string) error
+	// Remove 删除已停止的容器
+	Remove(name string) error
+	// Wait 等待容器退出，返回退出后的状态
+	Wait(name string) (string, error)
+	// Exec 在运行中的容器中执行命令，stdin为nil时不提供输入，返回命令的退出码
+	Exec(name string, cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error)
+	// Attach 连接到运行中容器的标准输入输出
+	Attach(name string) (net.Conn, error)
+	// Resize 设置容器伪终端的窗口大小
+	Resize(name string, height uint16, width uint16) error
+	// Logs 读取容器日志，按选项过滤后依次交给handler处理，handler返回错误时停止读取
+	Logs(name string, options container.LogOptions, handler func(entry *container.LogEntry) error) error
+	// Events 读取容器事件，按选项过滤后依次交给handler处理，handler返回错误时停止读取
+	Events(options container.EventOptions, handler func(event *container.Event) error) error
+	// Networks 获取全部容器网络
+	Networks() ([]*network.Network, error)
+	// CreateNetwork 创建容器网络，networkType为空时为bridge，subnet为空时自动分配，创建过程的输出写入out
+	CreateNetwork(name string, networkType string, subnet string, out io.Writer) error
+	// RemoveNetwork 删除容器网络，删除过程的输出写入out
+	RemoveNetwork(name string, out io.Writer) error
+}
+
+// 将容器模块等返回的错误包装为指定类型的 *Error
+func wrapError(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	var clientErr *Error
+	if errors.As(err, &clientErr) {
+		return err
+	}
+	// 容器、镜像模块返回的错误带有类型时沿用
+	for _, errKind := range []error{ErrNotFound, ErrConflict, ErrInvalid} {
+		if errors.Is(err, errKind) {
+			kind = errKind
+			break
+		}
+	}
+	return &Error{Kind: kind, Message: err.Error()}
+}
+
+var (
+	_ Client = (*DaemonClient)(nil)
+	_ Client = (*LocalClient)(nil)
+)
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"errors"
+	"fockker/errdefs"
+	"time"
+)
+
+// 客户端错误的类型，可通过 errors.Is(err, client.ErrNotFound) 判断
+// 前三种与容器、镜像模块返回的错误类型相同
+var (
+	ErrNotFound    = errdefs.ErrNotFound       // 容器、镜像、网络等不存在
+	ErrConflict    = errdefs.ErrConflict       // 容器未运行、已在运行、名称已存在等
+	ErrInvalid     = errdefs.ErrInvalid        // 缺少镜像名、命令，时间格式错误等
+	ErrUnavailable = errors.New("fockkerd不可用") // 无法连接fockkerd
+	ErrInternal    = errors.New("操作执行失败")      // 操作过程中的其他错误
+)
+
+// Error 客户端返回的错误，Kind为上述错误类型之一，Message为fockkerd或容器模块返回的错误信息
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// 客户端相关配置
+var (
+	containerWaitInterval time.Duration = 200 * time.Millisecond // 进程内等待容器退出时检查容器状态的间隔
+)
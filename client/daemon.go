@@ -0,0 +1,420 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fockker/api"
+	"fockker/container"
+	"fockker/network"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DaemonClient 通过unix套接字访问fockkerd的客户端，请求的主机名无意义
+type DaemonClient struct {
+	socketPath string
+	httpClient *http.Client
+}
+
+// NewDaemonClient 创建访问socketPath上fockkerd的客户端，socketPath为空时使用默认的套接字
+func NewDaemonClient(socketPath string) *DaemonClient {
+	if socketPath == "" {
+		socketPath = api.DefaultSocketPath
+	}
+	c := &DaemonClient{socketPath: socketPath}
+	c.httpClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				return c.dial(ctx)
+			},
+		},
+	}
+	return c
+}
+
+func (c *DaemonClient) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return nil, &Error{Kind: ErrUnavailable, Message: fmt.Sprintf("无法连接fockkerd %s，请确认fockkerd已启动: %v", c.socketPath, err)}
+	}
+	return conn, nil
+}
+
+// 拼接请求的URL
+func daemonURL(path string, query url.Values) string {
+	u := url.URL{Scheme: "http", Host: "fockkerd", Path: path}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// 容器相关API的路径
+func containerPath(name string) string {
+	return "/containers/" + url.PathEscape(name)
+}
+
+// Do 发送请求，body不为nil时作为请求体，返回2xx的响应；失败时返回fockkerd的错误信息
+func (c *DaemonClient) Do(method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	return c.do(context.Background(), method, path, query, body, contentType)
+}
+
+func (c *DaemonClient) do(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, daemonURL(path, query), body)
+	if err != nil {
+		return nil, wrapError(ErrInvalid, err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// 去掉net/http添加的请求信息，保留连接失败的原因
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, wrapError(ErrUnavailable, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	return nil, responseError(resp)
+}
+
+// 读取失败响应中的错误信息，按状态码确定错误类型
+func responseError(resp *http.Response) error {
+	kind := ErrInternal
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		kind = ErrInvalid
+	case http.StatusNotFound:
+		kind = ErrNotFound
+	case http.StatusConflict:
+		kind = ErrConflict
+	}
+	var errorResponse api.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil || errorResponse.Message == "" {
+		return &Error{Kind: kind, Message: fmt.Sprintf("fockkerd返回 %s", resp.Status)}
+	}
+	return &Error{Kind: kind, Message: errorResponse.Message}
+}
+
+// Call 发送JSON格式的请求，request不为nil时编码为请求体，result不为nil时将响应解码到result
+func (c *DaemonClient) Call(method string, path string, query url.Values, request interface{}, result interface{}) error {
+	var body io.Reader
+	if request != nil {
+		content, err := json.Marshal(request)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		body = bytes.NewReader(content)
+	}
+	resp, err := c.Do(method, path, query, body, api.ContentTypeJSON)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if result == nil {
+		return nil
+	}
+	return wrapError(ErrInternal, json.NewDecoder(resp.Body).Decode(result))
+}
+
+// Stream 发送请求并将流式消息中的输出写入out，消息中包含错误时返回该错误
+func (c *DaemonClient) Stream(method string, path string, query url.Values, body io.Reader, contentType string, out io.Writer) error {
+	resp, err := c.Do(method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message api.Message
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return &Error{Kind: ErrInternal, Message: fmt.Sprintf("fockkerd响应读取异常: %v", err)}
+		}
+		if message.Error != "" {
+			return &Error{Kind: ErrInternal, Message: message.Error}
+		}
+		_, _ = fmt.Fprint(out, message.Stream)
+	}
+}
+
+// 以每行一个JSON对象的流式响应依次解码到newValue返回的对象并交给handle，done关闭后断开连接并停止读取
+func (c *DaemonClient) decodeStream(path string, query url.Values, done <-chan struct{}, newValue func() interface{}, handle func(value interface{}) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil, "")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		value := newValue()
+		if err := decoder.Decode(value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return &Error{Kind: ErrInternal, Message: fmt.Sprintf("fockkerd响应读取异常: %v", err)}
+		}
+		if err := handle(value); err != nil {
+			return err
+		}
+	}
+}
+
+// Hijack 发送升级连接的请求，成功后返回与fockkerd之间的原始字节流，用于attach与exec
+func (c *DaemonClient) Hijack(path string, query url.Values, request interface{}) (*HijackedConn, error) {
+	var content []byte
+	if request != nil {
+		var err error
+		if content, err = json.Marshal(request); err != nil {
+			return nil, wrapError(ErrInvalid, err)
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, daemonURL(path, query), bytes.NewReader(content))
+	if err != nil {
+		return nil, wrapError(ErrInvalid, err)
+	}
+	req.Header.Set("Content-Type", api.ContentTypeJSON)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", api.UpgradeProtocol)
+	conn, err := c.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, wrapError(ErrUnavailable, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, &Error{Kind: ErrInternal, Message: fmt.Sprintf("fockkerd响应读取异常: %v", err)}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		err = responseError(resp)
+		_ = conn.Close()
+		return nil, err
+	}
+	return &HijackedConn{Conn: conn, reader: reader}, nil
+}
+
+// HijackedConn 升级后的连接，先读取响应头之后已缓冲的内容
+type HijackedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *HijackedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// CloseWrite 关闭写入端，通知对端输入已结束
+func (c *HijackedConn) CloseWrite() error {
+	if unixConn, ok := c.Conn.(*net.UnixConn); ok {
+		return unixConn.CloseWrite()
+	}
+	return nil
+}
+
+func (c *DaemonClient) List() ([]*container.ContainerInfo, error) {
+	var containers []*container.ContainerInfo
+	if err := c.Call(http.MethodGet, "/containers", nil, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *DaemonClient) Inspect(name string) (*container.ContainerInfo, error) {
+	var containerInfo container.ContainerInfo
+	if err := c.Call(http.MethodGet, containerPath(name), nil, nil, &containerInfo); err != nil {
+		return nil, err
+	}
+	return &containerInfo, nil
+}
+
+func (c *DaemonClient) Create(containerInfo *container.ContainerInfo, entrypoint []string) (*container.ContainerInfo, error) {
+	var created container.ContainerInfo
+	request := api.RunRequest{Container: containerInfo, Entrypoint: entrypoint}
+	if err := c.Call(http.MethodPost, "/containers", nil, request, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *DaemonClient) Start(name string) error {
+	return c.Call(http.MethodPost, containerPath(name)+"/start", nil, nil, nil)
+}
+
+// Stop 的timeout按秒传递
+func (c *DaemonClient) Stop(name string, timeout time.Duration) (*container.ContainerInfo, error) {
+	var containerInfo container.ContainerInfo
+	query := url.Values{"t": {strconv.Itoa(int(timeout / time.Second))}}
+	if err := c.Call(http.MethodPost, containerPath(name)+"/stop", query, nil, &containerInfo); err != nil {
+		return nil, err
+	}
+	return &containerInfo, nil
+}
+
+func (c *DaemonClient) Kill(name string, signal string) error {
+	return c.Call(http.MethodPost, containerPath(name)+"/kill", url.Values{"signal": {signal}}, nil, nil)
+}
+
+func (c *DaemonClient) Remove(name string) error {
+	return c.Call(http.MethodDelete, containerPath(name), nil, nil, nil)
+}
+
+func (c *DaemonClient) Wait(name string) (string, error) {
+	var response api.WaitResponse
+	if err := c.Call(http.MethodPost, containerPath(name)+"/wait", nil, nil, &response); err != nil {
+		return "", err
+	}
+	return response.Status, nil
+}
+
+// Exec 按帧读取fockkerd返回的标准输出、标准错误，直到读到退出码或执行异常
+func (c *DaemonClient) Exec(name string, cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	conn, err := c.Hijack(containerPath(name)+"/exec", nil, api.ExecRequest{Cmd: cmd})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	// 输入结束后关闭写入端，命令读到EOF，输出仍继续读取
+	go func() {
+		if stdin != nil {
+			_, _ = io.Copy(conn, stdin)
+		}
+		_ = conn.CloseWrite()
+	}()
+	outputs := map[byte]io.Writer{api.FrameStdout: stdout, api.FrameStderr: stderr}
+	for {
+		frameType, data, err := api.ReadFrame(conn)
+		if err != nil {
+			return 0, &Error{Kind: ErrInternal, Message: fmt.Sprintf("exec连接中断: %v", err)}
+		}
+		switch frameType {
+		case api.FrameExit:
+			exitCode, err := strconv.Atoi(string(data))
+			if err != nil {
+				return 0, &Error{Kind: ErrInternal, Message: fmt.Sprintf("无效的退出码 %q", data)}
+			}
+			return exitCode, nil
+		case api.FrameError:
+			return 0, &Error{Kind: ErrInternal, Message: string(data)}
+		}
+		if output := outputs[frameType]; output != nil {
+			_, _ = output.Write(data)
+		}
+	}
+}
+
+func (c *DaemonClient) Attach(name string) (net.Conn, error) {
+	return c.Hijack(containerPath(name)+"/attach", nil, nil)
+}
+
+func (c *DaemonClient) Resize(name string, height uint16, width uint16) error {
+	query := url.Values{"h": {strconv.Itoa(int(height))}, "w": {strconv.Itoa(int(width))}}
+	return c.Call(http.MethodPost, containerPath(name)+"/resize", query, nil, nil)
+}
+
+// Logs 中的Timestamps只影响输出格式，由调用方处理
+func (c *DaemonClient) Logs(name string, options container.LogOptions, handler func(entry *container.LogEntry) error) error {
+	query := url.Values{}
+	if options.Follow {
+		query.Set("follow", "1")
+	}
+	if options.Tail >= 0 {
+		query.Set("tail", strconv.Itoa(options.Tail))
+	}
+	if !options.Since.IsZero() {
+		query.Set("since", options.Since.Format(time.RFC3339Nano))
+	}
+	if !options.Until.IsZero() {
+		query.Set("until", options.Until.Format(time.RFC3339Nano))
+	}
+	if options.Stream != "" {
+		query.Set("stream", options.Stream)
+	}
+	return c.decodeStream(containerPath(name)+"/logs", query, options.Done,
+		func() interface{} { return &container.LogEntry{} },
+		func(value interface{}) error { return handler(value.(*container.LogEntry)) })
+}
+
+func (c *DaemonClient) Events(options container.EventOptions, handler func(event *container.Event) error) error {
+	query := url.Values{}
+	if options.Follow {
+		query.Set("follow", "1")
+	}
+	if !options.Since.IsZero() {
+		query.Set("since", options.Since.Format(time.RFC3339Nano))
+	}
+	if !options.Until.IsZero() {
+		query.Set("until", options.Until.Format(time.RFC3339Nano))
+	}
+	if options.Name != "" {
+		query.Set("name", options.Name)
+	}
+	return c.decodeStream("/events", query, options.Done,
+		func() interface{} { return &container.Event{} },
+		func(value interface{}) error { return handler(value.(*container.Event)) })
+}
+
+func (c *DaemonClient) Networks() ([]*network.Network, error) {
+	var networks []*network.Network
+	if err := c.Call(http.MethodGet, "/networks", nil, nil, &networks); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+func (c *DaemonClient) CreateNetwork(name string, networkType string, subnet string, out io.Writer) error {
+	content, err := json.Marshal(api.NetworkCreateRequest{Name: name, Type: networkType, Subnet: subnet})
+	if err != nil {
+		return wrapError(ErrInvalid, err)
+	}
+	return c.Stream(http.MethodPost, "/networks", nil, bytes.NewReader(content), api.ContentTypeJSON, out)
+}
+
+func (c *DaemonClient) RemoveNetwork(name string, out io.Writer) error {
+	return c.Stream(http.MethodDelete, "/networks/"+url.PathEscape(name), nil, nil, "", out)
+}
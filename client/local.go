@@ -0,0 +1,210 @@
+package client
+
+import (
+	"fmt"
+	"fockker/container"
+	"fockker/network"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// LocalClient 在当前进程内直接调用容器与网络模块的客户端，不需要fockkerd
+// 容器由脱离当前进程会话的shim启动并回收，当前进程退出后容器继续运行，状态仍由shim记录；与fockkerd同时修改同一容器时不保证一致
+type LocalClient struct {
+	mutex sync.Mutex // 保护容器生命周期与网络等会修改共享状态的操作
+}
+
+// NewLocalClient 加载容器网络后创建进程内的客户端，exePath为fockker可执行文件的路径
+// 容器init进程、shim与exec需要再次调用fockker，当前程序不是fockker时必须指定，否则可为空
+// 镜像存储根目录为image.StoreRoot，使用其他目录时需在创建客户端前设置，启动的shim等进程使用同一目录
+func NewLocalClient(exePath string) *LocalClient {
+	if exePath != "" {
+		container.ExecutablePath = exePath
+	}
+	network.InitNetwork()
+	return &LocalClient{}
+}
+
+// 获取容器信息，容器不存在时返回ErrNotFound
+func (l *LocalClient) container(name string) (*container.ContainerInfo, error) {
+	containerInfo, err := container.GetContainerInfoByName(name)
+	if err != nil {
+		return nil, &Error{Kind: ErrNotFound, Message: fmt.Sprintf("容器 %s 不存在", name)}
+	}
+	return &containerInfo, nil
+}
+
+// 获取运行中的容器信息，容器未运行时返回ErrConflict
+func (l *LocalClient) runningContainer(name string) (*container.ContainerInfo, error) {
+	containerInfo, err := l.container(name)
+	if err == nil && containerInfo.Status != container.RUNNING {
+		return nil, &Error{Kind: ErrConflict, Message: fmt.Sprintf("容器 %s 未运行", name)}
+	}
+	return containerInfo, err
+}
+
+func (l *LocalClient) List() ([]*container.ContainerInfo, error) {
+	containers, err := container.GetContainers()
+	return containers, wrapError(ErrInternal, err)
+}
+
+func (l *LocalClient) Inspect(name string) (*container.ContainerInfo, error) {
+	return l.container(name)
+}
+
+func (l *LocalClient) Create(containerInfo *container.ContainerInfo, entrypoint []string) (*container.ContainerInfo, error) {
+	if containerInfo == nil || containerInfo.Image == "" {
+		return nil, &Error{Kind: ErrInvalid, Message: "缺少镜像名"}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.container(containerInfo.Name); containerInfo.Name != "" && err == nil {
+		return nil, &Error{Kind: ErrConflict, Message: fmt.Sprintf("容器 %s 创建失败: 该名称已存在", containerInfo.Name)}
+	}
+	if err := container.RunContainer(containerInfo, entrypoint); err != nil {
+		return nil, wrapError(ErrInternal, err)
+	}
+	return containerInfo, nil
+}
+
+func (l *LocalClient) Start(name string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	containerInfo, err := l.container(name)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == container.RUNNING {
+		return &Error{Kind: ErrConflict, Message: fmt.Sprintf("容器: %s, ID: %s, 已为%s", name, containerInfo.Id, containerInfo.Status)}
+	}
+	return wrapError(ErrInternal, container.StartContainer(name))
+}
+
+// Stop 等待容器退出期间不持有锁，容器的清理由shim完成
+func (l *LocalClient) Stop(name string, timeout time.Duration) (*container.ContainerInfo, error) {
+	containerInfo, err := l.container(name)
+	if err != nil {
+		return nil, err
+	}
+	if containerInfo.Status != container.RUNNING && containerInfo.Status != container.RESTARTING {
+		return nil, &Error{Kind: ErrConflict, Message: fmt.Sprintf("容器 %s 未运行", name)}
+	}
+	if err := container.StopContainer(name, timeout); err != nil {
+		return nil, wrapError(ErrInternal, err)
+	}
+	return l.container(name)
+}
+
+func (l *LocalClient) Kill(name string, signal string) error {
+	if _, err := l.runningContainer(name); err != nil {
+		return err
+	}
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	sig, err := container.ParseSignal(signal)
+	if err != nil {
+		return &Error{Kind: ErrInvalid, Message: err.Error()}
+	}
+	return wrapError(ErrInternal, container.KillContainer(name, sig))
+}
+
+func (l *LocalClient) Remove(name string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	containerInfo, err := l.container(name)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == container.RUNNING {
+		return &Error{Kind: ErrConflict, Message: fmt.Sprintf("无法删除正在运行的容器 %s", name)}
+	}
+	return wrapError(ErrInternal, container.RemoveContainer(name))
+}
+
+// Wait 容器被删除时返回删除前最后的状态
+func (l *LocalClient) Wait(name string) (string, error) {
+	containerInfo, err := l.container(name)
+	if err != nil {
+		return "", err
+	}
+	for containerInfo.Status == container.RUNNING {
+		time.Sleep(containerWaitInterval)
+		current, err := l.container(name)
+		if err != nil {
+			break
+		}
+		containerInfo = current
+	}
+	return containerInfo.Status, nil
+}
+
+func (l *LocalClient) Exec(name string, cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	if len(cmd) == 0 {
+		return 0, &Error{Kind: ErrInvalid, Message: "缺少执行的命令"}
+	}
+	if _, err := l.runningContainer(name); err != nil {
+		return 0, err
+	}
+	exitCode, err := container.ExecContainer(name, cmd, stdin, stdout, stderr)
+	return exitCode, wrapError(ErrInternal, err)
+}
+
+func (l *LocalClient) Attach(name string) (net.Conn, error) {
+	if _, err := l.runningContainer(name); err != nil {
+		return nil, err
+	}
+	conn, err := container.DialAttach(name)
+	return conn, wrapError(ErrInternal, err)
+}
+
+func (l *LocalClient) Resize(name string, height uint16, width uint16) error {
+	if _, err := l.runningContainer(name); err != nil {
+		return err
+	}
+	return wrapError(ErrInternal, container.ResizeTerminal(name, height, width))
+}
+
+func (l *LocalClient) Logs(name string, options container.LogOptions, handler func(entry *container.LogEntry) error) error {
+	if _, err := l.container(name); err != nil {
+		return err
+	}
+	return wrapError(ErrInternal, container.ReadLogs(name, options, handler))
+}
+
+func (l *LocalClient) Events(options container.EventOptions, handler func(event *container.Event) error) error {
+	return wrapError(ErrInternal, container.ReadEvents(options, handler))
+}
+
+func (l *LocalClient) Networks() ([]*network.Network, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return network.Networks(), nil
+}
+
+func (l *LocalClient) CreateNetwork(name string, networkType string, subnet string, out io.Writer) error {
+	if name == "" {
+		return &Error{Kind: ErrInvalid, Message: "缺少网络名称"}
+	}
+	// 未指定网络类型则使用bridge
+	if networkType == "" {
+		networkType = string(network.Bridge)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := network.CreateNetwork(name, network.NetworkType(networkType), subnet, out); err != nil {
+		return wrapError(ErrInternal, fmt.Errorf("网络创建异常: %w", err))
+	}
+	return nil
+}
+
+func (l *LocalClient) RemoveNetwork(name string, out io.Writer) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := network.DistoryNetwork(name, out); err != nil {
+		return wrapError(ErrInternal, fmt.Errorf("网络删除异常: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"fockker/build"
+	"fockker/constants"
+	"fockker/container"
+	"fockker/container/cgroups"
+	"fockker/errdefs"
+	"fockker/image"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// CommitC 将容器层打包为新的镜像层，叠加在容器所用镜像之上生成新镜像
+// 新镜像的配置以原镜像配置为基础，应用changes中的ENV、CMD等变更；pause为true时在打包期间冻结运行中的容器。返回新镜像的ID
+func CommitC(containerName string, ref string, author string, message string, changes []string, pause bool) (string, error) {
+	// 打包期间持有容器的锁，start、restart与rm不会同时挂载或删除容器层
+	unlock, err := container.LockContainer(containerName)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	containerInfo, err := container.GetContainerInfoByName(containerName)
+	if err != nil {
+		return "", errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if err = container.CheckLayerReadable(&containerInfo); err != nil {
+		return "", err
+	}
+	imgName := containerInfo.ImageID
+	if imgName == "" {
+		imgName = containerInfo.Image
+	}
+	parent, err := image.GetImage(imgName)
+	if err != nil {
+		return "", errdefs.NotFound("容器 %s 的镜像 %s 不存在: %v", containerName, imgName, err)
+	}
+	config, err := build.ApplyChanges(parent.Config, changes)
+	if err != nil {
+		return "", err
+	}
+
+	// 冻结运行中的容器，保证打包期间容器层不被修改
+	if pause && containerInfo.Status == container.RUNNING {
+		cgroupManager := cgroups.NewCgroupManager(fmt.Sprintf("%s/%s", constants.AppName, containerName))
+		if err = cgroupManager.Freeze(); err != nil {
+			return "", fmt.Errorf("容器 %s 冻结失败: %v", containerName, err)
+		}
+		defer func() {
+			if err := cgroupManager.Thaw(); err != nil {
+				log.Errorf("容器 %s 解冻失败: %v", containerName, err)
+			}
+		}()
+	}
+	writePath := fmt.Sprintf(container.WriteLayerPath, containerName)
+	layer, err := image.CreateLayerFromDir(writePath)
+	if err != nil {
+		return "", fmt.Errorf("容器层 %s 打包异常: %v", writePath, err)
+	}
+
+	createdBy := "fockker commit " + containerName
+	if len(changes) > 0 {
+		createdBy += " --change " + strings.Join(changes, " --change ")
+	}
+	img := &image.Image{
+		Layers: append(append([]string{}, parent.Layers...), layer),
+		Config: config,
+		Author: author,
+		History: append(append([]image.History{}, parent.History...), image.History{
+			Created:   time.Now().UTC().Format(time.RFC3339),
+			CreatedBy: createdBy,
+			Author:    author,
+			Comment:   message,
+		}),
+	}
+	img, err = image.SaveImage(img, ref)
+	if err != nil {
+		return "", err
+	}
+	return img.ID, nil
+}
@@ -3,5 +3,6 @@ package constants
 const (
 	AppName string = "fockker"
 	Usage   string = `fockker是一个轻量的容器引擎实现`
-	RunPath string = "/var/run/" + AppName
+	RunPath string = "/var/run/" + AppName // 套接字与锁等运行时文件，宿主机重启后不再存在
+	LibPath string = "/var/lib/" + AppName // 容器、网络与事件的记录，宿主机重启后仍然保留
 )
@@ -0,0 +1,254 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"fockker/errdefs"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Broker shim中的标准输入输出代理
+// 持有容器的标准输入输出，将输出写入容器日志并转发给attach的客户端，将客户端的输入转发给容器
+type Broker struct {
+	containerName string
+	stdio         *ContainerIO
+	logger        *ContainerLogger
+	listener      net.Listener
+	resizer       net.Listener // 调整伪终端窗口大小的套接字，未创建终端时为nil
+	mutex         sync.Mutex
+	replay        []byte                     // 最近的输出，客户端连接时首先发送
+	clients       map[*attachClient]struct{} // 已连接的客户端
+	closed        bool                       // 容器输出已结束
+	done          chan struct{}
+}
+
+// 已连接的attach客户端，输出经由缓冲通道异步发送，避免慢客户端阻塞容器输出
+type attachClient struct {
+	conn   net.Conn
+	output chan []byte
+}
+
+// NewBroker 创建容器的标准输入输出代理，按容器的日志驱动创建容器日志，并监听容器运行目录下的attach套接字
+// 创建了终端的容器还监听调整窗口大小的套接字
+func NewBroker(containerInfo *ContainerInfo, stdio *ContainerIO) (*Broker, error) {
+	containerName := containerInfo.Name
+	logger, err := NewContainerLogger(containerInfo)
+	if err != nil {
+		return nil, fmt.Errorf("容器 %s 日志创建异常 %v", containerName, err)
+	}
+	runPath := fmt.Sprintf(DefaultRunPath, containerName)
+	if err = os.MkdirAll(runPath, 0755); err != nil {
+		_ = logger.Close()
+		return nil, fmt.Errorf("容器运行目录 %s 创建异常 %v", runPath, err)
+	}
+	sockPath := runPath + AttachSockName
+	_ = os.Remove(sockPath)
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		_ = logger.Close()
+		return nil, fmt.Errorf("attach套接字 %s 监听异常 %v", sockPath, err)
+	}
+	var resizer net.Listener
+	if stdio.Terminal != nil {
+		resizePath := runPath + ResizeSockName
+		_ = os.Remove(resizePath)
+		if resizer, err = net.Listen("unix", resizePath); err != nil {
+			_ = listener.Close()
+			_ = logger.Close()
+			return nil, fmt.Errorf("resize套接字 %s 监听异常 %v", resizePath, err)
+		}
+	}
+	return &Broker{
+		containerName: containerName,
+		stdio:         stdio,
+		logger:        logger,
+		listener:      listener,
+		resizer:       resizer,
+		clients:       map[*attachClient]struct{}{},
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Run 开始转发容器的输出并接受客户端连接，容器输出结束后断开全部客户端
+func (b *Broker) Run() {
+	go b.accept()
+	if b.resizer != nil {
+		go b.acceptResize()
+	}
+	// 伪终端中标准输出与标准错误无法区分，均记为stdout
+	readers := map[string]io.Reader{}
+	if b.stdio.Terminal != nil {
+		readers[StreamStdout] = b.stdio.Terminal.Master
+	} else {
+		readers[StreamStdout] = b.stdio.Stdout
+		readers[StreamStderr] = b.stdio.Stderr
+	}
+	var wg sync.WaitGroup
+	for stream, reader := range readers {
+		wg.Add(1)
+		go func(stream string, reader io.Reader) {
+			defer wg.Done()
+			buffer := make([]byte, 32*1024)
+			for {
+				// 伪终端在容器进程全部退出后返回EIO，管道返回EOF
+				n, err := reader.Read(buffer)
+				if n > 0 {
+					b.write(stream, buffer[:n])
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(stream, reader)
+	}
+	go func() {
+		wg.Wait()
+		b.mutex.Lock()
+		b.closed = true
+		for client := range b.clients {
+			close(client.output)
+			delete(b.clients, client)
+		}
+		b.mutex.Unlock()
+		close(b.done)
+	}()
+}
+
+// Close 等待容器输出转发完毕（最长timeout），关闭attach套接字与日志文件
+func (b *Broker) Close(timeout time.Duration) {
+	select {
+	case <-b.done:
+	case <-time.After(timeout):
+	}
+	_ = b.listener.Close()
+	if b.resizer != nil {
+		_ = b.resizer.Close()
+	}
+	_ = b.logger.Close()
+}
+
+// 写入容器日志，并发送给全部客户端
+func (b *Broker) write(stream string, data []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.logger.Log(stream, data); err != nil {
+		log.Errorf("容器 %s 日志写入异常 %v", b.containerName, err)
+	}
+	b.replay = append(b.replay, data...)
+	if len(b.replay) > attachReplaySize {
+		b.replay = append([]byte{}, b.replay[len(b.replay)-attachReplaySize:]...)
+	}
+	for client := range b.clients {
+		select {
+		case client.output <- append([]byte{}, data...):
+		default:
+			// 客户端接收过慢，断开连接
+			close(client.output)
+			delete(b.clients, client)
+		}
+	}
+}
+
+// 接受客户端连接，每个客户端首先收到最近的输出，之后收到实时输出
+func (b *Broker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &attachClient{conn: conn, output: make(chan []byte, attachClientBuffer)}
+		b.mutex.Lock()
+		if len(b.replay) > 0 {
+			client.output <- append([]byte{}, b.replay...)
+		}
+		if b.closed {
+			close(client.output)
+		} else {
+			b.clients[client] = struct{}{}
+		}
+		b.mutex.Unlock()
+		go func() {
+			for data := range client.output {
+				if _, err := conn.Write(data); err != nil {
+					break
+				}
+			}
+			_ = conn.Close()
+		}()
+		go b.forwardInput(client)
+	}
+}
+
+// 将客户端的输入转发给容器，客户端断开后移除
+func (b *Broker) forwardInput(client *attachClient) {
+	var input io.Writer = io.Discard
+	if b.stdio.Terminal != nil {
+		input = b.stdio.Terminal.Master
+	} else if b.stdio.Stdin != nil {
+		input = b.stdio.Stdin
+	}
+	_, _ = io.Copy(input, client.conn)
+	b.mutex.Lock()
+	if _, exists := b.clients[client]; exists {
+		close(client.output)
+		delete(b.clients, client)
+	}
+	b.mutex.Unlock()
+}
+
+// 接受调整窗口大小的请求，每个连接一个请求，设置伪终端master端的窗口大小后回复结果
+func (b *Broker) acceptResize() {
+	for {
+		conn, err := b.resizer.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer func() {
+				_ = conn.Close()
+			}()
+			_ = conn.SetDeadline(time.Now().Add(attachDialTimeout))
+			var request resizeMessage
+			reply := shimMessage{}
+			if err := json.NewDecoder(conn).Decode(&request); err != nil {
+				reply.Error = fmt.Sprintf("调整窗口大小的请求解析异常 %v", err)
+			} else if err = b.stdio.Terminal.Resize(request.Height, request.Width); err != nil {
+				reply.Error = fmt.Sprintf("容器 %s 调整伪终端窗口大小异常 %v", b.containerName, err)
+			}
+			_ = json.NewEncoder(conn).Encode(reply)
+		}()
+	}
+}
+
+// DialAttach 连接到运行中容器的attach套接字，连接后首先收到容器最近的输出，之后收到实时输出，写入的内容转发给容器的标准输入
+func DialAttach(containerName string) (net.Conn, error) {
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return nil, errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if containerInfo.Status != RUNNING {
+		return nil, errdefs.Conflict("容器 %s 未运行", containerName)
+	}
+	return dialShim(containerName, AttachSockName)
+}
+
+// 连接shim在容器运行目录下监听的套接字，容器刚启动时shim可能尚未开始监听，短暂重试
+func dialShim(containerName string, sockName string) (net.Conn, error) {
+	sockPath := fmt.Sprintf(DefaultRunPath, containerName) + sockName
+	deadline := time.Now().Add(attachDialTimeout)
+	for {
+		conn, err := net.Dial("unix", sockPath)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("连接容器 %s 异常: %v", containerName, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
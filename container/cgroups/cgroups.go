@@ -6,6 +6,8 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -15,6 +17,9 @@ const (
 	cgroupCPUWeight = "cpu.weight"     // CPU权重文件
 	cgroupCPUMax    = "cpu.max"        // CPU配额文件
 	cgroupCPUSet    = "cpuset.cpus"    // CPU亲和性文件
+	cgroupFreeze    = "cgroup.freeze"  // v2冻结控制文件
+	cgroupEvents    = "cgroup.events"  // v2事件文件，frozen字段表示冻结是否完成
+	cgroupMemEvents = "memory.events"  // v2内存事件文件，oom_kill字段为OOM终止的进程数
 )
 
 type CgroupManager struct {
@@ -124,3 +129,68 @@ func (c *CgroupManager) Destroy() error {
 	}
 	return nil
 }
+
+// OOMKilled 判断cgroup内是否有进程因超出内存限制被终止，需在Destroy之前调用
+func (c *CgroupManager) OOMKilled() bool {
+	content, err := os.ReadFile(path.Join(cgroupRoot, c.Path, cgroupMemEvents))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, _ := strconv.Atoi(fields[1])
+			return count > 0
+		}
+	}
+	return false
+}
+
+// Freeze 冻结cgroup内的全部进程，等待冻结完成
+func (c *CgroupManager) Freeze() error {
+	if err := c.setFrozen("1"); err != nil {
+		return err
+	}
+	fullPath, err := c.getFullPath()
+	if err != nil {
+		return err
+	}
+	// 冻结是异步的，进程全部停止后cgroup.events中的frozen变为1
+	for i := 0; i < 100; i++ {
+		content, err := os.ReadFile(path.Join(fullPath, cgroupEvents))
+		if err != nil {
+			_ = c.setFrozen("0")
+			return err
+		}
+		if strings.Contains(string(content), "frozen 1") {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = c.setFrozen("0")
+	return fmt.Errorf("freeze cgroup %s timeout", c.Path)
+}
+
+// Thaw 解冻cgroup内的全部进程
+func (c *CgroupManager) Thaw() error {
+	return c.setFrozen("0")
+}
+
+func (c *CgroupManager) setFrozen(state string) error {
+	fullPath, err := c.getFullPath()
+	if err != nil {
+		return err
+	}
+	// 不创建文件，cgroup.freeze不存在说明内核不支持v2冻结
+	file, err := os.OpenFile(path.Join(fullPath, cgroupFreeze), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("set cgroup freeze %s failed: %v", state, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if _, err = file.WriteString(state); err != nil {
+		return fmt.Errorf("set cgroup freeze %s failed: %v", state, err)
+	}
+	return nil
+}
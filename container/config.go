@@ -2,36 +2,249 @@ package container
 
 import (
 	"fockker/constants"
+	"fockker/container/cgroups"
+	"fockker/image"
+	"time"
 )
 
 // 容器运行与挂载路径
 var (
 	RootPath       string = "/root"
-	ImgLayerPath   string = RootPath + "/%s"            // 镜像存储路径，%s为镜像名
+	ImgLayerPath   string = RootPath + "/%s"            // 旧格式的镜像路径，%s为镜像名，首次使用时导入镜像存储
 	WriteLayerPath string = RootPath + "/writeLayer/%s" // 容器层文件路径，%s为容器名
 	WorkLayerPath  string = RootPath + "/workLayer/%s"  // 工作目录存储路径，%s为容器名
 	MountPath      string = RootPath + "/mnt/%s"        // 联合挂载点路径，%s为容器名
 )
 
+// fockker可执行文件的路径，容器init进程、shim与exec都通过它再次调用fockker
+// 为空时为当前进程的可执行文件；在其他程序中进程内调用容器模块时需指定为fockker的路径
+var ExecutablePath string = ""
+
 // 容器运行状态与管理路径
 var (
-	DefaultInfoPath string = constants.RunPath + "/%s/"
+	DefaultInfoPath string = constants.LibPath + "/containers/%s/" // 容器信息、日志与健康状态，宿主机重启后保留以便按重启策略恢复
+	DefaultRunPath  string = constants.RunPath + "/containers/%s/" // shim的套接字与容器的锁
 	ConfigName      string = "config.json"
 	LogFileName     string = "container.log"
+	LockFileName    string = "container.lock" // 修改容器状态前加锁的文件，避免stop、start与shim的重启同时修改
+	CREATED         string = "created"        // 已记录容器信息，shim尚未启动容器进程
 	RUNNING         string = "running"
 	STOP            string = "stopped"
 	Exit            string = "exited"
+	RESTARTING      string = "restarting"                      // 容器已退出，按重启策略等待重新启动
+	DetachKeys      string = "ctrl-p,ctrl-q"                   // 默认的终端分离按键
+	AttachSockName  string = "attach.sock"                     // shim监听的attach套接字
+	ResizeSockName  string = "resize.sock"                     // shim监听的调整伪终端窗口大小的套接字，仅创建了终端的容器
+	TimeLayout      string = "2006-01-02 15:04:05"             // 容器创建、退出时间的格式
+	BootIdPath      string = "/proc/sys/kernel/random/boot_id" // 宿主机本次启动的boot id
+	staleExitCode   int    = 255                               // shim异常终止、未能记录退出状态的容器的退出码
+)
+
+// 容器日志相关配置
+var (
+	StreamStdout   string = "stdout"  // 标准输出流
+	StreamStderr   string = "stderr"  // 标准错误流
+	logLineMaxSize int    = 16 * 1024 // 单条日志的最大长度，超过时不再等待换行
+
+	logFollowInterval time.Duration = 200 * time.Millisecond // logs -f 检查新日志的间隔
+
+	LogOptMaxSize       string = "max-size" // 单个日志文件的最大大小，如 10m
+	LogOptMaxFile       string = "max-file" // 保留的日志文件数量，包括当前日志文件
+	LogOptCompress      string = "compress" // 是否gzip压缩轮转后的日志文件
+	compressedLogSuffix string = ".gz"
+)
+
+// 日志驱动与各驱动的选项
+var (
+	LogDriverJSONFile string = "json-file" // 写入容器目录下的日志文件，默认的日志驱动
+	LogDriverNone     string = "none"      // 丢弃容器输出
+	LogDriverSyslog   string = "syslog"    // 以RFC5424格式发送到syslog的unix或udp套接字
+	LogDriverFluentd  string = "fluentd"   // 以fluentd forward协议通过tcp发送
+
+	LogOptMode          string = "mode"            // 写入模式：blocking、non-blocking
+	LogOptMaxBufferSize string = "max-buffer-size" // 非阻塞模式下缓冲的最大大小，如 1m
+	LogOptDropPolicy    string = "drop-policy"     // 非阻塞模式下缓冲已满时的丢弃策略：drop-newest、drop-oldest
+	LogModeBlocking     string = "blocking"        // 日志驱动写入完成后才继续读取容器输出
+	LogModeNonBlocking  string = "non-blocking"    // 容器输出先写入缓冲，由日志驱动异步写入
+	LogDropNewest       string = "drop-newest"     // 丢弃新的日志
+	LogDropOldest       string = "drop-oldest"     // 丢弃缓冲中最早的日志
+
+	LogOptTag            string = "tag"             // syslog的APP-NAME、fluentd的tag，默认为容器名
+	LogOptSyslogAddress  string = "syslog-address"  // unix:///dev/log、udp://host:port
+	LogOptSyslogFacility string = "syslog-facility" // syslog设施，如 daemon、local0
+	LogOptFluentdAddress string = "fluentd-address" // tcp://host:port
+
+	defaultLogBufferSize  int64         = 1 << 20
+	defaultSyslogAddress  string        = "unix:///dev/log"
+	defaultSyslogFacility string        = "daemon"
+	defaultFluentdAddress string        = "tcp://127.0.0.1:24224"
+	logDialTimeout        time.Duration = 5 * time.Second // 远程日志驱动连接与写入的超时时间
+)
+
+// LogEntry json-file格式日志的一行
+type LogEntry struct {
+	Log    string    `json:"log"`    // 输出内容，包含换行
+	Stream string    `json:"stream"` // 输出流，stdout或stderr
+	Time   time.Time `json:"time"`   // 输出时间，RFC3339Nano格式
+}
+
+// attach相关配置
+var (
+	attachReplaySize   int = 64 * 1024 // 客户端连接时回放的最近输出大小
+	attachClientBuffer int = 256       // 每个客户端待发送输出的缓冲数量，超出时断开该客户端
+
+	attachDialTimeout time.Duration = 2 * time.Second // 连接attach套接字的最长等待时间，容器刚启动时shim可能尚未监听
+)
+
+// exec相关配置
+var (
+	execWaitDelay  time.Duration = time.Second                                                                // exec的命令退出后，等待标准输入输出转发完毕的最长时间
+	shellSafeChars string        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-" // 无需引号即可作为shell参数的字符
+)
+
+// 文件复制辅助进程的操作
+var (
+	archiveTar      string = "tar"     // 将容器内的路径打包为tar
+	archiveStat     string = "stat"    // 获取容器内路径的文件模式
+	archiveExtract  string = "extract" // 将tar解压到容器内的目录
+	archiveNotFound int    = 2         // 辅助进程中路径不存在时的退出码，据此返回ErrNotFound
+)
+
+// 停止容器相关配置
+var (
+	DefaultStopTimeout time.Duration = 10 * time.Second       // 发送停止信号后等待容器退出的默认时间，超过后发送SIGKILL
+	stopWaitInterval   time.Duration = 100 * time.Millisecond // 等待容器退出时检查容器状态的间隔
+	stopKillTimeout    time.Duration = 10 * time.Second       // 发送SIGKILL后等待容器退出的最长时间
+	stopRecordTimeout  time.Duration = 5 * time.Second        // 容器进程退出后等待shim记录退出状态的最长时间
+)
+
+// shim相关配置
+var (
+	shimBrokerDrain time.Duration = time.Second // 容器退出后，等待剩余输出写入日志与转发给客户端的最长时间
+)
+
+// 重启策略相关配置
+var (
+	RestartNo            string = "no"             // 不自动重启，默认的重启策略
+	RestartOnFailure     string = "on-failure"     // 退出码不为0时重启，可指定最大重启次数，如 on-failure:3
+	RestartAlways        string = "always"         // 总是重启，通过stop停止后在fockkerd启动时重新启动
+	RestartUnlessStopped string = "unless-stopped" // 总是重启，通过stop停止后不再启动
+
+	restartBackoffBase time.Duration = 100 * time.Millisecond // 首次重启前的等待时间，之后每次重启翻倍
+	restartBackoffMax  time.Duration = time.Minute            // 重启前的最长等待时间
+	restartResetAfter  time.Duration = 10 * time.Second       // 容器运行超过该时间后退出，重启等待时间恢复为初始值
+)
+
+// 健康检查相关配置
+var (
+	HealthStarting  string = "starting"  // 尚未有检查成功，连续失败未达到重试次数
+	HealthHealthy   string = "healthy"   // 最近一次检查成功
+	HealthUnhealthy string = "unhealthy" // 连续失败达到重试次数
+	HealthFileName  string = "health.json"
+
+	HealthTestNone  string = "NONE"      // 禁用镜像的健康检查
+	HealthTestCmd   string = "CMD"       // 之后的各项为命令参数
+	HealthTestShell string = "CMD-SHELL" // 之后为shell命令
+
+	HealthOnFailureNone    string = "none"    // unhealthy时只记录状态
+	HealthOnFailureRestart string = "restart" // unhealthy时终止容器进程并重新启动，通过stop停止的容器除外
+
+	defaultHealthInterval time.Duration = 30 * time.Second
+	defaultHealthTimeout  time.Duration = 30 * time.Second
+	defaultHealthRetries  int           = 3
+	healthLogSize         int           = 5    // 保留的最近检查结果数量
+	healthOutputMaxSize   int           = 4096 // 每次检查保留的最大输出长度
 )
 
+// HealthState 容器的健康状态，由shim写入容器目录下的health.json
+type HealthState struct {
+	Status        string        `json:"status"`        // starting、healthy、unhealthy
+	FailingStreak int           `json:"failingStreak"` // 连续失败的次数
+	Log           []HealthProbe `json:"log"`           // 最近的检查结果，由旧到新
+}
+
+// HealthProbe 一次健康检查的结果
+type HealthProbe struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exitCode"` // 0为成功，超时为-1
+	Output   string    `json:"output"`   // 标准输出与标准错误，超过healthOutputMaxSize时截断
+}
+
+// shim与启动它的进程之间通过管道交换的消息，依次为：shim报告容器进程PID，调用方完成网络连接后回复，shim报告容器已启动
+type shimMessage struct {
+	Pid   int    `json:"pid,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// 调整容器伪终端窗口大小的请求，shim设置伪终端master端的窗口大小后以shimMessage回复
+type resizeMessage struct {
+	Height uint16 `json:"height"`
+	Width  uint16 `json:"width"`
+}
+
 // ContainerInfo 容器状态信息
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`         // 容器的init进程在宿主机上的 PID
-	Id          string   `json:"id"`          // 容器Id
-	Name        string   `json:"name"`        // 容器名
-	Command     string   `json:"command"`     // 容器内init运行命令
-	CreatedTime string   `json:"createTime"`  // 创建时间
-	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 容器的数据卷
-	PortMapping []string `json:"portmapping"` // 端口映射
-	NetworkName string   `json:"networkname"` // 加入的容器网络
+	Pid         string                  `json:"pid"`         // 容器的init进程在宿主机上的 PID
+	Id          string                  `json:"id"`          // 容器Id
+	Name        string                  `json:"name"`        // 容器名
+	Image       string                  `json:"image"`       // 容器使用的镜像名
+	ImageID     string                  `json:"imageId"`     // 容器使用的镜像ID，镜像标签变更后重新启动仍使用原镜像
+	Command     string                  `json:"command"`     // 容器内init运行命令
+	Cmd         []string                `json:"cmd"`         // 容器内init运行命令的参数列表，用于重新启动容器
+	Env         []string                `json:"env"`         // 用户设置的容器环境变量
+	CreatedTime string                  `json:"createTime"`  // 创建时间
+	Status      string                  `json:"status"`      // 容器的状态
+	Volume      string                  `json:"volume"`      // 容器的数据卷
+	PortMapping []string                `json:"portmapping"` // 端口映射
+	NetworkName string                  `json:"networkname"` // 加入的容器网络
+	IPAddress   string                  `json:"ipaddress"`   // 容器在网络中的IP地址，host、none网络为空
+	Resource    *cgroups.ResourceConfig `json:"resource"`    // cgroup资源限制
+	Hostname    string                  `json:"hostname"`    // 容器主机名
+	User        string                  `json:"user"`        // 容器内运行用户
+	WorkingDir  string                  `json:"workingdir"`  // 容器内工作目录
+	Rlimits     []Rlimit                `json:"rlimits"`     // 进程资源限制
+	Tty         bool                    `json:"tty"`         // 是否为容器分配伪终端
+	OpenStdin   bool                    `json:"openStdin"`   // 是否保持容器的标准输入打开
+	LogDriver   string                  `json:"logDriver"`   // 日志驱动，为空时为json-file
+	LogOpts     map[string]string       `json:"logOpts"`     // 日志驱动的选项，如 max-size、max-file
+	ExitCode    int                     `json:"exitCode"`    // 容器进程的退出码，被信号终止时为128+信号值
+	FinishedAt  string                  `json:"finishedAt"`  // 容器进程退出的时间，未退出过时为空
+	OOMKilled   bool                    `json:"oomKilled"`   // 容器进程是否因超出内存限制被终止
+	StartedAt   string                  `json:"startedAt"`   // 容器进程最近一次启动的时间
+	ShimPid     int                     `json:"shimPid"`     // 容器shim进程的PID，fockkerd启动时据此判断容器是否仍在运行
+	BootId      string                  `json:"bootId"`      // 启动shim时宿主机的boot id，宿主机重启后记录的PID失效
+
+	RestartPolicy string `json:"restartPolicy"` // 重启策略：no、on-failure[:N]、always、unless-stopped，为空时为no
+	RestartCount  int    `json:"restartCount"`  // 按重启策略自动重启的次数，通过start启动时清零
+	StopSignal    string `json:"stopSignal"`    // 停止容器时发送的信号，为空时为SIGTERM
+	ManualStop    bool   `json:"manualStop"`    // 已通过stop请求停止，容器退出后为STOP状态且不再重启
+
+	Healthcheck     *image.HealthConfig `json:"healthcheck,omitempty"` // 健康检查配置，未设置时使用镜像的配置
+	HealthOnFailure string              `json:"healthOnFailure"`       // unhealthy时的处理：none、restart，为空时为none
+	Health          *HealthState        `json:"health,omitempty"`      // 健康状态，读取容器信息时从health.json加载，不写入配置文件
+}
+
+// 容器事件相关配置
+var (
+	EventsFileName    string        = "events.log"           // 容器事件记录文件，位于运行状态目录下
+	eventsMaxSize     int64         = 1 << 20                // 事件文件超过该大小时轮转为 events.log.1，只保留一代
+	eventsFollowDelay time.Duration = 200 * time.Millisecond // 持续读取事件时检查新事件的间隔
+
+	EventCreate  string = "create"  // 容器创建
+	EventStart   string = "start"   // 容器启动，包括创建后的首次启动
+	EventStop    string = "stop"    // 通过stop停止容器
+	EventDie     string = "die"     // 容器进程退出，由shim记录
+	EventDestroy string = "destroy" // 容器删除
+	EventExec    string = "exec"    // 在容器中执行命令
+	EventKill    string = "kill"    // 通过kill向容器进程发送信号
+)
+
+// Event 容器生命周期事件，以每行一个JSON对象的格式记录
+type Event struct {
+	Action string    `json:"action"` // create、start、stop、die、destroy、exec、kill
+	Id     string    `json:"id"`     // 容器Id
+	Name   string    `json:"name"`   // 容器名
+	Image  string    `json:"image"`  // 容器使用的镜像名
+	Time   time.Time `json:"time"`   // 事件发生的时间
 }
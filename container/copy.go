@@ -0,0 +1,219 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"fockker/errdefs"
+	"fockker/image"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// SplitContainerPath 解析 container:path 格式的路径，返回容器名与容器内路径
+// 不含 : 或 : 之前包含 / 时视为宿主机路径，容器名为空
+func SplitContainerPath(arg string) (string, string) {
+	name, path, ok := strings.Cut(arg, ":")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", arg
+	}
+	return name, path
+}
+
+// ContainerArchive 将容器内的文件或目录以name为名打包为tar写入writer，name为空时使用路径的最后一级
+// 最后一级为符号链接时打包链接本身；路径不存在时在写入之前返回错误
+func ContainerArchive(containerName string, srcPath string, name string, writer io.Writer) error {
+	if name == "" {
+		name = filepath.Base(filepath.Clean("/" + srcPath))
+		if name == "/" {
+			name = "."
+		}
+	}
+	return runArchiveHelper(containerName, []string{archiveTar, srcPath, name}, nil, writer)
+}
+
+// StatContainerPath 获取容器内路径的文件模式，路径中的符号链接以容器根目录为根解析
+func StatContainerPath(containerName string, path string) (os.FileMode, error) {
+	var output bytes.Buffer
+	if err := runArchiveHelper(containerName, []string{archiveStat, path}, nil, &output); err != nil {
+		return 0, err
+	}
+	mode, err := strconv.ParseUint(output.String(), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("容器 %s 中的路径 %s 的文件信息无效", containerName, path)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ExtractToContainer 将tar解压到容器内已存在的目录dir
+func ExtractToContainer(containerName string, dir string, reader io.Reader) error {
+	return runArchiveHelper(containerName, []string{archiveExtract, dir}, reader, io.Discard)
+}
+
+// 在辅助进程中对容器根目录执行args指定的操作，辅助进程chroot到容器根目录后再解析容器内的路径
+// 路径中的符号链接由内核在容器根目录内解析，容器内的进程同时替换路径中的目录为符号链接也无法指向宿主机上的文件
+// stdin为辅助进程的标准输入，辅助进程的输出写入output
+func runArchiveHelper(containerName string, args []string, stdin io.Reader, output io.Writer) error {
+	root, release, err := openContainerRoot(containerName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	cmd, err := fockkerCommand(append([]string{"archive", root}, args...)...)
+	if err != nil {
+		return fmt.Errorf("获取fockker可执行文件异常 %v", err)
+	}
+	// 标准输出可能混有日志，输出通过3号文件描述符传递
+	outputRead, outputWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("管道创建异常 %v", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+	cmd.ExtraFiles = []*os.File{outputWrite}
+	err = cmd.Start()
+	_ = outputWrite.Close()
+	if err != nil {
+		_ = outputRead.Close()
+		return fmt.Errorf("容器 %s 的文件复制进程启动失败: %v", containerName, err)
+	}
+	_, copyErr := io.Copy(output, outputRead)
+	// 写入output失败时关闭管道，辅助进程随之退出
+	_ = outputRead.Close()
+	if err = cmd.Wait(); err != nil && copyErr == nil {
+		message := strings.TrimSpace(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == archiveNotFound {
+			return errdefs.NotFound("容器 %s: %s", containerName, message)
+		}
+		if message != "" {
+			return fmt.Errorf("容器 %s: %s", containerName, message)
+		}
+		return fmt.Errorf("容器 %s 的文件复制进程异常退出: %v", containerName, err)
+	}
+	return copyErr
+}
+
+// RunArchiveHelper 文件复制的辅助进程，chroot到容器根目录root后执行args指定的操作：
+// tar <path> <name>：将path打包为tar写入3号文件描述符；stat <path>：将path的文件模式写入3号文件描述符；
+// extract <dir>：将标准输入中的tar解压到目录dir。失败时将错误写入标准错误并以1退出
+func RunArchiveHelper(root string, args []string) {
+	output := os.NewFile(3, "archive-output")
+	if err := archiveInRoot(root, args, output); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		if errors.Is(err, errdefs.ErrNotFound) {
+			os.Exit(archiveNotFound)
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func archiveInRoot(root string, args []string, output io.Writer) error {
+	if err := syscall.Chroot(root); err != nil {
+		return fmt.Errorf("切换根目录到 %s 异常 %v", root, err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("切换工作目录异常 %v", err)
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("缺少路径")
+	}
+	path := filepath.Clean("/" + args[1])
+	switch args[0] {
+	case archiveTar:
+		if len(args) < 3 {
+			return fmt.Errorf("缺少打包名称")
+		}
+		if _, err := os.Lstat(path); err != nil {
+			return errdefs.NotFound("路径 %s 不存在", args[1])
+		}
+		return image.TarPath(path, args[2], output)
+	case archiveStat:
+		info, err := os.Stat(path)
+		if err != nil {
+			return errdefs.NotFound("路径 %s 不存在", args[1])
+		}
+		_, err = fmt.Fprintf(output, "%d", info.Mode())
+		return err
+	case archiveExtract:
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			return errdefs.NotFound("目录 %s 不存在", args[1])
+		}
+		return image.ExtractTar(os.Stdin, path)
+	}
+	return fmt.Errorf("不支持的操作 %s", args[0])
+}
+
+// 获取容器根目录在宿主机上的路径，返回路径与释放函数
+// 运行中的容器经由 /proc/<pid>/root 进入其挂载命名空间，可访问数据卷；已停止的容器将镜像层与容器层联合挂载到临时目录
+// 挂载期间持有容器的锁直到释放，start不会同时挂载同一容器层；正在创建或重启中的容器返回ErrConflict
+func openContainerRoot(containerName string) (string, func(), error) {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return "", nil, err
+	}
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		unlock()
+		return "", nil, errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if err = CheckLayerReadable(&containerInfo); err != nil {
+		unlock()
+		return "", nil, err
+	}
+	if containerInfo.Status == RUNNING {
+		unlock()
+		return fmt.Sprintf("/proc/%s/root", containerInfo.Pid), func() {}, nil
+	}
+	mountPath, err := mountContainerRoot(&containerInfo)
+	if err != nil {
+		unlock()
+		return "", nil, err
+	}
+	return mountPath, func() {
+		defer unlock()
+		// 卸载失败时不能删除目录，否则会删除容器层中的文件
+		if err := syscall.Unmount(mountPath, syscall.MNT_DETACH); err != nil {
+			log.Errorf("卸载挂载点 %s 异常 %v", mountPath, err)
+			return
+		}
+		_ = os.Remove(mountPath)
+	}, nil
+}
+
+// 将已停止容器的镜像层与容器层联合挂载到临时目录，返回挂载点
+func mountContainerRoot(containerInfo *ContainerInfo) (string, error) {
+	imgName := containerInfo.ImageID
+	if imgName == "" {
+		imgName = containerInfo.Image
+	}
+	lowerDirs, err := CreateReadOnlyLayer(imgName)
+	if err != nil {
+		return "", err
+	}
+	writePath, err := CreateWriteLayer(containerInfo.Name)
+	if err != nil {
+		return "", err
+	}
+	workPath, err := CreateWorkLayer(containerInfo.Name)
+	if err != nil {
+		return "", err
+	}
+	mountPath, err := os.MkdirTemp("", containerInfo.Name+"-rootfs-")
+	if err != nil {
+		return "", err
+	}
+	if err = CreateMountPoint(lowerDirs, writePath, workPath, mountPath); err != nil {
+		_ = os.Remove(mountPath)
+		return "", err
+	}
+	return mountPath, nil
+}
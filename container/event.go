@@ -0,0 +1,170 @@
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"fockker/constants"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"time"
+)
+
+// EventOptions 读取容器事件的选项
+type EventOptions struct {
+	Follow bool      // 持续输出新的事件
+	Since  time.Time // 只输出该时间之后的事件，为零值时不限制
+	Until  time.Time // 只输出该时间之前的事件，为零值时不限制；持续输出时到达该时间后停止
+	Name   string    // 只输出该容器的事件，为空时不限制
+
+	Done <-chan struct{} // 持续输出时，关闭后停止等待新的事件，为nil时不限制
+}
+
+// 事件文件路径
+func eventsFilePath() string {
+	return constants.LibPath + "/" + EventsFileName
+}
+
+// RecordEvent 记录容器事件，fockkerd、shim与进程内调用的客户端都会追加写入同一个文件
+// 事件只用于观察，记录失败不影响容器操作
+func RecordEvent(containerInfo *ContainerInfo, action string) {
+	event := Event{
+		Action: action,
+		Id:     containerInfo.Id,
+		Name:   containerInfo.Name,
+		Image:  containerInfo.Image,
+		Time:   time.Now().UTC(),
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("容器事件序列化异常 %v", err)
+		return
+	}
+	path := eventsFilePath()
+	if info, err := os.Stat(path); err == nil && info.Size() >= eventsMaxSize {
+		_ = os.Rename(path, path+".1")
+	}
+	if err := os.MkdirAll(constants.LibPath, 0755); err != nil {
+		log.Errorf("容器事件目录创建异常 %v", err)
+		return
+	}
+	// 以追加方式写入一整行，多个进程同时写入时不会交错
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Errorf("容器事件文件 %s 打开异常 %v", path, err)
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		log.Errorf("容器事件写入异常 %v", err)
+	}
+}
+
+// ReadEvents 读取已记录的容器事件，按选项过滤后依次交给handler处理，handler返回错误时停止读取
+// 先读取轮转的上一代事件文件，Follow时持续读取新的事件，直到超过Until或options.Done关闭
+func ReadEvents(options EventOptions, handler func(event *Event) error) error {
+	path := eventsFilePath()
+	if err := readEventFile(path+".1", options, handler); err != nil {
+		return err
+	}
+	if !options.Follow {
+		return readEventFile(path, options, handler)
+	}
+	var file *os.File
+	var reader *bufio.Reader
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}()
+	var pending []byte // 写入尚未完成的行
+	for {
+		if file == nil {
+			opened, err := os.Open(path)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("容器事件文件 %s 打开异常 %v", path, err)
+			}
+			if err == nil {
+				file, reader = opened, bufio.NewReader(opened)
+			}
+		}
+		if reader != nil {
+			line, err := reader.ReadBytes('\n')
+			pending = append(pending, line...)
+			if err == nil {
+				if err = handleEventLine(pending, options, handler); err != nil {
+					return err
+				}
+				pending = nil
+				continue
+			}
+			if err != io.EOF {
+				return fmt.Errorf("容器事件文件 %s 读取异常 %v", path, err)
+			}
+			// 事件文件被轮转后从新文件的开头继续
+			if rotated(file, path) {
+				_ = file.Close()
+				file, reader, pending = nil, nil, nil
+				continue
+			}
+		}
+		if !options.Until.IsZero() && time.Now().After(options.Until) {
+			return nil
+		}
+		select {
+		case <-options.Done:
+			return nil
+		case <-time.After(eventsFollowDelay):
+		}
+	}
+}
+
+// 读取整个事件文件，文件不存在时没有事件
+func readEventFile(path string, options EventOptions, handler func(event *Event) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("容器事件文件 %s 打开异常 %v", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := handleEventLine(line, options, handler); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("容器事件文件 %s 读取异常 %v", path, err)
+		}
+	}
+}
+
+// 解码一行事件并按选项过滤后交给handler，无法解码的行忽略
+func handleEventLine(line []byte, options EventOptions, handler func(event *Event) error) error {
+	var event Event
+	if err := json.Unmarshal(line, &event); err != nil {
+		return nil
+	}
+	if options.Name != "" && event.Name != options.Name && event.Id != options.Name {
+		return nil
+	}
+	if !options.Since.IsZero() && event.Time.Before(options.Since) {
+		return nil
+	}
+	if !options.Until.IsZero() && event.Time.After(options.Until) {
+		return nil
+	}
+	return handler(&event)
+}
@@ -0,0 +1,152 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+	"fockker/errdefs"
+	"net"
+	"strings"
+	"time"
+)
+
+// FluentdLogger fluentd日志驱动，每条日志以forward协议的Message模式通过tcp发送：[tag, time, record]
+// time为EventTime（纳秒精度），record包含log、source、container_name、container_id
+// 首次写入时连接，未写入任何内容即失败时重新连接并重发一次，fluentd未启动不影响容器运行
+type FluentdLogger struct {
+	address       string
+	tag           string
+	containerName string
+	containerID   string
+	conn          net.Conn
+}
+
+// NewFluentdLogger 根据容器信息中的日志选项创建fluentd日志驱动
+func NewFluentdLogger(containerInfo *ContainerInfo) (*FluentdLogger, error) {
+	address, err := parseFluentdAddress(containerInfo.LogOpts[LogOptFluentdAddress])
+	if err != nil {
+		return nil, err
+	}
+	return &FluentdLogger{
+		address:       address,
+		tag:           logTag(containerInfo),
+		containerName: containerInfo.Name,
+		containerID:   containerInfo.Id,
+	}, nil
+}
+
+// 检查fluentd驱动的地址
+func validateFluentdOpts(logOpts map[string]string) error {
+	_, err := parseFluentdAddress(logOpts[LogOptFluentdAddress])
+	return err
+}
+
+// 解析fluentd地址：tcp://host:port 或 host:port，为空时为 127.0.0.1:24224
+func parseFluentdAddress(address string) (string, error) {
+	if address == "" {
+		address = defaultFluentdAddress
+	}
+	if scheme, rest, ok := strings.Cut(address, "://"); ok {
+		if scheme != "tcp" {
+			return "", errdefs.Invalid("不支持的fluentd地址 %s，应为 tcp://host:port", address)
+		}
+		address = rest
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", errdefs.Invalid("fluentd地址格式错误 %s", address)
+	}
+	return address, nil
+}
+
+// Log 发送一条日志
+func (l *FluentdLogger) Log(entry *LogEntry) error {
+	message := appendMsgpackArrayHeader(nil, 3)
+	message = appendMsgpackString(message, l.tag)
+	message = appendMsgpackEventTime(message, entry.Time)
+	message = appendMsgpackMapHeader(message, 4)
+	for _, field := range [][2]string{
+		{"log", strings.TrimSuffix(entry.Log, "\n")},
+		{"source", entry.Stream},
+		{"container_name", l.containerName},
+		{"container_id", l.containerID},
+	} {
+		message = appendMsgpackString(message, field[0])
+		message = appendMsgpackString(message, field[1])
+	}
+	written, err := l.send(message)
+	if err != nil && written == 0 {
+		// 连接已断开（如fluentd重启），重新连接后再发送一次
+		l.disconnect()
+		written, err = l.send(message)
+	}
+	if err != nil {
+		// 已写入部分内容时重新发送会破坏消息流，丢弃该条日志，下次发送时重新连接
+		l.disconnect()
+		if written > 0 {
+			return fmt.Errorf("日志只发送了部分内容，已丢弃: %v", err)
+		}
+	}
+	return err
+}
+
+// Close 关闭与fluentd的连接
+func (l *FluentdLogger) Close() error {
+	l.disconnect()
+	return nil
+}
+
+// 发送消息，返回已写入的字节数
+func (l *FluentdLogger) send(message []byte) (int, error) {
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.address, logDialTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("连接fluentd %s 异常 %v", l.address, err)
+		}
+		l.conn = conn
+	}
+	_ = l.conn.SetWriteDeadline(time.Now().Add(logDialTimeout))
+	return l.conn.Write(message)
+}
+
+func (l *FluentdLogger) disconnect() {
+	if l.conn != nil {
+		_ = l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// 以下为forward协议所需的最小msgpack编码
+
+func appendMsgpackArrayHeader(buffer []byte, n int) []byte {
+	if n < 16 {
+		return append(buffer, 0x90|byte(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buffer, 0xdd), uint32(n))
+}
+
+func appendMsgpackMapHeader(buffer []byte, n int) []byte {
+	if n < 16 {
+		return append(buffer, 0x80|byte(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buffer, 0xdf), uint32(n))
+}
+
+func appendMsgpackString(buffer []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buffer = append(buffer, 0xa0|byte(n))
+	case n < 1<<8:
+		buffer = append(buffer, 0xd9, byte(n))
+	case n < 1<<16:
+		buffer = binary.BigEndian.AppendUint16(append(buffer, 0xda), uint16(n))
+	default:
+		buffer = binary.BigEndian.AppendUint32(append(buffer, 0xdb), uint32(n))
+	}
+	return append(buffer, s...)
+}
+
+// EventTime为扩展类型0，依次为32位的秒与纳秒
+func appendMsgpackEventTime(buffer []byte, t time.Time) []byte {
+	buffer = append(buffer, 0xd7, 0x00)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(t.Unix()))
+	return binary.BigEndian.AppendUint32(buffer, uint32(t.Nanosecond()))
+}
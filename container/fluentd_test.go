@@ -0,0 +1,173 @@
+package container
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// forward协议Message模式的一条消息：[tag, time, record]
+type fluentdMessage struct {
+	tag    string
+	time   time.Time
+	record map[string]string
+}
+
+// 按FluentdLogger使用的msgpack子集解码一条消息
+func readFluentdMessage(reader *bufio.Reader) (*fluentdMessage, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if header != 0x93 {
+		return nil, fmt.Errorf("消息应为3个元素的数组，实际为 %#x", header)
+	}
+	message := &fluentdMessage{record: map[string]string{}}
+	if message.tag, err = readMsgpackString(reader); err != nil {
+		return nil, err
+	}
+	// EventTime：fixext8，扩展类型0，依次为32位的秒与纳秒
+	eventTime := make([]byte, 10)
+	if _, err = io.ReadFull(reader, eventTime); err != nil {
+		return nil, err
+	}
+	if eventTime[0] != 0xd7 || eventTime[1] != 0x00 {
+		return nil, fmt.Errorf("时间应为EventTime，实际为 %#x %#x", eventTime[0], eventTime[1])
+	}
+	message.time = time.Unix(int64(binary.BigEndian.Uint32(eventTime[2:6])), int64(binary.BigEndian.Uint32(eventTime[6:10])))
+	if header, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+	if header&0xf0 != 0x80 {
+		return nil, fmt.Errorf("record应为map，实际为 %#x", header)
+	}
+	for i := 0; i < int(header&0x0f); i++ {
+		key, err := readMsgpackString(reader)
+		if err != nil {
+			return nil, err
+		}
+		if message.record[key], err = readMsgpackString(reader); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+func readMsgpackString(reader *bufio.Reader) (string, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case header&0xe0 == 0xa0:
+		n = int(header & 0x1f)
+	case header == 0xd9:
+		size, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n = int(size)
+	case header == 0xda:
+		size := make([]byte, 2)
+		if _, err = io.ReadFull(reader, size); err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint16(size))
+	case header == 0xdb:
+		size := make([]byte, 4)
+		if _, err = io.ReadFull(reader, size); err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint32(size))
+	default:
+		return "", fmt.Errorf("应为字符串，实际为 %#x", header)
+	}
+	content := make([]byte, n)
+	_, err = io.ReadFull(reader, content)
+	return string(content), err
+}
+
+func TestFluentdForward(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	logger, err := NewFluentdLogger(&ContainerInfo{
+		Name:    "web",
+		Id:      "1234567890",
+		LogOpts: map[string]string{LogOptFluentdAddress: "tcp://" + listener.Addr().String(), LogOptTag: "docker.web"},
+	})
+	if err != nil {
+		t.Fatalf("创建fluentd日志驱动失败: %v", err)
+	}
+	defer logger.Close()
+
+	long := make([]byte, 300)
+	for i := range long {
+		long[i] = 'x'
+	}
+	entries := []*LogEntry{
+		{Log: "hello\n", Stream: StreamStdout, Time: time.Date(2024, 5, 1, 8, 30, 0, 123456789, time.UTC)},
+		{Log: string(long) + "\n", Stream: StreamStderr, Time: time.Date(2024, 5, 1, 8, 30, 1, 0, time.UTC)},
+	}
+	messages := make(chan *fluentdMessage, len(entries))
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for range entries {
+			message, err := readFluentdMessage(reader)
+			if err != nil {
+				errs <- err
+				return
+			}
+			messages <- message
+		}
+	}()
+	for _, entry := range entries {
+		if err = logger.Log(entry); err != nil {
+			t.Fatalf("发送日志失败: %v", err)
+		}
+	}
+	for _, entry := range entries {
+		var message *fluentdMessage
+		select {
+		case message = <-messages:
+		case err = <-errs:
+			t.Fatalf("解码消息失败: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("等待日志超时")
+		}
+		if message.tag != "docker.web" {
+			t.Errorf("tag为 %q，应为 docker.web", message.tag)
+		}
+		if !message.time.Equal(entry.Time) {
+			t.Errorf("时间为 %v，应为 %v", message.time, entry.Time)
+		}
+		expected := map[string]string{
+			"log":            entry.Log[:len(entry.Log)-1],
+			"source":         entry.Stream,
+			"container_name": "web",
+			"container_id":   "1234567890",
+		}
+		if len(message.record) != len(expected) {
+			t.Errorf("record为 %v，应为 %v", message.record, expected)
+		}
+		for key, value := range expected {
+			if message.record[key] != value {
+				t.Errorf("record中 %s 为 %q，应为 %q", key, message.record[key], value)
+			}
+		}
+	}
+}
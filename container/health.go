@@ -0,0 +1,228 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"fockker/errdefs"
+	"fockker/image"
+	"fockker/ioutils"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ParseHealthOnFailure 检查unhealthy时的处理方式，为空时为none
+func ParseHealthOnFailure(action string) (string, error) {
+	switch action {
+	case "", HealthOnFailureNone:
+		return HealthOnFailureNone, nil
+	case HealthOnFailureRestart:
+		return action, nil
+	}
+	return "", errdefs.Invalid("不支持的unhealthy处理方式 %s", action)
+}
+
+// MergeHealthConfig 合并用户与镜像的健康检查配置，用户未设置的项使用镜像的配置
+// 合并后没有检查命令或为NONE时返回nil，表示不检查
+func MergeHealthConfig(config *image.HealthConfig, imageConfig *image.HealthConfig) *image.HealthConfig {
+	merged := image.HealthConfig{}
+	if config != nil {
+		merged = *config
+	}
+	if imageConfig != nil {
+		if len(merged.Test) == 0 {
+			merged.Test = imageConfig.Test
+		}
+		if merged.Interval == 0 {
+			merged.Interval = imageConfig.Interval
+		}
+		if merged.Timeout == 0 {
+			merged.Timeout = imageConfig.Timeout
+		}
+		if merged.Retries == 0 {
+			merged.Retries = imageConfig.Retries
+		}
+	}
+	if _, ok := healthCommand(merged.Test); !ok {
+		return nil
+	}
+	return &merged
+}
+
+// 健康检查在容器中以shell执行的命令，没有检查命令或为NONE时返回false
+// CMD-SHELL的命令原样交给shell，CMD的参数逐个转义，保留参数的边界
+func healthCommand(test []string) (string, bool) {
+	if len(test) < 2 {
+		return "", false
+	}
+	switch test[0] {
+	case HealthTestShell:
+		return strings.Join(test[1:], " "), true
+	case HealthTestCmd:
+		return shellQuote(test[1:]), true
+	}
+	return "", false
+}
+
+// 读取容器的健康状态，未设置健康检查或尚未检查时返回nil
+func readHealth(containerName string) *HealthState {
+	content, err := os.ReadFile(fmt.Sprintf(DefaultInfoPath, containerName) + HealthFileName)
+	if err != nil {
+		return nil
+	}
+	var state HealthState
+	if err = json.Unmarshal(content, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+// 记录容器的健康状态
+func writeHealth(containerName string, state *HealthState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutils.WriteFileAtomic(fmt.Sprintf(DefaultInfoPath, containerName)+HealthFileName, bytes.NewReader(content))
+}
+
+// 在shim中按间隔执行健康检查，直到done关闭
+// 连续失败达到重试次数时为unhealthy，此时HealthOnFailure为restart则调用onUnhealthy，之后不再检查
+func monitorHealth(containerInfo *ContainerInfo, done <-chan struct{}, onUnhealthy func()) {
+	config := *containerInfo.Healthcheck
+	if config.Interval <= 0 {
+		config.Interval = defaultHealthInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultHealthTimeout
+	}
+	if config.Retries <= 0 {
+		config.Retries = defaultHealthRetries
+	}
+	action, _ := ParseHealthOnFailure(containerInfo.HealthOnFailure)
+	state := &HealthState{Status: HealthStarting}
+	if err := writeHealth(containerInfo.Name, state); err != nil {
+		log.Errorf("容器 %s 的健康状态记录异常 %v", containerInfo.Name, err)
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(config.Interval):
+		}
+		probe := runHealthProbe(containerInfo, &config)
+		select {
+		case <-done:
+			// 容器已退出，检查结果无意义
+			return
+		default:
+		}
+		state.Log = append(state.Log, probe)
+		if len(state.Log) > healthLogSize {
+			state.Log = state.Log[len(state.Log)-healthLogSize:]
+		}
+		if probe.ExitCode == 0 {
+			state.Status = HealthHealthy
+			state.FailingStreak = 0
+		} else {
+			state.FailingStreak++
+			if state.FailingStreak >= config.Retries {
+				state.Status = HealthUnhealthy
+			}
+		}
+		if err := writeHealth(containerInfo.Name, state); err != nil {
+			log.Errorf("容器 %s 的健康状态记录异常 %v", containerInfo.Name, err)
+		}
+		if state.Status == HealthUnhealthy && action == HealthOnFailureRestart {
+			onUnhealthy()
+			return
+		}
+	}
+}
+
+// 通过nsenter在容器中执行一次健康检查，超时时终止检查命令
+// 检查命令为shim的子进程，由shim的回收循环回收，因此通过管道读取输出而不调用cmd.Wait
+func runHealthProbe(containerInfo *ContainerInfo, config *image.HealthConfig) HealthProbe {
+	probe := HealthProbe{Start: time.Now()}
+	fail := func(err error) HealthProbe {
+		probe.End, probe.ExitCode, probe.Output = time.Now(), -1, err.Error()
+		return probe
+	}
+	cmdStr, _ := healthCommand(config.Test)
+	cmd, err := execCommand(containerInfo, cmdStr)
+	if err != nil {
+		return fail(err)
+	}
+	outputRead, outputWrite, err := os.Pipe()
+	if err != nil {
+		return fail(fmt.Errorf("管道创建异常 %v", err))
+	}
+	cmd.Stdout = outputWrite
+	cmd.Stderr = outputWrite
+	// 检查命令与其在容器中启动的shell位于同一进程组，超时时一并终止
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	exited, err := startReaped(cmd)
+	_ = outputWrite.Close()
+	if err != nil {
+		_ = outputRead.Close()
+		return fail(err)
+	}
+	output := &limitedBuffer{max: healthOutputMaxSize}
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(output, outputRead)
+		_ = outputRead.Close()
+		close(copied)
+	}()
+
+	var status syscall.WaitStatus
+	timedOut := false
+	select {
+	case status = <-exited:
+	case <-time.After(config.Timeout):
+		timedOut = true
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+	// 检查命令在容器中启动的后台进程可能一直持有管道，不等待其结束
+	select {
+	case <-copied:
+	case <-time.After(execWaitDelay):
+	}
+	probe.End = time.Now()
+	if timedOut {
+		probe.ExitCode = -1
+		probe.Output = fmt.Sprintf("健康检查超过 %v 未完成", config.Timeout)
+		return probe
+	}
+	probe.ExitCode = exitCode(status)
+	probe.Output = output.String()
+	return probe
+}
+
+// 只保留前max字节的输出，超出的部分丢弃；可在写入的同时读取
+type limitedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+	max   int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if remain := l.max - l.buf.Len(); remain > 0 {
+		l.buf.Write(p[:min(len(p), remain)])
+	}
+	return len(p), nil
+}
+
+func (l *limitedBuffer) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.String()
+}
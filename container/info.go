@@ -1,33 +1,38 @@
 package container
 
 import (
+	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"fockker/errdefs"
+	"fockker/image"
+	"fockker/ioutils"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"math/rand"
 	"os"
-	"strconv"
 	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
 )
 
-// ListContainers 获取配置路径下的全部容器信息
-func ListContainers() {
+// GetContainers 获取配置路径下的全部容器信息，RUNNING状态但shim已不存在的容器更新为已退出
+func GetContainers() ([]*ContainerInfo, error) {
 	dirPath := fmt.Sprintf(DefaultInfoPath, "")
 	dirPath = dirPath[:len(dirPath)-1] // 去掉最后的/斜杠
 	files, err := os.ReadDir(dirPath)  // 读取该路径下所有文件
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
-		log.Errorf("读取目录 %s 异常 %v", dirPath, err)
-		return
+		return nil, fmt.Errorf("读取目录 %s 异常 %v", dirPath, err)
 	}
 
 	// 保存容器信息
 	var containers []*ContainerInfo
 	for _, file := range files {
-		if file.Name() == "network" {
+		if !file.IsDir() {
 			continue
 		}
 		// 根据fileInfo读取文件，获取所有container信息
@@ -36,44 +41,64 @@ func ListContainers() {
 			log.Errorf("获取容器信息异常 %v", err)
 			continue
 		}
-		// 检查RUNNING状态的容器是否仍然存活
-		if tmpContainer.Status == RUNNING {
-			// 不存活则删除
-			pid, _ := strconv.Atoi(tmpContainer.Pid)
-			err = syscall.Kill(pid, 0)
-			if errors.Is(err, syscall.ESRCH) {
-				// 返回的错误是os.ErrProcess, 表示进程不存在
-				tmpContainer.Status = STOP
-				err = UpdateContainerInfoByName(tmpContainer)
-				if err != nil {
-					log.Errorf("更新容器%s信息异常 %v", tmpContainer.Name, err)
-					continue
-				}
-				RemoveContainer(tmpContainer.Name)
-				continue
-			}
+		// 检查RUNNING状态的容器的shim是否仍然存活，shim被强制终止或宿主机重启后无法记录容器的退出
+		if tmpContainer.Status == RUNNING && !shimAlive(tmpContainer) {
+			// 不存活则清理并标记为已退出，保留容器层以便通过start重新启动
+			tmpContainer = sweepStaleContainer(tmpContainer)
 		}
 		// 添加到containers
 		containers = append(containers, tmpContainer)
 	}
+	return containers, nil
+}
 
-	// 格式化输出
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	_, err = fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+// PrintContainers 以表格格式输出容器信息
+func PrintContainers(writer io.Writer, containers []*ContainerInfo) {
+	w := tabwriter.NewWriter(writer, 12, 1, 3, ' ', 0)
+	_, err := fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tRESTARTS\tNETWORK\tIP\tCOMMAND\tCREATED\n")
 	for _, item := range containers {
-		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		// host、none网络的容器没有独立IP
+		ipAddress := item.IPAddress
+		if ipAddress == "" {
+			ipAddress = "-"
+		}
+		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
 			item.Id,
 			item.Name,
 			item.Pid,
-			item.Status,
+			statusText(item),
+			item.RestartCount,
+			item.NetworkName,
+			ipAddress,
 			item.Command,
 			item.CreatedTime)
 	}
+	if err != nil {
+		log.Errorf("容器信息输出异常 %v", err)
+	}
 	if err := w.Flush(); err != nil {
 		log.Errorf("容器信息刷写异常 %v", err)
 	}
 }
 
+// 容器状态的显示文本，设置了健康检查的运行中容器附带健康状态
+// shim记录了退出状态的容器附带退出码，因OOM被终止时注明
+func statusText(containerInfo *ContainerInfo) string {
+	if containerInfo.Status == RUNNING {
+		if containerInfo.Healthcheck != nil && containerInfo.Health != nil {
+			return fmt.Sprintf("%s (%s)", containerInfo.Status, containerInfo.Health.Status)
+		}
+		return containerInfo.Status
+	}
+	if containerInfo.FinishedAt == "" {
+		return containerInfo.Status
+	}
+	if containerInfo.OOMKilled {
+		return fmt.Sprintf("%s (%d, OOM)", containerInfo.Status, containerInfo.ExitCode)
+	}
+	return fmt.Sprintf("%s (%d)", containerInfo.Status, containerInfo.ExitCode)
+}
+
 // getContainerInfo 获取容器信息
 func getContainerInfo(entry os.DirEntry) (*ContainerInfo, error) {
 	containerName := entry.Name()
@@ -90,36 +115,25 @@ func getContainerInfo(entry os.DirEntry) (*ContainerInfo, error) {
 		log.Errorf("JSON反序列化异常 %v", err)
 		return nil, err
 	}
+	containerInfo.Health = readHealth(containerName)
 
 	return &containerInfo, nil
 }
 
-// RecordContainerInfo 记录容器信息，启用于容器创建时
-func RecordContainerInfo(containerPID int, cmdArry []string, containerName string, volume string, networkName string, portMapping []string) (*ContainerInfo, error) {
-	// 不指定容器名则使用ID作为容器名
-	containerID := generateContainerID(10)
-	if containerName == "" {
-		containerName = containerID
-	}
+// RecordContainerInfo 记录容器信息，启用于容器创建时，此时容器进程尚未启动，状态为CREATED
+// containerInfo中需已填写容器ID、容器名以及用户的运行参数
+func RecordContainerInfo(containerInfo *ContainerInfo) error {
 	// 初始化容器状态信息
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(cmdArry, " ")
-	containerInfo := &ContainerInfo{
-		Id:          containerID,
-		Pid:         strconv.Itoa(containerPID),
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      RUNNING,
-		Name:        containerName,
-		Volume:      volume,
-		NetworkName: networkName,
-		PortMapping: portMapping,
-	}
+	containerInfo.Pid = "-"
+	containerInfo.Command = strings.Join(containerInfo.Cmd, " ")
+	containerInfo.CreatedTime = time.Now().Format(TimeLayout)
+	containerInfo.Status = CREATED
+	containerName := containerInfo.Name
 	// 序列化容器状态信息
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
 		log.Errorf("序列化容器状态信息异常 %v", err)
-		return &ContainerInfo{}, err
+		return err
 	}
 	containerJsonInfo := string(jsonBytes) // 保存为JSON格式字符
 
@@ -127,7 +141,7 @@ func RecordContainerInfo(containerPID int, cmdArry []string, containerName strin
 	dirPath := fmt.Sprintf(DefaultInfoPath, containerName)
 	if err := os.MkdirAll(dirPath, 0622); err != nil {
 		log.Errorf("配置路径 %s 创建异常 %v", dirPath, err)
-		return &ContainerInfo{}, err
+		return err
 	}
 	configPath := dirPath + "/" + ConfigName
 	configFile, err := os.Create(configPath)
@@ -142,19 +156,52 @@ func RecordContainerInfo(containerPID int, cmdArry []string, containerName strin
 	// error handle
 	if err != nil {
 		log.Errorf("创建容器状态文件 %s 异常 %v", configPath, err)
-		return &ContainerInfo{}, err
+		return err
 	}
 	// 写入配置文件
 	if _, err := configFile.WriteString(containerJsonInfo); err != nil {
 		log.Errorf("写入容器状态信息异常 %v", err)
-		return &ContainerInfo{}, err
+		return err
 	}
+	return nil
+}
 
-	// 返回容器名、容器ID、err
-	return containerInfo, nil
+// ImageUsers 获取全部容器使用的镜像ID与容器名的对应关系，用于判断镜像能否删除
+func ImageUsers() map[string][]string {
+	users := map[string][]string{}
+	dirPath := fmt.Sprintf(DefaultInfoPath, "")
+	files, err := os.ReadDir(dirPath[:len(dirPath)-1])
+	if err != nil {
+		return users
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		containerInfo, err := getContainerInfo(file)
+		if err != nil {
+			continue
+		}
+		imageID := containerInfo.ImageID
+		// 早期版本创建的容器未记录镜像ID，按镜像名查找
+		if imageID == "" {
+			img, err := image.GetImage(containerInfo.Image)
+			if err != nil {
+				continue
+			}
+			imageID = img.ID
+		}
+		users[imageID] = append(users[imageID], containerInfo.Name)
+	}
+	return users
 }
 
-// 生成容器ID
+// GenerateContainerID 生成容器ID
+func GenerateContainerID() string {
+	return generateContainerID(10)
+}
+
+// 生成指定长度的随机数字ID
 func generateContainerID(n int) string {
 	letterBytes := "1234567890"
 	rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -178,6 +225,7 @@ func GetContainerInfoByName(containerName string) (ContainerInfo, error) {
 	if err := json.Unmarshal(contentBytes, &containerInfo); err != nil {
 		return ContainerInfo{}, err
 	}
+	containerInfo.Health = readHealth(containerName)
 	return containerInfo, nil
 }
 
@@ -186,16 +234,76 @@ func UpdateContainerInfoByName(newContainerInfo *ContainerInfo) error {
 	// 拼接完整的配置文件路径
 	configFilePath := fmt.Sprintf(DefaultInfoPath, newContainerInfo.Name) + ConfigName
 
-	// 将更新后的结构体转换成 JSON 格式
-	updatedContentBytes, err := json.Marshal(newContainerInfo)
+	// 将更新后的结构体转换成 JSON 格式，健康状态由shim单独记录
+	stored := *newContainerInfo
+	stored.Health = nil
+	updatedContentBytes, err := json.Marshal(&stored)
 
 	if err != nil {
 		return err // 返回错误
 	}
-	// 将更新后的内容写回到配置文件
-	if err = os.WriteFile(configFilePath, updatedContentBytes, 0644); err != nil {
-		return err // 返回错误
+	// 先写入临时文件再重命名，shim与fockkerd同时读写时不会读到不完整的配置
+	return ioutils.WriteFileAtomic(configFilePath, bytes.NewReader(updatedContentBytes))
+}
+
+// 对容器加锁，检查容器状态并据此修改的过程需在锁内完成，返回解锁函数
+// 锁为容器运行目录下文件的flock，在fockkerd、shim等进程之间互斥；同一进程中不能重复加锁
+func lockContainer(containerName string) (func(), error) {
+	if _, err := os.Stat(fmt.Sprintf(DefaultInfoPath, containerName)); err != nil {
+		return nil, errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	// 运行目录在宿主机重启后不再存在，加锁时重新创建
+	runPath := fmt.Sprintf(DefaultRunPath, containerName)
+	if err := os.MkdirAll(runPath, 0755); err != nil {
+		return nil, fmt.Errorf("容器运行目录 %s 创建异常 %v", runPath, err)
+	}
+	lockFile, err := os.OpenFile(runPath+LockFileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("容器 %s 加锁异常 %v", containerName, err)
 	}
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		_ = lockFile.Close()
+		return nil, fmt.Errorf("容器 %s 加锁异常 %v", containerName, err)
+	}
+	// 关闭文件即释放锁
+	return func() { _ = lockFile.Close() }, nil
+}
 
+// LockContainer 对容器加锁，供容器模块之外需要在锁内读取容器层的操作（如commit）使用，返回解锁函数
+func LockContainer(containerName string) (func(), error) {
+	return lockContainer(containerName)
+}
+
+// CheckLayerReadable 检查容器层能否读取，正在创建或重启中的容器层可能正被挂载或清理，返回ErrConflict；需在容器的锁内调用
+func CheckLayerReadable(containerInfo *ContainerInfo) error {
+	switch containerInfo.Status {
+	case RUNNING, STOP, Exit:
+		return nil
+	}
+	return errdefs.Conflict("容器 %s 状态为%s，无法读取容器层", containerInfo.Name, containerInfo.Status)
+}
+
+// 创建容器的信息目录以占用容器名，同时创建同名的容器时只有一方成功，其余返回ErrConflict
+func claimContainerName(containerName string) error {
+	dirPath := fmt.Sprintf(DefaultInfoPath, containerName)
+	if err := os.MkdirAll(fmt.Sprintf(DefaultInfoPath, ""), 0755); err != nil {
+		return fmt.Errorf("配置路径 %s 创建异常 %v", dirPath, err)
+	}
+	if err := os.Mkdir(dirPath, 0622); err != nil {
+		if os.IsExist(err) {
+			return errdefs.Conflict("容器 %s 创建失败: 该名称已存在", containerName)
+		}
+		return fmt.Errorf("配置路径 %s 创建异常 %v", dirPath, err)
+	}
+	return nil
+}
+
+// RemoveContainerDirs 删除容器的信息目录与运行目录
+func RemoveContainerDirs(containerName string) error {
+	for _, dirPath := range []string{fmt.Sprintf(DefaultInfoPath, containerName), fmt.Sprintf(DefaultRunPath, containerName)} {
+		if err := os.RemoveAll(dirPath); err != nil {
+			return fmt.Errorf("删除容器目录 %s 异常 %v", dirPath, err)
+		}
+	}
 	return nil
 }
@@ -2,8 +2,8 @@ package container
 
 import (
 	"fmt"
+	"fockker/image"
 	log "github.com/sirupsen/logrus"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -11,42 +11,56 @@ import (
 	"syscall"
 )
 
-// NewContainerProcess 创建容器进程
-func NewContainerProcess(imgName string, containerName string, createTTY bool, volume string, envSlice []string) (*exec.Cmd, *os.File) {
+// 获取fockker可执行文件的路径
+func executable() (string, error) {
+	if ExecutablePath != "" {
+		return ExecutablePath, nil
+	}
+	return os.Readlink("/proc/self/exe")
+}
+
+// 构造再次调用fockker的命令，args为子命令及其参数
+// 传递镜像存储根目录，shim、容器init进程等与当前进程使用同一镜像存储
+func fockkerCommand(args ...string) (*exec.Cmd, error) {
+	exePath, err := executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(exePath, append([]string{"--image-root=" + image.StoreRoot}, args...)...), nil
+}
+
+// NewContainerProcess 创建容器进程，hostNetwork为true时容器与宿主机共享网络栈
+// 容器内的命令、环境变量等通过返回的write管道以InitSpec发送；同时返回容器标准输入输出在宿主机一侧的端点
+// createTTY为true时为容器分配伪终端，否则使用管道，openStdin为true时保持容器的标准输入打开
+func NewContainerProcess(imgName string, containerName string, createTTY bool, openStdin bool, volume string, hostNetwork bool) (*exec.Cmd, *os.File, *ContainerIO) {
 	// 容器进程与宿主机进程通过管道互相传递参数。容器读，宿主写
 	readPipe, writePipe, err := os.Pipe()
 	if err != nil {
 		log.Errorf("管道创建异常 %v", err)
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	initCmd, err := os.Readlink("/proc/self/exe")
+	// 创建容器进程
+	cmd, err := fockkerCommand("init") // 再调用fockker自身并传递init，启动容器应用的运行
 	if err != nil {
 		log.Errorf("获取初始化进程异常 %v", err)
-		return nil, nil
+		return nil, nil, nil
+	}
+	cloneflags := syscall.CLONE_NEWUTS | // 主机名与域名隔离；隔离hostname 和 domainname
+		syscall.CLONE_NEWPID | // PID进程隔离；独立PID空间
+		syscall.CLONE_NEWIPC | // 消息队列隔离；隔离System V IPC 或 POSIX
+		syscall.CLONE_NEWNS // 挂载命名空间隔离；mount挂载视图独立
+	if !hostNetwork {
+		cloneflags |= syscall.CLONE_NEWNET // 网络命名空间隔离；网络设备隔离
 	}
-
-	// 创建容器进程
-	cmd := exec.Command(initCmd, "init") // 通过/proc/self/exe再调用自身并传递init，启动容器应用的运行
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | // 主机名与域名隔离；隔离hostname 和 domainname
-			syscall.CLONE_NEWNET | // 网络命名空间隔离；网络设备隔离
-			syscall.CLONE_NEWPID | // PID进程隔离；独立PID空间
-			syscall.CLONE_NEWIPC | // 消息队列隔离；隔离System V IPC 或 POSIX
-			syscall.CLONE_NEWNS, // 挂载命名空间隔离；mount挂载视图独立
-	}
-	if createTTY {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		// 给后台运行的容器定义日志输出路径
-		stdLogFile, err := CreateLogFile(containerName)
-		if err != nil {
-			log.Errorf("日志文件 %s 创建异常 %v", containerName, err)
-			return nil, nil
-		}
-		cmd.Stdout = stdLogFile
+		Cloneflags: uintptr(cloneflags),
+	}
+	// 容器以伪终端的slave端作为控制终端，支持作业控制与窗口大小调整；不创建终端时通过管道输出，由shim写入日志
+	stdio, err := NewContainerIO(cmd, createTTY, openStdin)
+	if err != nil {
+		log.Errorf("容器 %s 标准输入输出创建异常 %v", containerName, err)
+		return nil, nil, nil
 	}
 
 	// 容器内通过额外的文件描述符去访问这个read管道；一般文件的描述符有3个，这里手动添加了一个
@@ -55,29 +69,77 @@ func NewContainerProcess(imgName string, containerName string, createTTY bool, v
 	err = NewWorkSpace(imgName, containerName, volume)
 	if err != nil {
 		// 方法内层会抛出对应error
-		return nil, nil
+		stdio.Close()
+		return nil, nil, nil
 	}
 	// 即使通过 pivotRoot 切换了根文件系统，进程的“当前工作目录”仍是挂载命名空间内的路径。
 	// 如果未设置 cmd.Dir，进程可能仍在宿主机的文件系统上下文中操作而导致挂载/proc引发`no such file or directory`
 	cmd.Dir = fmt.Sprintf(MountPath, containerName)
-	cmd.Env = append(os.Environ(), envSlice...)
-	return cmd, writePipe
+	return cmd, writePipe, stdio
 }
 
 // RunContainerInitProcess 初始化容器进程
 func RunContainerInitProcess() error {
-	cmdArry := readCommand()
-	if cmdArry == nil || len(cmdArry) == 0 {
+	spec, err := readInitSpec()
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	if len(spec.Args) == 0 {
 		return fmt.Errorf(`运行容器参数时异常, command参数为空`)
 	}
 
-	err := setupMount()
+	err = setupMount(spec.Mounts)
 	if err != nil {
 		log.Errorf("%v", err)
 		return err
 	}
+	if spec.Hostname != "" {
+		if err = syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+			log.Errorf("设置主机名异常 %v", err)
+			return err
+		}
+	}
+	// 解析运行用户需要读取容器内的/etc/passwd，因此在pivotRoot之后进行
+	execUser, err := LookupUser("/", spec.User)
+	if err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	if spec.Cwd != "" {
+		// 工作目录不存在时自动创建
+		if err = os.MkdirAll(spec.Cwd, 0755); err != nil {
+			log.Errorf("工作目录 %s 创建异常 %v", spec.Cwd, err)
+			return err
+		}
+		if err = syscall.Chdir(spec.Cwd); err != nil {
+			log.Errorf("切换工作目录 %s 异常 %v", spec.Cwd, err)
+			return err
+		}
+	}
+	// 使用规格中的环境变量替换init进程的环境变量，LookPath将基于新的PATH查找命令
+	os.Clearenv()
+	env := spec.Env
+	if spec.User != "" {
+		env = MergeEnv([]string{"HOME=" + execUser.Home}, env)
+	}
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		_ = os.Setenv(key, value)
+	}
+	// 提升hard limit需要root权限，因此在切换用户前设置
+	if err = setRlimits(spec.Rlimits); err != nil {
+		log.Errorf("%v", err)
+		return err
+	}
+	if spec.User != "" {
+		if err = setUser(execUser); err != nil {
+			log.Errorf("%v", err)
+			return err
+		}
+	}
 	// 寻找命令绝对路径避免异常，例如ll实际为/usr/bin/ls -l
-	path, err := exec.LookPath(cmdArry[0])
+	path, err := exec.LookPath(spec.Args[0])
 	if err != nil {
 		log.Errorf("Exec loop path error %v", err)
 		return err
@@ -85,29 +147,14 @@ func RunContainerInitProcess() error {
 
 	// 通过syscall.Exec方法 运行容器需要启动的进程/应用，并将该进程PID与init初始化的PID替换
 	// 例：fockker run -it ll 一开始init进程（/proc/self/init）必定为隔离空间内第一个进程，而此处的Exec将ll替换了init进程，所以使用ps查看进程时会发现ll的PID为1
-	if err := syscall.Exec(path, cmdArry[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, spec.Args, os.Environ()); err != nil {
 		log.Errorf(err.Error())
 	}
 	return nil
 }
 
-// 从文件描述符获取read管道并读取args
-func readCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe")
-	defer func() {
-		_ = pipe.Close()
-	}()
-	msg, err := io.ReadAll(pipe)
-	if err != nil {
-		log.Errorf(`初始化read管道异常 %v`, err)
-		return nil
-	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
-}
-
 // 设置容器环境的初始挂载
-func setupMount() error {
+func setupMount(mounts []Mount) error {
 	nowPath, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("当前路径获取异常: %v", err)
@@ -119,28 +166,8 @@ func setupMount() error {
 		return fmt.Errorf("pivotRoot挂载失败: %v", err)
 	}
 
-	// syscall.MS_NOEXEC 在本文件系统中不允许运行其他程序。
-	// - 即挂载后的文件系统中的可执行文件无法被执行。
-	// syscall.MS_NOSUID 在本系统中运行程序的时候， 不允许set-user-id和set-group-id。
-	// - 即使某个文件具有suid或sgid权限，也不会以文件拥有者的权限执行。
-	// syscall.MS_NODEV 用于禁止在挂载的文件系统中使用设备文件。
-	// - 可以避免对设备文件的访问，增强文件系统的安全性。
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-
 	// 下面基于已实现隔离的进程中再挂载文件系统，使只对自身namespace内容可见
-	// 挂载proc
-	err = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	if err != nil {
-		return fmt.Errorf("proc挂载异常: %v", err)
-	}
-	// 挂载tmpfs
-	err = syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
-	// 将tmpfs挂载到/dev目录可为容器提供快速、临时且安全的环境，它会将文件存储在内存中，避免不必要的磁盘I/O
-	if err != nil {
-		return fmt.Errorf("tmpfs挂载异常: %v", err)
-	}
-
-	return nil
+	return mountAll(mounts)
 }
 
 // 改变当前进程的根文件系统
@@ -0,0 +1,77 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ContainerIO 容器标准输入输出在宿主机一侧的端点
+// 创建终端时为伪终端的master端，否则为标准输入、输出、错误的管道；由容器的shim进程持有
+type ContainerIO struct {
+	Terminal *Terminal // 伪终端，未创建终端时为nil
+	Stdin    *os.File  // 容器标准输入管道的写端，未保持标准输入打开时为nil
+	Stdout   *os.File  // 容器标准输出管道的读端
+	Stderr   *os.File  // 容器标准错误管道的读端
+	child    []*os.File
+}
+
+// NewContainerIO 为容器进程创建标准输入输出。tty为true时分配伪终端，否则创建管道
+// openStdin为false时容器的标准输入为 /dev/null
+func NewContainerIO(cmd *exec.Cmd, tty bool, openStdin bool) (*ContainerIO, error) {
+	if tty {
+		terminal, err := NewTerminal()
+		if err != nil {
+			return nil, err
+		}
+		terminal.Attach(cmd)
+		return &ContainerIO{Terminal: terminal}, nil
+	}
+	stdio := &ContainerIO{}
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdio.Stdout, cmd.Stdout = stdoutRead, stdoutWrite
+	stdio.child = append(stdio.child, stdoutWrite)
+	stderrRead, stderrWrite, err := os.Pipe()
+	if err != nil {
+		stdio.Close()
+		return nil, err
+	}
+	stdio.Stderr, cmd.Stderr = stderrRead, stderrWrite
+	stdio.child = append(stdio.child, stderrWrite)
+	if openStdin {
+		stdinRead, stdinWrite, err := os.Pipe()
+		if err != nil {
+			stdio.Close()
+			return nil, err
+		}
+		stdio.Stdin, cmd.Stdin = stdinWrite, stdinRead
+		stdio.child = append(stdio.child, stdinRead)
+	}
+	return stdio, nil
+}
+
+// CloseChild 容器进程启动后关闭宿主机持有的容器一侧的文件，容器进程退出后读取输出才会结束
+func (c *ContainerIO) CloseChild() {
+	if c.Terminal != nil {
+		c.Terminal.CloseSlave()
+	}
+	for _, file := range c.child {
+		_ = file.Close()
+	}
+	c.child = nil
+}
+
+// Close 关闭全部端点
+func (c *ContainerIO) Close() {
+	c.CloseChild()
+	if c.Terminal != nil {
+		_ = c.Terminal.Master.Close()
+	}
+	for _, file := range []*os.File{c.Stdin, c.Stdout, c.Stderr} {
+		if file != nil {
+			_ = file.Close()
+		}
+	}
+}
@@ -1,58 +1,583 @@
 package container
 
 import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"fockker/errdefs"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// GetLogContent 根据文件获取日志内容，输出到屏幕
-func GetLogContent(containerName string) {
-	_, err := GetContainerInfoByName(containerName)
-	if err != nil && strings.Contains(err.Error(), "no such file") {
-		fmt.Printf("容器 %s 日志获取失败: 该容器不存在\n", containerName)
-		return
+// LogOptions 读取容器日志的选项
+type LogOptions struct {
+	Follow     bool      // 持续输出新的日志，直到容器退出
+	Tail       int       // 只输出最后的行数，小于0时输出全部
+	Since      time.Time // 只输出该时间之后的日志，为零值时不限制
+	Until      time.Time // 只输出该时间之前的日志，为零值时不限制
+	Timestamps bool      // 在每行前输出时间
+	Stream     string    // 只输出该输出流的日志，为空时不限制
+
+	Done <-chan struct{} // 持续输出时，关闭后停止等待新的日志，为nil时不限制
+}
+
+// ReadLogs 读取json-file格式的容器日志，按选项过滤后依次交给handler处理，handler返回错误时停止读取
+// 依次读取轮转后的各代日志文件与当前日志文件，Tail从文件末尾向前查找，不读取整个文件
+func ReadLogs(containerName string, options LogOptions, handler func(entry *LogEntry) error) error {
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 日志获取失败: 该容器不存在", containerName)
+	}
+	// 只有json-file驱动的日志保存在本机
+	if containerInfo.LogDriver != "" && containerInfo.LogDriver != LogDriverJSONFile {
+		return fmt.Errorf("容器 %s 的日志驱动 %s 不支持读取日志", containerName, containerInfo.LogDriver)
+	}
+	logFilePath := fmt.Sprintf(DefaultInfoPath, containerName) + LogFileName
+	files := logGenerations(logFilePath)
+	offsets := make([]int64, len(files))
+	if options.Tail >= 0 {
+		if files, offsets, err = tailOffsets(files, options.Tail); err != nil {
+			return err
+		}
+	}
+	for i, path := range files {
+		if path == logFilePath {
+			break
+		}
+		if err := readLogFile(path, offsets[i], options, handler); err != nil {
+			return err
+		}
+	}
+	// 当前日志文件总是最后一个；Tail在轮转文件中已经满足时，当前文件从末尾开始
+	offset := int64(0)
+	if len(files) > 0 && files[len(files)-1] == logFilePath {
+		offset = offsets[len(offsets)-1]
+	} else if info, err := os.Stat(logFilePath); err == nil && options.Tail >= 0 {
+		offset = info.Size()
+	}
+	if !options.Follow {
+		return readLogFile(logFilePath, offset, options, handler)
 	}
-	dirURL := fmt.Sprintf(DefaultInfoPath, containerName)
-	logFilePath := dirURL + LogFileName
-	file, err := os.Open(logFilePath)
-	// 从配置文件获取容器日志路径
+	return followLogFile(containerName, logFilePath, offset, options, handler)
+}
 
-	defer func(file *os.File) {
-		err := file.Close()
+// 返回日志文件的各代路径，从最早轮转的文件到当前日志文件
+// 轮转后的文件名为 container.log.N，压缩后为 container.log.N.gz，N越大越早；压缩尚未完成时读取未压缩的文件
+func logGenerations(logFilePath string) []string {
+	matches, _ := filepath.Glob(logFilePath + ".*")
+	generations := map[int]string{}
+	var numbers []int
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, logFilePath+".")
+		n, err := strconv.Atoi(strings.TrimSuffix(suffix, compressedLogSuffix))
+		if err != nil || n <= 0 {
+			continue
+		}
+		if existing, exists := generations[n]; exists {
+			if !strings.HasSuffix(existing, compressedLogSuffix) {
+				continue
+			}
+		} else {
+			numbers = append(numbers, n)
+		}
+		generations[n] = match
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	var files []string
+	for _, n := range numbers {
+		files = append(files, generations[n])
+	}
+	if _, err := os.Stat(logFilePath); err == nil {
+		files = append(files, logFilePath)
+	}
+	return files
+}
+
+// 打开日志文件，压缩的日志文件返回解压后的内容
+func openLogFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil || !strings.HasSuffix(path, compressedLogSuffix) {
+		return file, err
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, nil
+}
+
+// 从最新的日志文件向前查找最后tail行，返回需要读取的文件及各自的起始偏移
+func tailOffsets(files []string, tail int) ([]string, []int64, error) {
+	first := len(files)
+	offsets := make([]int64, len(files))
+	for i := len(files) - 1; i >= 0 && tail > 0; i-- {
+		offset, count, err := tailFile(files[i], tail)
 		if err != nil {
-			log.Errorf("日志文件 %s 关闭异常 %v", logFilePath, err)
+			return nil, nil, err
 		}
-	}(file)
+		first, offsets[i] = i, offset
+		tail -= count
+	}
+	return files[first:], offsets[first:], nil
+}
 
+// 从文件末尾按块向前查找最后n行的起始偏移，返回偏移与找到的行数（文件不足n行时为全部行数）
+// 压缩的日志文件无法从末尾读取，顺序解压后查找，偏移为解压后内容中的偏移
+func tailFile(path string, n int) (int64, int, error) {
+	if strings.HasSuffix(path, compressedLogSuffix) {
+		return tailStream(path, n)
+	}
+	file, err := os.Open(path)
 	if err != nil {
-		log.Errorf("日志文件 %s 打开异常 %v", logFilePath, err)
-		return
+		return 0, 0, err
 	}
-	content, err := io.ReadAll(file)
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
 	if err != nil {
-		log.Errorf("日志文件 %s 读取异常 %v", logFilePath, err)
-		return
+		return 0, 0, err
+	}
+	end := info.Size()
+	if end == 0 {
+		return 0, 0, nil
+	}
+	// 末尾的换行属于最后一行
+	last := make([]byte, 1)
+	if _, err = file.ReadAt(last, end-1); err != nil {
+		return 0, 0, err
+	}
+	if last[0] == '\n' {
+		end--
+	}
+	count := 0
+	buffer := make([]byte, 32*1024)
+	for end > 0 {
+		size := int64(len(buffer))
+		if end < size {
+			size = end
+		}
+		chunk := buffer[:size]
+		if _, err = file.ReadAt(chunk, end-size); err != nil {
+			return 0, 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != '\n' {
+				continue
+			}
+			count++
+			if count == n {
+				return end - size + int64(i) + 1, count, nil
+			}
+		}
+		end -= size
 	}
+	// 文件开头的一行之前没有换行
+	return 0, count + 1, nil
+}
+
+// 顺序读取日志文件，只保留最后n行的起始偏移
+func tailStream(path string, n int) (int64, int, error) {
+	file, err := openLogFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	starts := make([]int64, n) // 最近n行起始偏移的环形缓冲
+	count := 0
+	offset := int64(0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			starts[count%n] = offset
+			count++
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if count < n {
+		return 0, count, nil
+	}
+	return starts[count%n], n, nil
+}
+
+// 从offset开始读取日志文件直到文件末尾，日志文件不存在时不输出
+func readLogFile(path string, offset int64, options LogOptions, handler func(entry *LogEntry) error) error {
+	file, err := openLogFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("日志文件 %s 打开异常 %v", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	// 未压缩的文件直接定位到offset，压缩的文件需要解压并跳过之前的内容
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, file, offset)
+	}
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := handleLogLine(line, options, handler); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("日志文件 %s 读取异常 %v", path, err)
+		}
+	}
+}
 
-	_, err = fmt.Fprint(os.Stdout, string(content))
+// 从offset开始持续读取当前日志文件，直到容器不再运行且日志读取完毕，或超过Until，或options.Done关闭
+// 日志文件被轮转（路径指向了新的文件）时，读完原文件后从新文件的开头继续
+func followLogFile(containerName string, path string, offset int64, options LogOptions, handler func(entry *LogEntry) error) error {
+	var file *os.File
+	var reader *bufio.Reader
+	defer func() {
+		if file != nil {
+			_ = file.Close()
+		}
+	}()
+	var pending []byte // 写入尚未完成的行
+	stopping := false
+	for {
+		if file == nil {
+			opened, err := os.Open(path)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("日志文件 %s 打开异常 %v", path, err)
+			}
+			if err == nil {
+				if _, err = opened.Seek(offset, io.SeekStart); err != nil {
+					_ = opened.Close()
+					return err
+				}
+				file, reader, offset = opened, bufio.NewReader(opened), 0
+			}
+		}
+		if reader != nil {
+			line, err := reader.ReadBytes('\n')
+			pending = append(pending, line...)
+			if err == nil {
+				if err = handleLogLine(pending, options, handler); err != nil {
+					return err
+				}
+				pending = nil
+				continue
+			}
+			if err != io.EOF {
+				return fmt.Errorf("日志文件 %s 读取异常 %v", path, err)
+			}
+			if rotated(file, path) {
+				if len(pending) > 0 {
+					if err = handleLogLine(pending, options, handler); err != nil {
+						return err
+					}
+					pending = nil
+				}
+				_ = file.Close()
+				file, reader = nil, nil
+				continue
+			}
+		}
+		if stopping || (!options.Until.IsZero() && time.Now().After(options.Until)) {
+			if len(pending) > 0 {
+				return handleLogLine(pending, options, handler)
+			}
+			return nil
+		}
+		// shim在关闭日志之后才更新容器状态，发现容器停止后再读取一次即可读完全部日志
+		if containerInfo, err := GetContainerInfoByName(containerName); err != nil || containerInfo.Status != RUNNING {
+			stopping = true
+			continue
+		}
+		select {
+		case <-options.Done:
+			return nil
+		case <-time.After(logFollowInterval):
+		}
+	}
+}
+
+// 判断已打开的日志文件是否已被轮转，即路径不再指向该文件
+func rotated(file *os.File, path string) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(current, opened)
+}
+
+// 解码一行json-file日志并按选项过滤后交给handler，无法解码的行（早期版本的原始日志）视为stdout原样输出
+func handleLogLine(line []byte, options LogOptions, handler func(entry *LogEntry) error) error {
+	var entry LogEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		entry = LogEntry{Log: string(line), Stream: StreamStdout}
+	}
+	if options.Stream != "" && entry.Stream != options.Stream {
+		return nil
+	}
+	if !options.Since.IsZero() && entry.Time.Before(options.Since) {
+		return nil
+	}
+	if !options.Until.IsZero() && entry.Time.After(options.Until) {
+		return nil
+	}
+	return handler(&entry)
+}
+
+// WriteLogEntry 输出一条日志，stdout与stderr的日志分别写入stdout与stderr，timestamps为true时在行前输出时间
+func WriteLogEntry(entry *LogEntry, timestamps bool, stdout io.Writer, stderr io.Writer) error {
+	output := stdout
+	if entry.Stream == StreamStderr {
+		output = stderr
+	}
+	if timestamps && !entry.Time.IsZero() {
+		_, err := fmt.Fprintf(output, "%s %s", entry.Time.Format(time.RFC3339Nano), entry.Log)
+		return err
+	}
+	_, err := fmt.Fprint(output, entry.Log)
+	return err
+}
+
+// ParseLogTime 解析 --since、--until 的时间：RFC3339格式的时间、Unix时间戳，或 10m、1h30m 等相对now之前的时长
+func ParseLogTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Time{}, errdefs.Invalid("无效的时间 %s", value)
+}
+
+// 日志文件的轮转配置
+type logRotation struct {
+	maxSize  int64 // 单个日志文件的最大字节数，为0时不轮转
+	maxFile  int   // 保留的日志文件数量，包括当前日志文件
+	compress bool  // 是否使用gzip压缩轮转后的日志文件
+}
+
+// 由json-file驱动的日志选项生成轮转配置，忽略各驱动通用的缓冲选项
+func newLogRotation(logOpts map[string]string) (*logRotation, error) {
+	rotation := &logRotation{maxFile: 1}
+	for key, value := range logOpts {
+		var err error
+		switch key {
+		case LogOptMaxSize:
+			rotation.maxSize, err = parseLogSize(value)
+		case LogOptMaxFile:
+			rotation.maxFile, err = strconv.Atoi(value)
+			if err == nil && rotation.maxFile < 1 {
+				err = fmt.Errorf("不能小于1")
+			}
+		case LogOptCompress:
+			rotation.compress, err = strconv.ParseBool(value)
+		}
+		if err != nil {
+			return nil, errdefs.Invalid("日志选项 %s=%s 无效: %v", key, value, err)
+		}
+	}
+	return rotation, nil
+}
+
+// 解析日志文件大小，支持 k、m、g 单位（1024进制），如 10m
+func parseLogSize(value string) (int64, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	value = strings.TrimSuffix(strings.ToLower(value), "b")
+	multiple := int64(1)
+	if len(value) > 0 {
+		if unit, exists := units[value[len(value)-1]]; exists {
+			multiple, value = unit, value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("无效的大小")
+	}
+	return size * multiple, nil
+}
+
+// JSONFileLogger json-file日志驱动，每条日志写为一行JSON，记录输出流、时间与内容，可通过logs读取
+// 由持有容器输出的shim独占写入，日志文件超过max-size时轮转为 container.log.1、container.log.2 ...
+type JSONFileLogger struct {
+	path        string
+	file        *os.File
+	size        int64 // 当前日志文件的大小
+	rotation    *logRotation
+	compressing sync.WaitGroup // 正在压缩的轮转文件
+}
+
+// NewJSONFileLogger 根据日志选项创建容器的json-file日志
+func NewJSONFileLogger(containerName string, logOpts map[string]string) (*JSONFileLogger, error) {
+	rotation, err := newLogRotation(logOpts)
+	if err != nil {
+		return nil, err
+	}
+	file, err := CreateLogFile(containerName)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &JSONFileLogger{
+		path:     fmt.Sprintf(DefaultInfoPath, containerName) + LogFileName,
+		file:     file,
+		size:     info.Size(),
+		rotation: rotation,
+	}, nil
+}
+
+// Log 写入一条日志，写入后超过max-size时先轮转日志文件
+func (l *JSONFileLogger) Log(entry *LogEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	if l.rotation.maxSize > 0 && l.size > 0 && l.size+int64(len(content)) > l.rotation.maxSize {
+		if err = l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(content)
+	l.size += int64(n)
+	return err
+}
+
+// Close 关闭日志文件并等待轮转文件压缩完成
+func (l *JSONFileLogger) Close() error {
+	err := l.file.Close()
+	l.compressing.Wait()
+	return err
+}
+
+// 轮转日志文件：各代轮转文件的编号加1，超出max-file的最早一代删除，当前日志文件重命名为 .1 后重新创建
+// max-file为1时直接删除当前日志文件。读取日志的一方通过文件是否仍为同一文件判断发生了轮转
+func (l *JSONFileLogger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		log.Errorf("日志文件 %s 关闭异常 %v", l.path, err)
+	}
+	// 等待上一次轮转的压缩完成，各代文件名稳定后再重命名
+	l.compressing.Wait()
+	if l.rotation.maxFile > 1 {
+		last := fmt.Sprintf("%s.%d", l.path, l.rotation.maxFile-1)
+		_ = os.Remove(last)
+		_ = os.Remove(last + compressedLogSuffix)
+		for n := l.rotation.maxFile - 2; n >= 1; n-- {
+			for _, suffix := range []string{"", compressedLogSuffix} {
+				from := fmt.Sprintf("%s.%d%s", l.path, n, suffix)
+				if _, err := os.Stat(from); err == nil {
+					_ = os.Rename(from, fmt.Sprintf("%s.%d%s", l.path, n+1, suffix))
+				}
+			}
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return fmt.Errorf("日志文件 %s 轮转异常 %v", l.path, err)
+		}
+		if l.rotation.compress {
+			l.compressing.Add(1)
+			go func() {
+				defer l.compressing.Done()
+				if err := compressLogFile(l.path + ".1"); err != nil {
+					log.Errorf("日志文件 %s 压缩异常 %v", l.path+".1", err)
+				}
+			}()
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("日志文件 %s 轮转异常 %v", l.path, err)
+	}
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("日志文件 %s 创建异常 %v", l.path, err)
+	}
+	l.file, l.size = file, 0
+	return nil
+}
+
+// 将轮转后的日志文件压缩为 .gz 文件，压缩完成后删除原文件。压缩过程中写入临时文件，读取日志的一方仍读取原文件
+func compressLogFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+	tmpPath := path + compressedLogSuffix + ".tmp"
+	target, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, source)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path+compressedLogSuffix)
+	}
 	if err != nil {
-		log.Errorf("日志文件 %s 标准输出异常 %v", logFilePath, err)
-		return
+		_ = os.Remove(tmpPath)
+		return err
 	}
+	return os.Remove(path)
 }
 
 // CreateLogFile 根据容器名创建日志文件
 func CreateLogFile(containerName string) (*os.File, error) {
 	dirPath := fmt.Sprintf(DefaultInfoPath, containerName)
 	if err := os.MkdirAll(dirPath, 0622); err != nil {
-		log.Errorf("日志配置路径 %s 创建异常 %v", dirPath, err)
-		return nil, nil
+		return nil, fmt.Errorf("日志配置路径 %s 创建异常 %v", dirPath, err)
 	}
-	// 配置日志文件路径
+	// 配置日志文件路径，以追加方式打开，重新启动的容器保留之前的日志
 	stdLogFilePath := dirPath + LogFileName
-	stdLogFile, err := os.Create(stdLogFilePath)
+	stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	return stdLogFile, err
 }
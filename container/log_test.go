@@ -0,0 +1,202 @@
+package container
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 写入日志文件，以.gz结尾的文件使用gzip压缩
+func writeLogFile(t *testing.T, path string, content string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if !strings.HasSuffix(path, compressedLogSuffix) {
+		if _, err = file.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	writer := gzip.NewWriter(file)
+	if _, err = writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 与ReadLogs相同，按tailOffsets返回的偏移依次读取各代日志文件
+func readTail(t *testing.T, logFilePath string, tail int) []string {
+	t.Helper()
+	files, offsets, err := tailOffsets(logGenerations(logFilePath), tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for i, path := range files {
+		err = readLogFile(path, offsets[i], LogOptions{}, func(entry *LogEntry) error {
+			lines = append(lines, entry.Log)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lines
+}
+
+func TestTailLogs(t *testing.T) {
+	var long strings.Builder
+	var longTail []string
+	for i := 0; i < 10000; i++ {
+		line := fmt.Sprintf("%05d\n", i)
+		long.WriteString(line)
+		if i >= 7000 {
+			longTail = append(longTail, line)
+		}
+	}
+	tests := []struct {
+		name     string
+		files    map[string]string // 日志文件名的后缀与内容，空后缀为当前日志文件
+		tail     int
+		expected []string
+	}{
+		{"末尾有换行", map[string]string{"": "a\nb\nc\n"}, 2, []string{"b\n", "c\n"}},
+		{"末尾无换行", map[string]string{"": "a\nb\nc"}, 2, []string{"b\n", "c"}},
+		{"只有一行且无换行", map[string]string{"": "a"}, 1, []string{"a"}},
+		{"tail为0", map[string]string{"": "a\nb\n"}, 0, nil},
+		{"行数不足", map[string]string{"": "a\nb\n"}, 5, []string{"a\n", "b\n"}},
+		{"空文件", map[string]string{"": ""}, 3, nil},
+		{"超过读取块大小", map[string]string{"": long.String()}, 3000, longTail},
+		{"跨越轮转文件", map[string]string{".1": "a\nb\n", "": "c\n"}, 2, []string{"b\n", "c\n"}},
+		{"跨越压缩的轮转文件", map[string]string{".2.gz": "a\nb\n", ".1": "c\n", "": "d\n"}, 3, []string{"b\n", "c\n", "d\n"}},
+		{"当前文件为空", map[string]string{".1.gz": "a\nb\nc\n", "": ""}, 2, []string{"b\n", "c\n"}},
+		{"压缩尚未完成", map[string]string{".1": "a\nb\n", ".1.gz": "", "": "c\n"}, 3, []string{"a\n", "b\n", "c\n"}},
+	}
+	for _, test := range tests {
+		logFilePath := filepath.Join(t.TempDir(), LogFileName)
+		for suffix, content := range test.files {
+			writeLogFile(t, logFilePath+suffix, content)
+		}
+		lines := readTail(t, logFilePath, test.tail)
+		if len(lines) != len(test.expected) {
+			t.Errorf("%s: 读取到 %d 行，应为 %d 行", test.name, len(lines), len(test.expected))
+			continue
+		}
+		for i := range lines {
+			if lines[i] != test.expected[i] {
+				t.Errorf("%s: 第%d行为 %q，应为 %q", test.name, i, lines[i], test.expected[i])
+				break
+			}
+		}
+	}
+}
+
+// 压缩的日志文件顺序查找，与未压缩的文件从末尾查找的结果应一致
+func TestTailStreamMatchesTailFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, content := range []string{"a\n", "a", "a\nb\nc\n", "a\nb\nc", "\n\n\n", "a\n\nb\n"} {
+		plain, compressed := filepath.Join(dir, "plain"), filepath.Join(dir, "log"+compressedLogSuffix)
+		writeLogFile(t, plain, content)
+		writeLogFile(t, compressed, content)
+		for n := 1; n <= 4; n++ {
+			offset, count, err := tailFile(plain, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streamOffset, streamCount, err := tailFile(compressed, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offset != streamOffset || count != streamCount {
+				t.Errorf("%q 的最后%d行: 未压缩时为 (%d, %d)，压缩后为 (%d, %d)", content, n, offset, count, streamOffset, streamCount)
+			}
+		}
+	}
+}
+
+// 等待follow输出下一行日志
+func expectLogLine(t *testing.T, lines <-chan string, expected string) {
+	t.Helper()
+	select {
+	case line := <-lines:
+		if line != expected {
+			t.Fatalf("输出的日志为 %q，应为 %q", line, expected)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("等待日志 %q 超时", expected)
+	}
+}
+
+// follow期间日志文件被轮转，原文件剩余的日志与新文件的日志均应输出，容器停止后返回
+func TestFollowLogFileRotation(t *testing.T) {
+	defaultInfoPath := DefaultInfoPath
+	DefaultInfoPath = t.TempDir() + "/%s/"
+	t.Cleanup(func() {
+		DefaultInfoPath = defaultInfoPath
+	})
+	containerInfo := &ContainerInfo{Name: "follow"}
+	if err := RecordContainerInfo(containerInfo); err != nil {
+		t.Fatal(err)
+	}
+	containerInfo.Status = RUNNING
+	if err := UpdateContainerInfoByName(containerInfo); err != nil {
+		t.Fatal(err)
+	}
+	logFilePath := fmt.Sprintf(DefaultInfoPath, containerInfo.Name) + LogFileName
+	writeLogFile(t, logFilePath, "a\n")
+
+	lines := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- followLogFile(containerInfo.Name, logFilePath, 0, LogOptions{Follow: true}, func(entry *LogEntry) error {
+			lines <- entry.Log
+			return nil
+		})
+	}()
+	expectLogLine(t, lines, "a\n")
+
+	// 轮转前写入原文件的日志，最后一行尚未写完
+	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteString("b\nc")
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(logFilePath, logFilePath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeLogFile(t, logFilePath, "d\n")
+	for _, expected := range []string{"b\n", "c", "d\n"} {
+		expectLogLine(t, lines, expected)
+	}
+
+	containerInfo.Status = STOP
+	if err = UpdateContainerInfoByName(containerInfo); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("容器停止后follow未返回")
+	}
+	if len(lines) > 0 {
+		t.Errorf("多输出了日志 %q", <-lines)
+	}
+}
@@ -0,0 +1,314 @@
+package container
+
+import (
+	"bytes"
+	"fockker/errdefs"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogDriver 日志驱动，接收按行拆分后的容器输出
+type LogDriver interface {
+	Log(entry *LogEntry) error
+	Close() error
+}
+
+// 日志驱动的注册信息
+type logDriverInfo struct {
+	options  []string                                              // 驱动支持的日志选项，各驱动通用的缓冲选项除外
+	validate func(logOpts map[string]string) error                 // 检查选项的取值
+	create   func(containerInfo *ContainerInfo) (LogDriver, error) // 按容器信息中的选项创建驱动
+}
+
+// 可通过 --log-driver 选择的日志驱动
+var logDrivers = map[string]logDriverInfo{
+	LogDriverJSONFile: {
+		options: []string{LogOptMaxSize, LogOptMaxFile, LogOptCompress},
+		validate: func(logOpts map[string]string) error {
+			_, err := newLogRotation(logOpts)
+			return err
+		},
+		create: func(containerInfo *ContainerInfo) (LogDriver, error) {
+			return NewJSONFileLogger(containerInfo.Name, containerInfo.LogOpts)
+		},
+	},
+	LogDriverNone: {
+		validate: func(map[string]string) error { return nil },
+		create: func(*ContainerInfo) (LogDriver, error) {
+			return noneLogger{}, nil
+		},
+	},
+	LogDriverSyslog: {
+		options:  []string{LogOptSyslogAddress, LogOptSyslogFacility, LogOptTag},
+		validate: validateSyslogOpts,
+		create: func(containerInfo *ContainerInfo) (LogDriver, error) {
+			return NewSyslogLogger(containerInfo)
+		},
+	},
+	LogDriverFluentd: {
+		options:  []string{LogOptFluentdAddress, LogOptTag},
+		validate: validateFluentdOpts,
+		create: func(containerInfo *ContainerInfo) (LogDriver, error) {
+			return NewFluentdLogger(containerInfo)
+		},
+	},
+}
+
+// ParseLogOpts 检查日志驱动，并解析 key=value 格式的日志选项，检查选项名与取值
+func ParseLogOpts(driverName string, logOpts []string) (map[string]string, error) {
+	if _, exists := logDrivers[driverName]; !exists {
+		return nil, errdefs.Invalid("不支持的日志驱动 %s", driverName)
+	}
+	if len(logOpts) == 0 {
+		return nil, nil
+	}
+	options := map[string]string{}
+	for _, logOpt := range logOpts {
+		key, value, ok := strings.Cut(logOpt, "=")
+		if !ok || key == "" {
+			return nil, errdefs.Invalid("日志选项格式错误 %s", logOpt)
+		}
+		options[key] = value
+	}
+	if err := ValidateLogOpts(driverName, options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// ValidateLogOpts 检查日志驱动与已解析的日志选项的选项名与取值，日志驱动为空时为json-file
+func ValidateLogOpts(driverName string, logOpts map[string]string) error {
+	if driverName == "" {
+		driverName = LogDriverJSONFile
+	}
+	driver, exists := logDrivers[driverName]
+	if !exists {
+		return errdefs.Invalid("不支持的日志驱动 %s", driverName)
+	}
+	for key := range logOpts {
+		supported := key == LogOptMode || key == LogOptMaxBufferSize || key == LogOptDropPolicy
+		for _, option := range driver.options {
+			supported = supported || key == option
+		}
+		if !supported {
+			return errdefs.Invalid("日志驱动 %s 不支持日志选项 %s", driverName, key)
+		}
+	}
+	if _, err := newLogBufferConfig(logOpts); err != nil {
+		return err
+	}
+	return driver.validate(logOpts)
+}
+
+// 容器日志的缓冲配置，各日志驱动通用
+type logBufferConfig struct {
+	nonBlocking bool  // 是否为非阻塞模式
+	maxSize     int64 // 缓冲的最大字节数
+	dropOldest  bool  // 缓冲已满时丢弃最早的日志，否则丢弃新的日志
+}
+
+func newLogBufferConfig(logOpts map[string]string) (*logBufferConfig, error) {
+	config := &logBufferConfig{maxSize: defaultLogBufferSize}
+	switch mode := logOpts[LogOptMode]; mode {
+	case "", LogModeBlocking:
+	case LogModeNonBlocking:
+		config.nonBlocking = true
+	default:
+		return nil, errdefs.Invalid("日志选项 %s=%s 无效: 应为 %s 或 %s", LogOptMode, mode, LogModeBlocking, LogModeNonBlocking)
+	}
+	if value, exists := logOpts[LogOptMaxBufferSize]; exists {
+		size, err := parseLogSize(value)
+		if err != nil {
+			return nil, errdefs.Invalid("日志选项 %s=%s 无效: %v", LogOptMaxBufferSize, value, err)
+		}
+		config.maxSize = size
+	}
+	switch policy := logOpts[LogOptDropPolicy]; policy {
+	case "", LogDropNewest:
+	case LogDropOldest:
+		config.dropOldest = true
+	default:
+		return nil, errdefs.Invalid("日志选项 %s=%s 无效: 应为 %s 或 %s", LogOptDropPolicy, policy, LogDropNewest, LogDropOldest)
+	}
+	return config, nil
+}
+
+// ContainerLogger 将容器输出按行拆分为日志，交给容器的日志驱动写入
+// 阻塞模式下直接写入日志驱动；非阻塞模式下先写入缓冲，由后台协程写入，缓冲已满时按丢弃策略丢弃日志，避免日志驱动阻塞容器输出
+type ContainerLogger struct {
+	driver  LogDriver
+	mutex   sync.Mutex
+	partial map[string][]byte // 各输出流中尚未遇到换行的内容
+	buffer  *logBuffer        // 非阻塞模式下的缓冲，阻塞模式为nil
+	done    chan struct{}     // 后台协程已写完缓冲中的日志
+}
+
+// NewContainerLogger 按容器信息中的日志驱动与选项创建容器日志，未指定日志驱动时为json-file
+func NewContainerLogger(containerInfo *ContainerInfo) (*ContainerLogger, error) {
+	driverName := containerInfo.LogDriver
+	if driverName == "" {
+		driverName = LogDriverJSONFile
+	}
+	driverInfo, exists := logDrivers[driverName]
+	if !exists {
+		return nil, errdefs.Invalid("不支持的日志驱动 %s", driverName)
+	}
+	config, err := newLogBufferConfig(containerInfo.LogOpts)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := driverInfo.create(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+	logger := &ContainerLogger{driver: driver, partial: map[string][]byte{}}
+	if config.nonBlocking {
+		logger.buffer = newLogBuffer(config)
+		logger.done = make(chan struct{})
+		go logger.run()
+	}
+	return logger, nil
+}
+
+// Log 写入输出流stream的一段输出，按换行拆分为多条日志，不完整的行暂存到下次写入
+// 暂存内容超过logLineMaxSize时不再等待换行，直接写入
+func (l *ContainerLogger) Log(stream string, data []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	buffer := append(l.partial[stream], data...)
+	now := time.Now().UTC()
+	for {
+		i := bytes.IndexByte(buffer, '\n')
+		if i < 0 {
+			break
+		}
+		if err := l.emit(&LogEntry{Log: string(buffer[:i+1]), Stream: stream, Time: now}); err != nil {
+			return err
+		}
+		buffer = buffer[i+1:]
+	}
+	if len(buffer) >= logLineMaxSize {
+		if err := l.emit(&LogEntry{Log: string(buffer), Stream: stream, Time: now}); err != nil {
+			return err
+		}
+		buffer = nil
+	}
+	l.partial[stream] = append([]byte{}, buffer...)
+	return nil
+}
+
+// Close 写入各输出流中剩余的不完整行，等待缓冲中的日志写完后关闭日志驱动
+func (l *ContainerLogger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for stream, buffer := range l.partial {
+		if len(buffer) > 0 {
+			_ = l.emit(&LogEntry{Log: string(buffer), Stream: stream, Time: time.Now().UTC()})
+		}
+	}
+	l.partial = map[string][]byte{}
+	if l.buffer != nil {
+		l.buffer.close()
+		<-l.done
+		if l.buffer.dropped > 0 {
+			log.Warnf("日志缓冲已满，共丢弃 %d 条日志", l.buffer.dropped)
+		}
+	}
+	return l.driver.Close()
+}
+
+func (l *ContainerLogger) emit(entry *LogEntry) error {
+	if l.buffer == nil {
+		return l.driver.Log(entry)
+	}
+	l.buffer.push(entry)
+	return nil
+}
+
+// 非阻塞模式下将缓冲中的日志依次写入日志驱动
+func (l *ContainerLogger) run() {
+	defer close(l.done)
+	for {
+		entry, ok := l.buffer.pop()
+		if !ok {
+			return
+		}
+		if err := l.driver.Log(entry); err != nil {
+			log.Errorf("日志写入异常 %v", err)
+		}
+	}
+}
+
+// 非阻塞模式的日志缓冲，按日志内容的字节数限制大小
+type logBuffer struct {
+	mutex      sync.Mutex
+	cond       *sync.Cond
+	entries    []*LogEntry
+	size       int64
+	maxSize    int64
+	dropOldest bool
+	closed     bool
+	dropped    int // 已丢弃的日志数量
+}
+
+func newLogBuffer(config *logBufferConfig) *logBuffer {
+	buffer := &logBuffer{maxSize: config.maxSize, dropOldest: config.dropOldest}
+	buffer.cond = sync.NewCond(&buffer.mutex)
+	return buffer
+}
+
+// 写入一条日志，缓冲已满时按丢弃策略丢弃最早的日志或该日志，不会阻塞
+func (b *logBuffer) push(entry *LogEntry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	size := int64(len(entry.Log))
+	for b.dropOldest && len(b.entries) > 0 && b.size+size > b.maxSize {
+		b.size -= int64(len(b.entries[0].Log))
+		b.entries = b.entries[1:]
+		b.dropped++
+	}
+	if b.size+size > b.maxSize && len(b.entries) > 0 {
+		b.dropped++
+		return
+	}
+	b.entries = append(b.entries, entry)
+	b.size += size
+	b.cond.Signal()
+}
+
+// 取出最早的一条日志，缓冲为空时等待；缓冲已关闭且为空时返回false
+func (b *logBuffer) pop() (*LogEntry, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for len(b.entries) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.entries) == 0 {
+		return nil, false
+	}
+	entry := b.entries[0]
+	b.entries[0] = nil
+	b.entries = b.entries[1:]
+	b.size -= int64(len(entry.Log))
+	return entry, true
+}
+
+func (b *logBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// none日志驱动，丢弃全部输出
+type noneLogger struct{}
+
+func (noneLogger) Log(*LogEntry) error {
+	return nil
+}
+
+func (noneLogger) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"testing"
+)
+
+// 依次写入三条4字节的日志，缓冲最多10字节，只能容纳两条
+func pushTestEntries(policy string) (*logBuffer, error) {
+	config, err := newLogBufferConfig(map[string]string{
+		LogOptMode:          LogModeNonBlocking,
+		LogOptMaxBufferSize: "10",
+		LogOptDropPolicy:    policy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	buffer := newLogBuffer(config)
+	for _, line := range []string{"aaa\n", "bbb\n", "ccc\n"} {
+		buffer.push(&LogEntry{Log: line, Stream: StreamStdout})
+	}
+	buffer.close()
+	return buffer, nil
+}
+
+// 取出缓冲中剩余的全部日志
+func drainLogBuffer(buffer *logBuffer) []string {
+	var lines []string
+	for {
+		entry, ok := buffer.pop()
+		if !ok {
+			return lines
+		}
+		lines = append(lines, entry.Log)
+	}
+}
+
+func TestLogBufferDropPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected []string
+	}{
+		{LogDropNewest, []string{"aaa\n", "bbb\n"}},
+		{LogDropOldest, []string{"bbb\n", "ccc\n"}},
+	}
+	for _, test := range tests {
+		buffer, err := pushTestEntries(test.policy)
+		if err != nil {
+			t.Fatalf("%s: %v", test.policy, err)
+		}
+		if buffer.dropped != 1 {
+			t.Errorf("%s: 丢弃了 %d 条日志，应为1条", test.policy, buffer.dropped)
+		}
+		lines := drainLogBuffer(buffer)
+		if len(lines) != len(test.expected) {
+			t.Fatalf("%s: 缓冲中的日志为 %q，应为 %q", test.policy, lines, test.expected)
+		}
+		for i := range lines {
+			if lines[i] != test.expected[i] {
+				t.Errorf("%s: 缓冲中的日志为 %q，应为 %q", test.policy, lines, test.expected)
+				break
+			}
+		}
+		if buffer.size != 0 {
+			t.Errorf("%s: 取出全部日志后缓冲大小为 %d", test.policy, buffer.size)
+		}
+	}
+}
+
+// 超过缓冲大小的单条日志在缓冲为空时仍然写入，避免长行永远无法写入
+func TestLogBufferOversizedEntry(t *testing.T) {
+	config, err := newLogBufferConfig(map[string]string{LogOptMaxBufferSize: "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := newLogBuffer(config)
+	buffer.push(&LogEntry{Log: "0123456789\n"})
+	buffer.push(&LogEntry{Log: "a\n"})
+	buffer.close()
+	lines := drainLogBuffer(buffer)
+	if len(lines) != 1 || lines[0] != "0123456789\n" || buffer.dropped != 1 {
+		t.Errorf("缓冲中的日志为 %q，丢弃 %d 条，应只保留第一条", lines, buffer.dropped)
+	}
+}
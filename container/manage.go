@@ -1,99 +1,333 @@
 package container
 
 import (
+	"errors"
 	"fmt"
+	"fockker/errdefs"
+	"fockker/image"
+	"fockker/network"
 	"fockker/nsenter"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
-// StopContainer 停止正在运行的容器
-func StopContainer(containerName string) {
+// StopContainer 停止正在运行的容器：发送镜像配置的停止信号（默认SIGTERM），超过timeout仍未退出时发送SIGKILL
+// 容器进程退出并由shim记录退出状态后才返回，此时容器为STOP状态，不再按重启策略重启
+func StopContainer(containerName string, timeout time.Duration) error {
+	// 检查状态与记录停止请求在容器的锁内完成，shim不会在此期间将容器重新启动
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return err
+	}
 	containerInfo, err := GetContainerInfoByName(containerName)
 	if err != nil {
-		log.Errorf("获取容器信息 %s 异常 %v", containerName, err)
-		return
+		unlock()
+		return errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	// 等待重启中的容器不再重启
+	if containerInfo.Status == RESTARTING {
+		containerInfo.Status = STOP
+		err = UpdateContainerInfoByName(&containerInfo)
+		unlock()
+		if err != nil {
+			return fmt.Errorf("更新容器%s信息异常 %v", containerName, err)
+		}
+		RecordEvent(&containerInfo, EventStop)
+		return nil
+	}
+	// 未运行的容器Pid为 -，不能发送信号，否则会向调用方所在的进程组发送信号
+	if containerInfo.Status != RUNNING {
+		unlock()
+		return errdefs.Conflict("容器: %s, ID: %s, 已为%s", containerName, containerInfo.Id, containerInfo.Status)
+	}
+	stopSignal := syscall.SIGTERM
+	if containerInfo.StopSignal != "" {
+		if stopSignal, err = ParseSignal(containerInfo.StopSignal); err != nil {
+			log.Warnf("容器 %s 的停止信号无效，使用SIGTERM: %v", containerName, err)
+			stopSignal = syscall.SIGTERM
+		}
+	}
+	// 先记录停止请求，shim在容器退出后据此标记为STOP，容器状态在退出前保持不变
+	containerInfo.ManualStop = true
+	err = UpdateContainerInfoByName(&containerInfo)
+	unlock()
+	if err != nil {
+		return fmt.Errorf("更新容器%s信息异常 %v", containerName, err)
 	}
 	pid := containerInfo.Pid
 	pidInt, _ := strconv.Atoi(pid) // string 转换 int
-	// 中止进程
-	// SIGTERM：终止信号，通常用于请求程序优雅地终止。 -15
-	// SIGKILL：强制终止信号，无法被捕获或忽略，立即终止进程。 -9
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
-		log.Errorf("停止容器%s的进程%d中止异常 %v", containerName, pidInt, err)
-		return
+	// 中止进程，进程已退出时等待shim记录即可
+	if err = syscall.Kill(pidInt, stopSignal); err != nil && err != syscall.ESRCH {
+		resetManualStop(containerName, pid)
+		return fmt.Errorf("停止容器%s的进程%d中止异常 %v", containerName, pidInt, err)
+	}
+	if !waitStopped(containerName, pid, timeout) {
+		// SIGKILL：强制终止信号，无法被捕获或忽略，立即终止进程
+		log.Warnf("容器 %s 未在 %v 内退出，发送SIGKILL", containerName, timeout)
+		if err = syscall.Kill(pidInt, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			resetManualStop(containerName, pid)
+			return fmt.Errorf("停止容器%s的进程%d中止异常 %v", containerName, pidInt, err)
+		}
+		if !waitStopped(containerName, pid, stopKillTimeout) {
+			resetManualStop(containerName, pid)
+			return fmt.Errorf("容器 %s 的进程%d在SIGKILL后仍未退出", containerName, pidInt)
+		}
 	}
+	RecordEvent(&containerInfo, EventStop)
+	return nil
+}
 
-	if containerInfo.Status != STOP {
-		// 更新配置文件中的容器信息
-		containerInfo.Status = STOP
-		containerInfo.Pid = "-"
-		err = UpdateContainerInfoByName(&containerInfo)
-		if err != nil {
-			log.Errorf("更新容器%s信息异常 %v", containerName, err)
-			return
+// 等待容器进程退出并由shim记录退出状态，进程超过timeout仍未退出时返回false
+// 进程已退出而shim未能记录时（如shim已被强制终止），由调用方标记为STOP
+func waitStopped(containerName string, pid string, timeout time.Duration) bool {
+	pidInt, _ := strconv.Atoi(pid)
+	deadline := time.Now().Add(timeout)
+	var exitedAt time.Time
+	for {
+		current, err := GetContainerInfoByName(containerName)
+		if err != nil || current.Status != RUNNING || current.Pid != pid {
+			return true
+		}
+		if errors.Is(syscall.Kill(pidInt, 0), syscall.ESRCH) {
+			// 容器进程已退出，shim仍在转发剩余输出与清理
+			if exitedAt.IsZero() {
+				exitedAt = time.Now()
+			} else if time.Since(exitedAt) > stopRecordTimeout {
+				markStopped(containerName, pid)
+				return true
+			}
+		} else if time.Now().After(deadline) {
+			return false
 		}
+		time.Sleep(stopWaitInterval)
+	}
+}
 
-		fmt.Printf("容器: %s, ID: %s, 已进入%s\n", containerName, containerInfo.Id, containerInfo.Status)
-	} else {
-		fmt.Printf("容器: %s, ID: %s, 已为%s\n", containerName, containerInfo.Id, containerInfo.Status)
+// 将shim未能记录退出状态的容器标记为STOP，在容器的锁内重新读取，容器已被shim记录或重新启动时不覆盖
+func markStopped(containerName string, pid string) {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	current, err := GetContainerInfoByName(containerName)
+	if err != nil || current.Status != RUNNING || current.Pid != pid {
+		return
+	}
+	current.Status = STOP
+	current.Pid = "-"
+	if err = UpdateContainerInfoByName(&current); err != nil {
+		log.Errorf("更新容器%s信息异常 %v", containerName, err)
 	}
 }
 
-// RemoveContainer 删除容器
-func RemoveContainer(containerName string) {
-	containerInfo, err := GetContainerInfoByName(containerName)
+// 停止失败时撤销停止请求，容器之后自行退出时仍按重启策略处理；容器已退出或重新启动时不修改
+func resetManualStop(containerName string, pid string) {
+	unlock, err := lockContainer(containerName)
 	if err != nil {
-		log.Errorf("获取容器信息 %s 异常 %v", containerName, err)
 		return
 	}
-	if containerInfo.Status != STOP {
-		log.Errorf("无法删除正在运行的容器")
+	defer unlock()
+	current, err := GetContainerInfoByName(containerName)
+	if err != nil || current.Status != RUNNING || current.Pid != pid || !current.ManualStop {
 		return
 	}
+	current.ManualStop = false
+	if err = UpdateContainerInfoByName(&current); err != nil {
+		log.Errorf("更新容器%s信息异常 %v", containerName, err)
+	}
+}
+
+// KillContainer 向运行中容器的init进程发送信号，不改变容器状态；进程因信号退出时由shim记录
+// 在容器的锁内检查状态并发送信号，shim记录退出后不会向已被复用的PID发送信号
+func KillContainer(containerName string, sig syscall.Signal) error {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	// 未运行的容器Pid为 -，不能发送信号
+	if containerInfo.Status != RUNNING {
+		return errdefs.Conflict("容器 %s 未运行", containerName)
+	}
+	pidInt, _ := strconv.Atoi(containerInfo.Pid)
+	if err = syscall.Kill(pidInt, sig); err != nil {
+		return fmt.Errorf("向容器%s的进程%d发送信号%s异常 %v", containerName, pidInt, unix.SignalName(sig), err)
+	}
+	RecordEvent(&containerInfo, EventKill)
+	return nil
+}
+
+// ParseSignal 解析信号名或信号值，如 SIGHUP、HUP、1
+func ParseSignal(signal string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(signal); err == nil {
+		if num <= 0 || num > 64 {
+			return 0, errdefs.Invalid("无效的信号 %s", signal)
+		}
+		return syscall.Signal(num), nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, errdefs.Invalid("无效的信号 %s", signal)
+}
+
+// RemoveContainer 删除容器
+func RemoveContainer(containerName string) error {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if containerInfo.Status == RUNNING {
+		return errdefs.Conflict("无法删除正在运行的容器 %s", containerName)
+	}
 	// 从网络中断开连接（容器进入STOP状态时就已会自动删除veth接口）
 	// network.DisconnectFromNetwork(containerInfo.NetworkName, containerInfo.Id)
-	// 拼接配置文件路径
-	dirURL := fmt.Sprintf(DefaultInfoPath, containerName)
-	if err := os.RemoveAll(dirURL); err != nil {
-		log.Errorf("删除配置文件 %s 异常 %v", dirURL, err)
-		return
+	if err := RemoveContainerDirs(containerName); err != nil {
+		return err
+	}
+	// 重新启动时沿用的IP地址在容器删除后释放
+	if err := network.ReleaseIP(containerInfo.NetworkName, containerInfo.IPAddress); err != nil {
+		log.Errorf("释放容器 %s 的IP地址 %s 异常 %v", containerName, containerInfo.IPAddress, err)
 	}
 	DeleteWorkSpace(containerInfo.Volume, containerName)
-	fmt.Printf("容器: %s, ID: %s, 已删除\n", containerName, containerInfo.Id)
+	RecordEvent(&containerInfo, EventDestroy)
+	return nil
 }
 
-// ExecContainer 在容器中执行命令
-func ExecContainer(containerName string, cmdArry []string) {
+// ExportContainer 将容器文件系统的合并视图打包为tar写入writer，保留属主、扩展属性、硬链接与设备文件
+// 打包期间持有容器的锁，rm不会删除挂载中的容器层，start不会同时挂载
+func ExportContainer(containerName string, writer io.Writer) error {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if err = CheckLayerReadable(&containerInfo); err != nil {
+		return err
+	}
+	rootfs, unmount, err := MountRootfs(&containerInfo)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+	return image.TarDir(rootfs, writer)
+}
+
+// ContainerChanges 获取容器层相对镜像的文件变更，A新增、C修改、D删除
+// 比较期间持有容器的锁，rm与restart不会同时修改容器层
+func ContainerChanges(containerName string) ([]image.Change, error) {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return nil, errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if err = CheckLayerReadable(&containerInfo); err != nil {
+		return nil, err
+	}
+	imgName := containerInfo.ImageID
+	if imgName == "" {
+		imgName = containerInfo.Image
+	}
+	lowerDirs, err := CreateReadOnlyLayer(imgName)
+	if err != nil {
+		return nil, err
+	}
+	// 判断文件是新增还是修改，需要镜像各层的合并视图
+	lowerRoot, unmount, err := mountReadOnlyLayers(lowerDirs, containerName+"-image-")
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+	return image.Changes(fmt.Sprintf(WriteLayerPath, containerName), lowerRoot)
+}
+
+// ExecContainer 在运行中的容器中执行命令，命令的标准输入输出为stdin、stdout、stderr，返回命令的退出码
+func ExecContainer(containerName string, cmdArry []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return 0, errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if containerInfo.Status != RUNNING {
+		return 0, errdefs.Conflict("容器 %s 未运行", containerName)
+	}
 	pid := containerInfo.Pid
 
+	// 逐个转义参数后交给容器中的shell执行，保留参数的边界
+	cmd, err := execCommand(&containerInfo, shellQuote(cmdArry))
 	if err != nil {
-		log.Errorf("获取容器信息 %s 异常 %v", containerName, err)
-		return
+		return 0, err
 	}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// 命令退出后不再等待标准输入，输入端可能一直没有数据
+	cmd.WaitDelay = execWaitDelay
+	RecordEvent(&containerInfo, EventExec)
+	// 启动command，命令的退出码不作为执行异常
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !errors.Is(err, exec.ErrWaitDelay) && !errors.As(err, &exitErr) {
+		return 0, fmt.Errorf("执行容器 %s, PID: %s, 异常: %v", containerName, pid, err)
+	}
+	return exitCode(cmd.ProcessState.Sys().(syscall.WaitStatus)), nil
+}
 
-	// 拼接command参数
-	cmdStr := strings.Join(cmdArry, " ")
+// 构造在容器的namespace中以shell执行cmdStr的命令，命令的退出码即cmdStr的退出码
+func execCommand(containerInfo *ContainerInfo, cmdStr string) (*exec.Cmd, error) {
 	// 预定义command
-	cmd := exec.Command("/proc/self/exe", "exec") // 传递exec，会在容器进程内再运行一次exec方法
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	// 通过环境变量向cgo定义的nsenter传递参数
-	_ = os.Setenv(nsenter.EnvExecPid, pid)
-	_ = os.Setenv(nsenter.EnvExecCmd, cmdStr)
+	cmd, err := fockkerCommand("exec") // 传递exec，会在容器进程内再运行一次exec方法
+	if err != nil {
+		return nil, fmt.Errorf("获取fockker可执行文件异常 %v", err)
+	}
+	pid := containerInfo.Pid
 	// 根据PID获取进程的environments。将 当前环境变量、容器内环境变量 合并添加到command
+	// 通过环境变量向cgo定义的nsenter传递参数，只对该命令生效
 	containerEnvs := getEnvsByPid(pid)
 	cmd.Env = append(os.Environ(), containerEnvs...)
-	// 启动command
-	if err := cmd.Run(); err != nil {
-		log.Errorf("执行容器 %s, PID: %s, 异常: %v", containerName, pid, err)
+	cmd.Env = append(cmd.Env, nsenter.EnvExecPid+"="+pid, nsenter.EnvExecCmd+"="+cmdStr)
+	return cmd, nil
+}
+
+// 将参数列表转换为shell命令，含有特殊字符的参数以单引号包裹，shell解析后得到原参数列表
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, shellSafeChars) == "" {
+			quoted[i] = arg
+			continue
+		}
+		// 单引号内不能包含单引号，以 '\'' 结束引号、转义后重新开始
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 	}
+	return strings.Join(quoted, " ")
 }
 
 // 根据进程PID获取environments
@@ -0,0 +1,25 @@
+package container
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// 转义后的命令经shell解析，应得到原参数列表
+func TestShellQuote(t *testing.T) {
+	args := []string{"sh", "-c", "echo $HOME | wc -l", "", "it's", "a b", `"quoted"`, "*", "semi;colon", "new\nline"}
+	output, err := exec.Command("/bin/sh", "-c", `printf '%s\0' `+shellQuote(args)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	if len(parsed) != len(args) {
+		t.Fatalf("解析得到 %q，应为 %q", parsed, args)
+	}
+	for i := range args {
+		if parsed[i] != args[i] {
+			t.Errorf("第%d个参数为 %q，应为 %q", i, parsed[i], args[i])
+		}
+	}
+}
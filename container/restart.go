@@ -0,0 +1,168 @@
+package container
+
+import (
+	"fmt"
+	"fockker/constants"
+	"fockker/container/cgroups"
+	"fockker/errdefs"
+	"fockker/network"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRestartPolicy 解析重启策略，返回策略名与on-failure的最大重启次数（0为不限制），为空时为no
+func ParseRestartPolicy(policy string) (string, int, error) {
+	if policy == "" {
+		return RestartNo, 0, nil
+	}
+	name, count, hasCount := strings.Cut(policy, ":")
+	switch name {
+	case RestartNo, RestartAlways, RestartUnlessStopped:
+		if hasCount {
+			return "", 0, errdefs.Invalid("重启策略 %s 不支持指定最大重启次数", name)
+		}
+		return name, 0, nil
+	case RestartOnFailure:
+		if !hasCount {
+			return name, 0, nil
+		}
+		maxRetries, err := strconv.Atoi(count)
+		if err != nil || maxRetries < 0 {
+			return "", 0, errdefs.Invalid("无效的最大重启次数 %s", count)
+		}
+		return name, maxRetries, nil
+	}
+	return "", 0, errdefs.Invalid("不支持的重启策略 %s", policy)
+}
+
+// 容器退出后是否按重启策略重新启动，通过stop停止的容器不重启
+func shouldRestart(containerInfo *ContainerInfo) bool {
+	if containerInfo.Status == STOP {
+		return false
+	}
+	name, maxRetries, err := ParseRestartPolicy(containerInfo.RestartPolicy)
+	if err != nil {
+		return false
+	}
+	switch name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		return containerInfo.ExitCode != 0 && (maxRetries == 0 || containerInfo.RestartCount < maxRetries)
+	}
+	return false
+}
+
+// 重启前的等待时间，从restartBackoffBase开始随重启次数翻倍，不超过restartBackoffMax
+// 容器运行超过restartResetAfter后才退出时恢复为初始值
+func restartDelay(containerInfo *ContainerInfo) time.Duration {
+	startedAt, startErr := time.ParseInLocation(TimeLayout, containerInfo.StartedAt, time.Local)
+	finishedAt, finishErr := time.ParseInLocation(TimeLayout, containerInfo.FinishedAt, time.Local)
+	if startErr == nil && finishErr == nil && finishedAt.Sub(startedAt) >= restartResetAfter {
+		return restartBackoffBase
+	}
+	delay := restartBackoffBase
+	for i := 0; i < containerInfo.RestartCount && delay < restartBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, restartBackoffMax)
+}
+
+// 由shim在容器退出并清理后调用，等待后重新启动已标记为重启中的容器
+// 等待期间容器被stop、start或删除时不再重启；检查状态与启动在容器的锁内完成，避免覆盖同时到达的stop
+func restartContainer(containerInfo *ContainerInfo) {
+	time.Sleep(restartDelay(containerInfo))
+	unlock, err := lockContainer(containerInfo.Name)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	current, err := GetContainerInfoByName(containerInfo.Name)
+	if err != nil || current.Status != RESTARTING {
+		return
+	}
+	// 重新连接网络需要加载网络配置
+	network.InitNetwork()
+	if err = startContainer(containerInfo.Name, true); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// RestartContainers 由fockkerd启动时调用，重新启动按重启策略应当运行的容器
+// shim已不存在的运行中容器（如shim被强制终止）在读取容器信息时已清理并标记为已退出
+// always的容器即使通过stop停止也重新启动，unless-stopped的容器通过stop停止后不再启动，等待重启中的容器立即启动
+func RestartContainers() {
+	containers, err := GetContainers()
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	for _, containerInfo := range containers {
+		// 重启中的容器由仍在运行的shim重新启动
+		name, _, err := ParseRestartPolicy(containerInfo.RestartPolicy)
+		if err != nil || containerInfo.Status == RUNNING || (containerInfo.Status == RESTARTING && shimAlive(containerInfo)) {
+			continue
+		}
+		restart := containerInfo.Status == RESTARTING ||
+			name == RestartAlways ||
+			(name == RestartUnlessStopped && containerInfo.Status != STOP)
+		if !restart {
+			continue
+		}
+		if err = StartContainer(containerInfo.Name); err != nil {
+			log.Errorf("%v", err)
+			continue
+		}
+		log.Infof("容器 %s 已按重启策略 %s 重新启动", containerInfo.Name, containerInfo.RestartPolicy)
+	}
+}
+
+// 在容器锁内重新读取容器信息，仍为RUNNING且shim不存在时才标记为已退出，返回最新的容器信息
+// 读取与加锁之间容器可能正被start等操作重新启动，不在锁内检查会把正在启动的容器标记为已退出
+func sweepStaleContainer(containerInfo *ContainerInfo) *ContainerInfo {
+	unlock, err := lockContainer(containerInfo.Name)
+	if err != nil {
+		log.Errorf("%v", err)
+		return containerInfo
+	}
+	defer unlock()
+	current, err := GetContainerInfoByName(containerInfo.Name)
+	if err != nil {
+		log.Errorf("获取容器信息异常 %v", err)
+		return containerInfo
+	}
+	if current.Status == RUNNING && !shimAlive(&current) {
+		markStaleExited(&current)
+	}
+	return &current
+}
+
+// 清理shim已不存在的运行中容器的cgroup、网络端点与挂载点，标记为已退出
+// 退出码未知，记为staleExitCode；宿主机重启后这些资源通常已不存在，清理失败只记录日志
+func markStaleExited(containerInfo *ContainerInfo) {
+	log.Warnf("容器 %s 的shim已不存在，标记为已退出", containerInfo.Name)
+	cgroupManager := cgroups.NewCgroupManager(fmt.Sprintf("%s/%s", constants.AppName, containerInfo.Name))
+	if err := cgroupManager.Destroy(); err != nil {
+		log.Errorf("容器 %s 的cgroup删除异常 %v", containerInfo.Name, err)
+	}
+	if containerInfo.NetworkName != "" {
+		if err := network.ReleaseFromNetwork(containerInfo.NetworkName, containerInfo.Id, containerInfo.PortMapping, containerInfo.IPAddress); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+	UnmountWorkSpace(containerInfo.Name)
+	if containerInfo.ManualStop {
+		containerInfo.Status = STOP
+	} else {
+		containerInfo.Status = Exit
+	}
+	containerInfo.Pid = "-"
+	containerInfo.ExitCode = staleExitCode
+	containerInfo.FinishedAt = time.Now().Format(TimeLayout)
+	if err := UpdateContainerInfoByName(containerInfo); err != nil {
+		log.Errorf("更新容器%s信息异常 %v", containerInfo.Name, err)
+	}
+	RecordEvent(containerInfo, EventDie)
+}
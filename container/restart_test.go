@@ -0,0 +1,73 @@
+package container
+
+import (
+	"errors"
+	"fockker/errdefs"
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy     string
+		name       string
+		maxRetries int
+		invalid    bool
+	}{
+		{"", RestartNo, 0, false},
+		{"no", RestartNo, 0, false},
+		{"always", RestartAlways, 0, false},
+		{"unless-stopped", RestartUnlessStopped, 0, false},
+		{"on-failure", RestartOnFailure, 0, false},
+		{"on-failure:3", RestartOnFailure, 3, false},
+		{"on-failure:0", RestartOnFailure, 0, false},
+		{"on-failure:-1", "", 0, true},
+		{"on-failure:abc", "", 0, true},
+		{"always:3", "", 0, true},
+		{"sometimes", "", 0, true},
+	}
+	for _, test := range tests {
+		name, maxRetries, err := ParseRestartPolicy(test.policy)
+		if test.invalid {
+			if !errors.Is(err, errdefs.ErrInvalid) {
+				t.Errorf("%q: 应返回ErrInvalid，实际为 %v", test.policy, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.policy, err)
+			continue
+		}
+		if name != test.name || maxRetries != test.maxRetries {
+			t.Errorf("%q: 解析为 (%s, %d)，应为 (%s, %d)", test.policy, name, maxRetries, test.name, test.maxRetries)
+		}
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	startedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name         string
+		restartCount int
+		ran          time.Duration // 容器运行的时长，为0时不记录启动与退出时间
+		expected     time.Duration
+	}{
+		{"首次重启", 0, 0, restartBackoffBase},
+		{"第二次重启翻倍", 1, 0, 2 * restartBackoffBase},
+		{"第四次重启", 3, 0, 8 * restartBackoffBase},
+		{"不超过最长等待时间", 20, 0, restartBackoffMax},
+		{"重启次数很大时不溢出", 1000, 0, restartBackoffMax},
+		{"运行时间较短", 5, restartResetAfter - time.Second, 32 * restartBackoffBase},
+		{"运行足够长后恢复为初始值", 5, restartResetAfter, restartBackoffBase},
+	}
+	for _, test := range tests {
+		containerInfo := &ContainerInfo{RestartCount: test.restartCount}
+		if test.ran > 0 {
+			containerInfo.StartedAt = startedAt.Format(TimeLayout)
+			containerInfo.FinishedAt = startedAt.Add(test.ran).Format(TimeLayout)
+		}
+		if delay := restartDelay(containerInfo); delay != test.expected {
+			t.Errorf("%s: 等待 %v，应为 %v", test.name, delay, test.expected)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package container
+
+import (
+	"fmt"
+	"fockker/errdefs"
+	"fockker/network"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// RunContainer 根据入参创建并启动容器进程，containerInfo中为用户指定的镜像、命令、挂载、网络等运行参数
+// 未指定的命令、环境变量、工作目录与用户使用镜像配置，entrypoint不为nil时替换镜像的Entrypoint
+// 容器统一由shim持有标准输入输出，创建了终端的容器通过attach交互
+func RunContainer(containerInfo *ContainerInfo, entrypoint []string) error {
+	// 不指定容器名则使用ID作为容器名
+	containerInfo.Id = GenerateContainerID()
+	if containerInfo.Name == "" {
+		containerInfo.Name = containerInfo.Id
+	}
+	containerName := containerInfo.Name
+	// 提前判断containerName是否重复，避免拉取镜像后才发现重名；保存容器信息前再原子地占用容器名
+	_, err := GetContainerInfoByName(containerName)
+	if err == nil {
+		return errdefs.Conflict("容器 %s 创建失败: 该名称已存在", containerName)
+	}
+	if containerInfo.NetworkName == "" {
+		// 加入默认网络
+		containerInfo.NetworkName = network.DefaultBridgeName
+	}
+	networkType, err := network.GetNetworkType(containerInfo.NetworkName)
+	if err != nil {
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	// host网络直接使用宿主机端口，端口映射无意义
+	if networkType == network.Host && len(containerInfo.PortMapping) > 0 {
+		log.Warnf("host网络下忽略端口映射 %q", containerInfo.PortMapping)
+		containerInfo.PortMapping = nil
+	}
+	// 独立UTS namespace的容器默认以容器ID作为主机名，host网络的容器沿用宿主机主机名
+	if containerInfo.Hostname == "" && networkType != network.Host {
+		containerInfo.Hostname = containerInfo.Id
+	}
+	if _, _, err = ParseRestartPolicy(containerInfo.RestartPolicy); err != nil {
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	if _, err = ParseHealthOnFailure(containerInfo.HealthOnFailure); err != nil {
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	if err = ValidateLogOpts(containerInfo.LogDriver, containerInfo.LogOpts); err != nil {
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	// 记录镜像ID，镜像标签之后指向其他镜像时，容器仍使用创建时的镜像
+	img, err := ResolveImage(containerInfo.Image)
+	if err != nil {
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	containerInfo.ImageID = img.ID
+	if err = ApplyImageConfig(containerInfo, img.Config, entrypoint); err != nil {
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	// 占用容器名后保存容器信息，由shim创建容器进程
+	if err = claimContainerName(containerName); err != nil {
+		return err
+	}
+	if err = RecordContainerInfo(containerInfo); err != nil {
+		_ = RemoveContainerDirs(containerName)
+		return fmt.Errorf("保存容器信息异常 %v", err)
+	}
+	connected := false
+	err = StartShim(containerInfo, networkType == network.Host, func(pid int) error {
+		containerInfo.Pid = strconv.Itoa(pid)
+		containerInfo.Status = RUNNING
+		containerInfo.StartedAt = time.Now().Format(TimeLayout)
+		// 加入网络
+		ipAddress, err := network.ConnectToNetwork(containerInfo.NetworkName, containerInfo.Id, containerInfo.PortMapping, containerInfo.Pid)
+		if err == nil {
+			connected = true
+			if ipAddress != "" {
+				containerInfo.IPAddress = ipAddress
+			}
+		}
+		return UpdateContainerInfoByName(containerInfo)
+	})
+	if err != nil {
+		// 未能启动的容器不保留，已连接的网络端点与分配的IP地址一并释放
+		if connected {
+			releaseNetwork(containerInfo)
+		}
+		_ = RemoveContainerDirs(containerName)
+		if err := network.ReleaseIP(containerInfo.NetworkName, containerInfo.IPAddress); err != nil {
+			log.Errorf("释放容器 %s 的IP地址 %s 异常 %v", containerName, containerInfo.IPAddress, err)
+		}
+		DeleteWorkSpace(containerInfo.Volume, containerName)
+		return fmt.Errorf("容器 %s 创建失败: %w", containerName, err)
+	}
+	RecordEvent(containerInfo, EventCreate)
+	RecordEvent(containerInfo, EventStart)
+	return nil
+}
+
+// 容器进程未能启动时断开已连接的网络端点，删除端口映射的iptables规则
+func releaseNetwork(containerInfo *ContainerInfo) {
+	if err := network.ReleaseFromNetwork(containerInfo.NetworkName, containerInfo.Id, containerInfo.PortMapping, containerInfo.IPAddress); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// StartContainer 重新启动已停止的容器，复用保存的容器信息与容器层，按重启策略重启的次数清零
+func StartContainer(containerName string) error {
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 启动失败: 该容器不存在", containerName)
+	}
+	defer unlock()
+	return startContainer(containerName, false)
+}
+
+// 启动已停止的容器，restart为true时为按重启策略自动重启，累计重启次数；调用方需持有容器的锁
+func startContainer(containerName string, restart bool) error {
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 启动失败: 该容器不存在", containerName)
+	}
+	if containerInfo.Status == RUNNING {
+		return errdefs.Conflict("容器: %s, ID: %s, 已为%s", containerName, containerInfo.Id, containerInfo.Status)
+	}
+	networkType, err := network.GetNetworkType(containerInfo.NetworkName)
+	if err != nil {
+		return fmt.Errorf("容器 %s 启动失败: %w", containerName, err)
+	}
+	if restart {
+		containerInfo.RestartCount++
+	} else {
+		containerInfo.RestartCount = 0
+	}
+	containerInfo.ManualStop = false
+	// 卸载早期版本停止时残留的联合挂载点，容器层保留，随后由shim重新挂载
+	UnmountWorkSpace(containerName)
+	connected := false
+	err = StartShim(&containerInfo, networkType == network.Host, func(pid int) error {
+		containerInfo.Pid = strconv.Itoa(pid)
+		containerInfo.Status = RUNNING
+		containerInfo.StartedAt = time.Now().Format(TimeLayout)
+		// 重新加入网络，沿用之前分配的IP地址
+		ipAddress, err := network.ReconnectToNetwork(containerInfo.NetworkName, containerInfo.Id, containerInfo.PortMapping,
+			containerInfo.Pid, containerInfo.IPAddress)
+		if err == nil {
+			connected = true
+			containerInfo.IPAddress = ipAddress
+		}
+		return UpdateContainerInfoByName(&containerInfo)
+	})
+	if err != nil {
+		// shim未能启动容器时，容器仍为已退出，断开已连接的网络端点，IP地址在容器删除前保留
+		if connected {
+			releaseNetwork(&containerInfo)
+		}
+		if current, getErr := GetContainerInfoByName(containerName); getErr == nil && (current.Status == RUNNING || current.Status == RESTARTING) {
+			current.Status = Exit
+			current.Pid = "-"
+			_ = UpdateContainerInfoByName(&current)
+		}
+		return fmt.Errorf("容器 %s 启动失败: %w", containerName, err)
+	}
+	RecordEvent(&containerInfo, EventStart)
+	return nil
+}
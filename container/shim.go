@@ -0,0 +1,333 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fockker/constants"
+	"fockker/container/cgroups"
+	"fockker/network"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// shim启动的需要获取退出状态的子进程，如健康检查命令
+var (
+	reapMutex   sync.Mutex
+	reapWaiters = map[int]chan syscall.WaitStatus{}
+)
+
+// StartShim 启动容器的shim进程，由shim创建容器进程并作为其父进程一直运行到容器退出
+// shim报告容器进程的PID后调用connect（记录PID、连接网络），成功后shim设置cgroup并发送启动规格，容器启动后返回
+// 容器信息需已记录，hostNetwork为true时容器与宿主机共享网络栈
+func StartShim(containerInfo *ContainerInfo, hostNetwork bool, connect func(pid int) error) error {
+	args := []string{"shim"}
+	if hostNetwork {
+		args = append(args, "--host-network")
+	}
+	args = append(args, containerInfo.Name)
+	cmd, err := fockkerCommand(args...)
+	if err != nil {
+		return fmt.Errorf("获取fockker可执行文件异常 %v", err)
+	}
+	// 两个方向的同步管道，从3号文件描述符开始传递给shim
+	toShimRead, toShimWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("管道创建异常 %v", err)
+	}
+	fromShimRead, fromShimWrite, err := os.Pipe()
+	if err != nil {
+		_ = toShimRead.Close()
+		_ = toShimWrite.Close()
+		return fmt.Errorf("管道创建异常 %v", err)
+	}
+	defer func() {
+		_ = toShimWrite.Close()
+		_ = fromShimRead.Close()
+	}()
+
+	cmd.ExtraFiles = []*os.File{toShimRead, fromShimWrite}
+	// 创建新会话，调用方退出或终端关闭时shim与容器继续运行
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	_ = toShimRead.Close()
+	_ = fromShimWrite.Close()
+	if err != nil {
+		return fmt.Errorf("容器 %s 的shim启动失败: %v", containerInfo.Name, err)
+	}
+	// shim在容器退出后退出，由调用方回收；调用方先于shim退出时shim由系统回收
+	go func() {
+		_ = cmd.Wait()
+	}()
+	// 由connect随容器PID一并记录
+	containerInfo.ShimPid = cmd.Process.Pid
+	containerInfo.BootId = bootId()
+
+	decoder := json.NewDecoder(fromShimRead)
+	encoder := json.NewEncoder(toShimWrite)
+	message, err := readShimMessage(decoder)
+	if err != nil {
+		return err
+	}
+	if err = connect(message.Pid); err != nil {
+		// 通知shim终止容器进程并清理
+		_ = encoder.Encode(shimMessage{Error: err.Error()})
+		return err
+	}
+	if err = encoder.Encode(shimMessage{Pid: message.Pid}); err != nil {
+		return fmt.Errorf("容器 %s 的shim通信异常 %v", containerInfo.Name, err)
+	}
+	_, err = readShimMessage(decoder)
+	return err
+}
+
+// 读取shim的消息，shim报告失败或提前退出时返回错误
+func readShimMessage(decoder *json.Decoder) (*shimMessage, error) {
+	var message shimMessage
+	if err := decoder.Decode(&message); err != nil {
+		return nil, fmt.Errorf("shim异常退出: %v", err)
+	}
+	if message.Error != "" {
+		return nil, fmt.Errorf("%s", message.Error)
+	}
+	return &message, nil
+}
+
+// RunShim 容器的shim进程，成为容器进程的父进程与子进程回收者（PR_SET_CHILD_SUBREAPER）
+// shim持有容器的标准输入输出，写入容器日志并通过attach套接字转发给客户端，避免缓冲区写满导致容器阻塞
+// 容器运行期间定期执行健康检查；容器退出后记录退出码、退出时间与是否OOM，依次清理cgroup、网络端点与挂载点，再按重启策略重新启动容器
+func RunShim(containerName string, hostNetwork bool) {
+	syncRead := os.NewFile(3, "shim-sync-read")
+	syncWrite := os.NewFile(4, "shim-sync-write")
+	decoder := json.NewDecoder(syncRead)
+	encoder := json.NewEncoder(syncWrite)
+	fail := func(err error) {
+		_ = encoder.Encode(shimMessage{Error: err.Error()})
+		os.Exit(1)
+	}
+
+	// 容器进程的后代被其父进程遗弃时由shim收养，退出后由shim回收
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		fail(fmt.Errorf("设置子进程回收者异常 %v", err))
+	}
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		fail(fmt.Errorf("容器 %s 不存在", containerName))
+	}
+	// 早期版本创建的容器未记录镜像ID，使用镜像名
+	imgName := containerInfo.ImageID
+	if imgName == "" {
+		imgName = containerInfo.Image
+	}
+	processCmd, writePipe, stdio := NewContainerProcess(imgName, containerName, containerInfo.Tty,
+		containerInfo.OpenStdin, containerInfo.Volume, hostNetwork)
+	if processCmd == nil {
+		fail(fmt.Errorf("容器初始化进程异常"))
+	}
+	if err := processCmd.Start(); err != nil {
+		fail(fmt.Errorf("容器初始化进程启动失败: %v", err))
+	}
+	stdio.CloseChild()
+	pid := processCmd.Process.Pid
+	cgroupManager := cgroups.NewCgroupManager(fmt.Sprintf("%s/%s", constants.AppName, containerName))
+
+	// 等待调用方记录PID并连接网络，失败时终止容器进程
+	_ = encoder.Encode(shimMessage{Pid: pid})
+	var reply shimMessage
+	if err := decoder.Decode(&reply); err != nil || reply.Error != "" {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		waitContainer(pid)
+		stdio.Close()
+		_ = cgroupManager.Destroy()
+		UnmountWorkSpace(containerName)
+		os.Exit(1)
+	}
+
+	// 重新读取调用方更新后的容器信息，如分配的IP地址
+	if current, err := GetContainerInfoByName(containerName); err == nil {
+		containerInfo = current
+	}
+	if containerInfo.Resource != nil {
+		_ = cgroupManager.Set(containerInfo.Resource)
+	}
+	_ = cgroupManager.Apply(pid)
+	broker, err := NewBroker(&containerInfo, stdio)
+	if err != nil {
+		log.Errorf("%v", err)
+	} else {
+		broker.Run()
+	}
+	// 容器进程初始化启动完成后，通过管道向其发送启动规格（如top、ls -l等用户在run输入的参数，以及环境变量、工作目录等）
+	if err := SendInitSpec(NewInitSpec(&containerInfo), writePipe); err != nil {
+		log.Errorf("%v", err)
+	}
+	_ = encoder.Encode(shimMessage{Pid: pid})
+	_ = syncRead.Close()
+	_ = syncWrite.Close()
+
+	// shim不因终端信号退出，终止信号转发给容器进程
+	signal.Ignore(syscall.SIGHUP)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for sig := range sigCh {
+			_ = syscall.Kill(pid, sig.(syscall.Signal))
+		}
+	}()
+
+	// 定期在容器中执行健康检查，unhealthy且设置为restart时终止容器进程，退出后重新启动
+	healthDone := make(chan struct{})
+	var unhealthy atomic.Bool
+	if containerInfo.Healthcheck != nil {
+		go monitorHealth(&containerInfo, healthDone, func() {
+			log.Warnf("容器 %s 健康检查失败，即将重新启动", containerName)
+			unhealthy.Store(true)
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		})
+	}
+
+	status := waitContainer(pid)
+	finishedAt := time.Now().Format(TimeLayout)
+	close(healthDone)
+	// 转发完容器剩余的输出后关闭attach套接字与日志
+	if broker != nil {
+		broker.Close(shimBrokerDrain)
+	}
+	// 容器已通过start重新启动时，cgroup、网络与挂载点由新的shim负责管理
+	current, infoErr := GetContainerInfoByName(containerName)
+	if infoErr == nil && current.Status == RUNNING && current.Pid != strconv.Itoa(pid) {
+		os.Exit(0)
+	}
+	oomKilled := cgroupManager.OOMKilled()
+	if err := cgroupManager.Destroy(); err != nil {
+		log.Errorf("容器 %s 的cgroup删除异常 %v", containerName, err)
+	}
+	if infoErr != nil {
+		// 容器已被删除，无需更新状态
+		os.Exit(0)
+	}
+	if current.NetworkName != "" {
+		if err := network.ReleaseFromNetwork(current.NetworkName, current.Id, current.PortMapping, current.IPAddress); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+	UnmountWorkSpace(containerName)
+
+	// 在容器的锁内重新读取并记录退出状态，不覆盖清理期间到达的stop
+	unlock, err := lockContainer(containerName)
+	if err != nil {
+		// 容器已被删除，无需更新状态
+		os.Exit(0)
+	}
+	if latest, err := GetContainerInfoByName(containerName); err == nil {
+		current = latest
+	}
+	// 通过stop停止的容器为STOP状态
+	if current.ManualStop || current.Status == STOP {
+		current.Status = STOP
+	} else {
+		current.Status = Exit
+	}
+	current.Pid = "-"
+	current.ExitCode = exitCode(status)
+	current.FinishedAt = finishedAt
+	current.OOMKilled = oomKilled
+	if err := UpdateContainerInfoByName(&current); err != nil {
+		log.Errorf("更新容器%s信息异常 %v", containerName, err)
+	}
+	RecordEvent(&current, EventDie)
+	// 按重启策略重新启动容器，由新的shim接管；因unhealthy被终止的容器总是重新启动
+	restart := shouldRestart(&current) || (unhealthy.Load() && current.Status != STOP)
+	if restart {
+		current.Status = RESTARTING
+		if err := UpdateContainerInfoByName(&current); err != nil {
+			log.Errorf("更新容器%s信息异常 %v", containerName, err)
+			restart = false
+		}
+	}
+	unlock()
+	if restart {
+		restartContainer(&current)
+	}
+	os.Exit(0)
+}
+
+// 回收子进程直到容器进程退出，返回容器进程的退出状态；被收养的其他进程退出后一并回收
+// 通过startReaped启动的子进程，退出状态发送给启动方
+func waitContainer(pid int) syscall.WaitStatus {
+	for {
+		var status syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || wpid == pid {
+			return status
+		}
+		reapMutex.Lock()
+		if waiter, exists := reapWaiters[wpid]; exists {
+			waiter <- status
+			delete(reapWaiters, wpid)
+		}
+		reapMutex.Unlock()
+	}
+}
+
+// 在shim中启动子进程，子进程由waitContainer回收，返回接收其退出状态的通道，不能再调用cmd.Wait
+// 标准输入输出需为 *os.File 或nil
+func startReaped(cmd *exec.Cmd) (<-chan syscall.WaitStatus, error) {
+	reapMutex.Lock()
+	defer reapMutex.Unlock()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	waiter := make(chan syscall.WaitStatus, 1)
+	reapWaiters[cmd.Process.Pid] = waiter
+	return waiter, nil
+}
+
+// 宿主机本次启动的boot id，读取失败时为空
+func bootId() string {
+	content, err := os.ReadFile(BootIdPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// 记录的shim是否仍在运行：宿主机重启后或shim的PID已被其他进程复用时为false
+// 早期版本的容器未记录shim的PID，检查容器进程是否存在
+func shimAlive(containerInfo *ContainerInfo) bool {
+	if containerInfo.BootId != "" && containerInfo.BootId != bootId() {
+		return false
+	}
+	if containerInfo.ShimPid <= 0 {
+		pid, err := strconv.Atoi(containerInfo.Pid)
+		return err == nil && pid > 0 && !errors.Is(syscall.Kill(pid, 0), syscall.ESRCH)
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", containerInfo.ShimPid))
+	if err != nil {
+		return false
+	}
+	// shim的命令行为 fockker [全局参数] shim [--host-network] <容器名>
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	return len(args) > 1 && args[len(args)-1] == containerInfo.Name && slices.Contains(args, "shim")
+}
+
+// 退出状态对应的退出码，被信号终止时为128+信号值
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
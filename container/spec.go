@@ -0,0 +1,360 @@
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"fockker/errdefs"
+	"fockker/image"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// InitSpecVersion init规格版本，父子进程版本不一致时容器拒绝启动
+const InitSpecVersion = "1"
+
+// 容器内默认的PATH环境变量
+const defaultPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// InitSpec 宿主机通过fd 3的管道发送给容器init进程的启动规格
+type InitSpec struct {
+	Version  string   `json:"version"`  // 规格版本
+	Args     []string `json:"args"`     // 容器内运行的命令与参数
+	Env      []string `json:"env"`      // 容器内的环境变量
+	Cwd      string   `json:"cwd"`      // 容器内的工作目录
+	Hostname string   `json:"hostname"` // 容器主机名，为空时不设置
+	User     string   `json:"user"`     // 运行用户，格式为 user[:group]，支持用户名或uid
+	Mounts   []Mount  `json:"mounts"`   // pivotRoot后在容器内挂载的文件系统
+	Rlimits  []Rlimit `json:"rlimits"`  // 进程资源限制
+}
+
+// Mount 容器内的挂载信息
+type Mount struct {
+	Source      string   `json:"source"`      // 挂载源
+	Destination string   `json:"destination"` // 容器内的挂载点
+	Type        string   `json:"type"`        // 文件系统类型
+	Options     []string `json:"options"`     // 挂载选项，如nosuid、mode=755
+}
+
+// Rlimit 进程资源限制，Type格式为RLIMIT_NOFILE
+type Rlimit struct {
+	Type string `json:"type"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// 资源限制名称与setrlimit资源编号的对应关系
+var rlimitTypes = map[string]int{
+	"RLIMIT_CPU":        0,
+	"RLIMIT_FSIZE":      1,
+	"RLIMIT_DATA":       2,
+	"RLIMIT_STACK":      3,
+	"RLIMIT_CORE":       4,
+	"RLIMIT_RSS":        5,
+	"RLIMIT_NPROC":      6,
+	"RLIMIT_NOFILE":     7,
+	"RLIMIT_MEMLOCK":    8,
+	"RLIMIT_AS":         9,
+	"RLIMIT_LOCKS":      10,
+	"RLIMIT_SIGPENDING": 11,
+	"RLIMIT_MSGQUEUE":   12,
+	"RLIMIT_NICE":       13,
+	"RLIMIT_RTPRIO":     14,
+	"RLIMIT_RTTIME":     15,
+}
+
+// 挂载选项与mount flag的对应关系，未列出的选项作为data传递给文件系统
+var mountFlags = map[string]uintptr{
+	"ro":          syscall.MS_RDONLY,
+	"nosuid":      syscall.MS_NOSUID,
+	"nodev":       syscall.MS_NODEV,
+	"noexec":      syscall.MS_NOEXEC,
+	"strictatime": syscall.MS_STRICTATIME,
+	"relatime":    syscall.MS_RELATIME,
+	"noatime":     syscall.MS_NOATIME,
+	"bind":        syscall.MS_BIND,
+	"rbind":       syscall.MS_BIND | syscall.MS_REC,
+}
+
+// DefaultMounts 容器内默认挂载的proc与/dev
+func DefaultMounts() []Mount {
+	return []Mount{
+		// noexec：挂载后的文件系统中的可执行文件无法被执行
+		// nosuid：即使某个文件具有suid或sgid权限，也不会以文件拥有者的权限执行
+		// nodev：禁止在挂载的文件系统中使用设备文件
+		{Source: "proc", Destination: "/proc", Type: "proc", Options: []string{"noexec", "nosuid", "nodev"}},
+		// 将tmpfs挂载到/dev目录可为容器提供快速、临时且安全的环境，它会将文件存储在内存中，避免不必要的磁盘I/O
+		{Source: "tmpfs", Destination: "/dev", Type: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755"}},
+	}
+}
+
+// NewInitSpec 根据容器信息生成init规格
+func NewInitSpec(containerInfo *ContainerInfo) *InitSpec {
+	env := []string{defaultPathEnv}
+	if containerInfo.Hostname != "" {
+		env = append(env, "HOSTNAME="+containerInfo.Hostname)
+	}
+	// 用户设置的环境变量覆盖默认值
+	env = MergeEnv(env, containerInfo.Env)
+	return &InitSpec{
+		Version:  InitSpecVersion,
+		Args:     containerInfo.Cmd,
+		Env:      env,
+		Cwd:      containerInfo.WorkingDir,
+		Hostname: containerInfo.Hostname,
+		User:     containerInfo.User,
+		Mounts:   DefaultMounts(),
+		Rlimits:  containerInfo.Rlimits,
+	}
+}
+
+// ApplyImageConfig 以镜像配置补全用户未指定的运行参数
+// 运行命令为 Entrypoint + Cmd，用户指定的命令替换镜像的Cmd；
+// entrypoint不为nil时替换镜像的Entrypoint，此时镜像的Cmd不再生效
+// 环境变量以镜像的Env为基础，用户设置的同名变量覆盖镜像的值
+func ApplyImageConfig(containerInfo *ContainerInfo, config image.ImageConfig, entrypoint []string) error {
+	cmd := containerInfo.Cmd
+	if entrypoint == nil {
+		entrypoint = config.Entrypoint
+		if len(cmd) == 0 {
+			cmd = config.Cmd
+		}
+	}
+	containerInfo.Cmd = append(append([]string{}, entrypoint...), cmd...)
+	if len(containerInfo.Cmd) == 0 {
+		return fmt.Errorf("未指定运行命令，且镜像中没有Entrypoint与Cmd")
+	}
+	containerInfo.Env = MergeEnv(config.Env, containerInfo.Env)
+	if containerInfo.WorkingDir == "" {
+		containerInfo.WorkingDir = config.WorkingDir
+	}
+	if containerInfo.User == "" {
+		containerInfo.User = config.User
+	}
+	containerInfo.Healthcheck = MergeHealthConfig(containerInfo.Healthcheck, config.Healthcheck)
+	if containerInfo.StopSignal == "" {
+		containerInfo.StopSignal = config.StopSignal
+	}
+	return nil
+}
+
+// MergeEnv 合并环境变量，override中同名的变量覆盖base
+func MergeEnv(base []string, override []string) []string {
+	merged := make([]string, 0, len(base)+len(override))
+	index := map[string]int{}
+	for _, kv := range append(append([]string{}, base...), override...) {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if i, exists := index[key]; exists {
+			merged[i] = kv
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, kv)
+	}
+	return merged
+}
+
+// ParseUlimit 解析 name=soft[:hard] 格式的资源限制，如 nofile=1024:2048
+func ParseUlimit(ulimit string) (Rlimit, error) {
+	parts := strings.SplitN(ulimit, "=", 2)
+	if len(parts) != 2 {
+		return Rlimit{}, errdefs.Invalid("资源限制格式错误 %s", ulimit)
+	}
+	rlimitType := "RLIMIT_" + strings.ToUpper(parts[0])
+	if _, exists := rlimitTypes[rlimitType]; !exists {
+		return Rlimit{}, errdefs.Invalid("不支持的资源限制 %s", parts[0])
+	}
+	limits := strings.SplitN(parts[1], ":", 2)
+	soft, err := strconv.ParseUint(limits[0], 10, 64)
+	if err != nil {
+		return Rlimit{}, errdefs.Invalid("资源限制数值错误 %s", ulimit)
+	}
+	hard := soft
+	if len(limits) == 2 {
+		if hard, err = strconv.ParseUint(limits[1], 10, 64); err != nil {
+			return Rlimit{}, errdefs.Invalid("资源限制数值错误 %s", ulimit)
+		}
+	}
+	if soft > hard {
+		return Rlimit{}, errdefs.Invalid("资源限制 %s 的soft值大于hard值", ulimit)
+	}
+	return Rlimit{Type: rlimitType, Soft: soft, Hard: hard}, nil
+}
+
+// SendInitSpec 通过write管道向容器init进程发送JSON格式的启动规格
+func SendInitSpec(spec *InitSpec, writePipe *os.File) error {
+	defer func() {
+		if err := writePipe.Close(); err != nil {
+			log.Errorf(`write管道关闭异常`)
+		}
+	}()
+	if err := json.NewEncoder(writePipe).Encode(spec); err != nil {
+		return fmt.Errorf("write管道写入异常: %v", err)
+	}
+	return nil
+}
+
+// 从文件描述符获取read管道并读取启动规格
+func readInitSpec() (*InitSpec, error) {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	defer func() {
+		_ = pipe.Close()
+	}()
+	spec := &InitSpec{}
+	if err := json.NewDecoder(pipe).Decode(spec); err != nil {
+		return nil, fmt.Errorf("初始化read管道异常: %v", err)
+	}
+	if spec.Version != InitSpecVersion {
+		return nil, fmt.Errorf("不支持的init规格版本 %s", spec.Version)
+	}
+	return spec, nil
+}
+
+// 在容器内挂载文件系统
+func mountAll(mounts []Mount) error {
+	for _, m := range mounts {
+		var flags uintptr
+		var data []string
+		for _, option := range m.Options {
+			if flag, exists := mountFlags[option]; exists {
+				flags |= flag
+			} else {
+				data = append(data, option)
+			}
+		}
+		if err := os.MkdirAll(m.Destination, 0755); err != nil {
+			return fmt.Errorf("挂载点 %s 创建异常: %v", m.Destination, err)
+		}
+		if err := syscall.Mount(m.Source, m.Destination, m.Type, flags, strings.Join(data, ",")); err != nil {
+			return fmt.Errorf("%s挂载异常: %v", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+// 设置进程资源限制
+func setRlimits(rlimits []Rlimit) error {
+	for _, rlimit := range rlimits {
+		resource, exists := rlimitTypes[rlimit.Type]
+		if !exists {
+			return fmt.Errorf("不支持的资源限制 %s", rlimit.Type)
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}); err != nil {
+			return fmt.Errorf("设置资源限制 %s 异常: %v", rlimit.Type, err)
+		}
+	}
+	return nil
+}
+
+// ExecUser 解析后的容器内运行用户
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int
+	Home   string
+}
+
+// LookupUser 根据容器根目录下的/etc/passwd与/etc/group解析 user[:group]
+func LookupUser(rootfs string, user string) (*ExecUser, error) {
+	execUser := &ExecUser{Uid: 0, Gid: 0, Home: "/"}
+	if user == "" {
+		return execUser, nil
+	}
+	userName, groupName, hasGroup := strings.Cut(user, ":")
+
+	passwd := readColonFile(filepath.Join(rootfs, "/etc/passwd"))
+	found := false
+	for _, fields := range passwd {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 7 || (fields[0] != userName && fields[2] != userName) {
+			continue
+		}
+		execUser.Uid, _ = strconv.Atoi(fields[2])
+		execUser.Gid, _ = strconv.Atoi(fields[3])
+		execUser.Home = fields[5]
+		userName = fields[0]
+		found = true
+		break
+	}
+	if !found {
+		uid, err := strconv.Atoi(userName)
+		if err != nil {
+			return nil, fmt.Errorf("容器内不存在用户 %s", userName)
+		}
+		execUser.Uid = uid
+	}
+
+	groups := readColonFile(filepath.Join(rootfs, "/etc/group"))
+	if hasGroup {
+		found = false
+		for _, fields := range groups {
+			// name:password:gid:members
+			if len(fields) >= 3 && (fields[0] == groupName || fields[2] == groupName) {
+				execUser.Gid, _ = strconv.Atoi(fields[2])
+				found = true
+				break
+			}
+		}
+		if !found {
+			gid, err := strconv.Atoi(groupName)
+			if err != nil {
+				return nil, fmt.Errorf("容器内不存在用户组 %s", groupName)
+			}
+			execUser.Gid = gid
+		}
+	} else {
+		// 未指定用户组时，附加用户所属的其他组
+		for _, fields := range groups {
+			if len(fields) < 4 {
+				continue
+			}
+			for _, member := range strings.Split(fields[3], ",") {
+				if member == userName {
+					gid, _ := strconv.Atoi(fields[2])
+					execUser.Groups = append(execUser.Groups, gid)
+				}
+			}
+		}
+	}
+	return execUser, nil
+}
+
+// 读取以冒号分隔的配置文件，如/etc/passwd，文件不存在时返回空
+func readColonFile(path string) [][]string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Split(line, ":"))
+	}
+	return lines
+}
+
+// 切换到指定用户运行
+func setUser(execUser *ExecUser) error {
+	groups := append([]int{execUser.Gid}, execUser.Groups...)
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups异常: %v", err)
+	}
+	if err := syscall.Setgid(execUser.Gid); err != nil {
+		return fmt.Errorf("setgid异常: %v", err)
+	}
+	if err := syscall.Setuid(execUser.Uid); err != nil {
+		return fmt.Errorf("setuid异常: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package container
+
+import (
+	"errors"
+	"fockker/errdefs"
+	"testing"
+)
+
+func TestParseUlimit(t *testing.T) {
+	tests := []struct {
+		ulimit   string
+		expected Rlimit
+		invalid  bool
+	}{
+		{"nofile=1024:2048", Rlimit{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 2048}, false},
+		{"nofile=1024", Rlimit{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 1024}, false},
+		{"NPROC=10:10", Rlimit{Type: "RLIMIT_NPROC", Soft: 10, Hard: 10}, false},
+		{"core=0", Rlimit{Type: "RLIMIT_CORE", Soft: 0, Hard: 0}, false},
+		{"nofile", Rlimit{}, true},
+		{"unknown=1", Rlimit{}, true},
+		{"nofile=abc", Rlimit{}, true},
+		{"nofile=1:abc", Rlimit{}, true},
+		{"nofile=-1", Rlimit{}, true},
+		{"nofile=2048:1024", Rlimit{}, true},
+	}
+	for _, test := range tests {
+		rlimit, err := ParseUlimit(test.ulimit)
+		if test.invalid {
+			if !errors.Is(err, errdefs.ErrInvalid) {
+				t.Errorf("%s: 应返回ErrInvalid，实际为 %+v, %v", test.ulimit, rlimit, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.ulimit, err)
+			continue
+		}
+		if rlimit != test.expected {
+			t.Errorf("%s: 解析为 %+v，应为 %+v", test.ulimit, rlimit, test.expected)
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     []string
+		override []string
+		expected []string
+	}{
+		{"无覆盖", []string{"PATH=/bin", "HOME=/root"}, nil, []string{"PATH=/bin", "HOME=/root"}},
+		{"同名覆盖并保持位置", []string{"PATH=/bin", "HOME=/root"}, []string{"PATH=/usr/bin"}, []string{"PATH=/usr/bin", "HOME=/root"}},
+		{"新增变量", []string{"PATH=/bin"}, []string{"DEBUG=1"}, []string{"PATH=/bin", "DEBUG=1"}},
+		{"后出现的同名变量生效", nil, []string{"A=1", "A=2"}, []string{"A=2"}},
+		{"只有变量名", []string{"A=1"}, []string{"A"}, []string{"A"}},
+		{"值中包含等号", []string{"OPTS=a=b"}, []string{"OPTS=c=d"}, []string{"OPTS=c=d"}},
+	}
+	for _, test := range tests {
+		merged := MergeEnv(test.base, test.override)
+		if len(merged) != len(test.expected) {
+			t.Errorf("%s: 合并为 %q，应为 %q", test.name, merged, test.expected)
+			continue
+		}
+		for i := range merged {
+			if merged[i] != test.expected[i] {
+				t.Errorf("%s: 合并为 %q，应为 %q", test.name, merged, test.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package container
+
+import (
+	"fmt"
+	"fockker/errdefs"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// syslog设施编号
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslog严重等级，stdout记为info，stderr记为err
+const (
+	syslogSeverityErr  = 3
+	syslogSeverityInfo = 6
+)
+
+// RFC5424中APP-NAME的最大长度
+const syslogAppNameMaxSize = 48
+
+// SyslogLogger syslog日志驱动，每条日志以RFC5424格式发送到unix套接字或udp地址
+// 首次写入时连接，未写入任何内容即失败时重新连接并重发一次，syslog服务未启动不影响容器运行
+type SyslogLogger struct {
+	network  string // unixgram、unix或udp
+	address  string
+	priority int // 设施编号乘以8，加上严重等级即为PRI
+	hostname string
+	tag      string
+	conn     net.Conn
+}
+
+// NewSyslogLogger 根据容器信息中的日志选项创建syslog日志驱动
+func NewSyslogLogger(containerInfo *ContainerInfo) (*SyslogLogger, error) {
+	if err := validateSyslogOpts(containerInfo.LogOpts); err != nil {
+		return nil, err
+	}
+	network, address, _ := parseSyslogAddress(containerInfo.LogOpts[LogOptSyslogAddress])
+	facility := containerInfo.LogOpts[LogOptSyslogFacility]
+	if facility == "" {
+		facility = defaultSyslogFacility
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogLogger{
+		network:  network,
+		address:  address,
+		priority: syslogFacilities[facility] * 8,
+		hostname: hostname,
+		tag:      syslogAppName(logTag(containerInfo)),
+	}, nil
+}
+
+// 检查syslog驱动的地址与设施
+func validateSyslogOpts(logOpts map[string]string) error {
+	if _, _, err := parseSyslogAddress(logOpts[LogOptSyslogAddress]); err != nil {
+		return err
+	}
+	if facility, exists := logOpts[LogOptSyslogFacility]; exists {
+		if _, supported := syslogFacilities[facility]; !supported {
+			return errdefs.Invalid("不支持的syslog设施 %s", facility)
+		}
+	}
+	return nil
+}
+
+// 解析syslog地址：unix:///path（数据报或流式unix套接字）、udp://host:port，为空时为 /dev/log
+func parseSyslogAddress(address string) (string, string, error) {
+	if address == "" {
+		address = defaultSyslogAddress
+	}
+	scheme, rest, ok := strings.Cut(address, "://")
+	if !ok || rest == "" {
+		return "", "", errdefs.Invalid("syslog地址格式错误 %s", address)
+	}
+	switch scheme {
+	case "unix":
+		return "unixgram", rest, nil
+	case "udp":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return "", "", errdefs.Invalid("syslog地址格式错误 %s", address)
+		}
+		return "udp", rest, nil
+	}
+	return "", "", errdefs.Invalid("不支持的syslog地址 %s，应为 unix:///path 或 udp://host:port", address)
+}
+
+// 获取日志的标签，未指定时为容器名
+func logTag(containerInfo *ContainerInfo) string {
+	if tag := containerInfo.LogOpts[LogOptTag]; tag != "" {
+		return tag
+	}
+	return containerInfo.Name
+}
+
+// APP-NAME只能包含可打印的ASCII字符，且不超过48个字符
+func syslogAppName(tag string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, tag)
+	if len(name) > syslogAppNameMaxSize {
+		name = name[:syslogAppNameMaxSize]
+	}
+	if name == "" {
+		name = "-"
+	}
+	return name
+}
+
+// Log 以RFC5424格式发送一条日志：<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (l *SyslogLogger) Log(entry *LogEntry) error {
+	severity := syslogSeverityInfo
+	if entry.Stream == StreamStderr {
+		severity = syslogSeverityErr
+	}
+	message := fmt.Sprintf("<%d>1 %s %s %s - - - %s", l.priority+severity,
+		entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"), l.hostname, l.tag, strings.TrimSuffix(entry.Log, "\n"))
+	written, err := l.send(message)
+	if err != nil && written == 0 {
+		// 连接已断开（如syslog服务重启），重新连接后再发送一次
+		l.disconnect()
+		written, err = l.send(message)
+	}
+	if err != nil {
+		// 流式套接字已写入部分内容时重新发送会使日志重复或错位，丢弃该条日志，下次发送时重新连接
+		l.disconnect()
+		if written > 0 {
+			return fmt.Errorf("日志只发送了部分内容，已丢弃: %v", err)
+		}
+	}
+	return err
+}
+
+// Close 关闭与syslog的连接
+func (l *SyslogLogger) Close() error {
+	l.disconnect()
+	return nil
+}
+
+// 发送消息，返回已写入的字节数
+func (l *SyslogLogger) send(message string) (int, error) {
+	if l.conn == nil {
+		if err := l.connect(); err != nil {
+			return 0, err
+		}
+	}
+	// 流式套接字以换行分隔每条日志
+	if l.network == "unix" {
+		message += "\n"
+	}
+	_ = l.conn.SetWriteDeadline(time.Now().Add(logDialTimeout))
+	return l.conn.Write([]byte(message))
+}
+
+// 连接syslog，unix套接字优先以数据报方式连接，失败时以流式连接
+func (l *SyslogLogger) connect() error {
+	conn, err := net.DialTimeout(l.network, l.address, logDialTimeout)
+	if err != nil && l.network == "unixgram" {
+		if conn, err = net.DialTimeout("unix", l.address, logDialTimeout); err == nil {
+			l.network = "unix"
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("连接syslog %s 异常 %v", l.address, err)
+	}
+	l.conn = conn
+	return nil
+}
+
+func (l *SyslogLogger) disconnect() {
+	if l.conn != nil {
+		_ = l.conn.Close()
+		l.conn = nil
+	}
+}
@@ -0,0 +1,133 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 创建发送到address的syslog日志驱动，设施为local0，标签为web
+func newTestSyslogLogger(t *testing.T, address string) *SyslogLogger {
+	t.Helper()
+	logger, err := NewSyslogLogger(&ContainerInfo{
+		Name: "test",
+		LogOpts: map[string]string{
+			LogOptSyslogAddress:  address,
+			LogOptSyslogFacility: "local0",
+			LogOptTag:            "web",
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建syslog日志驱动失败: %v", err)
+	}
+	t.Cleanup(func() { _ = logger.Close() })
+	return logger
+}
+
+// 检查RFC5424格式：<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func checkSyslogMessage(t *testing.T, message string, priority int, entry *LogEntry) {
+	t.Helper()
+	hostname, _ := os.Hostname()
+	expected := fmt.Sprintf("<%d>1 %s %s web - - - %s", priority,
+		entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"), hostname, strings.TrimSuffix(entry.Log, "\n"))
+	if message != expected {
+		t.Errorf("syslog消息为 %q，应为 %q", message, expected)
+	}
+}
+
+// 依次发送stdout与stderr的日志，local0的PRI为16*8，stdout为info(6)，stderr为err(3)
+var syslogTestEntries = []struct {
+	entry    *LogEntry
+	priority int
+}{
+	{&LogEntry{Log: "hello stdout\n", Stream: StreamStdout, Time: time.Date(2024, 5, 1, 8, 30, 0, 123456000, time.UTC)}, 16*8 + 6},
+	{&LogEntry{Log: "hello stderr\n", Stream: StreamStderr, Time: time.Date(2024, 5, 1, 8, 30, 1, 0, time.UTC)}, 16*8 + 3},
+}
+
+func TestSyslogUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	logger := newTestSyslogLogger(t, "unix://"+path)
+
+	buffer := make([]byte, 4096)
+	for _, test := range syslogTestEntries {
+		if err = logger.Log(test.entry); err != nil {
+			t.Fatalf("发送日志失败: %v", err)
+		}
+		_ = listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := listener.Read(buffer)
+		if err != nil {
+			t.Fatalf("读取日志失败: %v", err)
+		}
+		// 数据报中每条日志单独发送，不以换行结尾
+		checkSyslogMessage(t, string(buffer[:n]), test.priority, test.entry)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	logger := newTestSyslogLogger(t, "udp://"+conn.LocalAddr().String())
+
+	buffer := make([]byte, 4096)
+	for _, test := range syslogTestEntries {
+		if err = logger.Log(test.entry); err != nil {
+			t.Fatalf("发送日志失败: %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("读取日志失败: %v", err)
+		}
+		checkSyslogMessage(t, string(buffer[:n]), test.priority, test.entry)
+	}
+}
+
+// 流式unix套接字以换行分隔每条日志
+func TestSyslogUnixStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	logger := newTestSyslogLogger(t, "unix://"+path)
+
+	lines := make(chan string, len(syslogTestEntries))
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for _, test := range syslogTestEntries {
+		if err = logger.Log(test.entry); err != nil {
+			t.Fatalf("发送日志失败: %v", err)
+		}
+	}
+	for _, test := range syslogTestEntries {
+		select {
+		case line := <-lines:
+			checkSyslogMessage(t, line, test.priority, test.entry)
+		case <-time.After(5 * time.Second):
+			t.Fatal("等待日志超时")
+		}
+	}
+}
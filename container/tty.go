@@ -0,0 +1,240 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"fockker/errdefs"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Terminal 容器的伪终端，slave端作为容器进程的标准输入输出与控制终端，master端由宿主机读写
+type Terminal struct {
+	Master *os.File
+	slave  *os.File
+}
+
+// NewTerminal 创建伪终端
+func NewTerminal() (*Terminal, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("打开 /dev/ptmx 异常: %v", err)
+	}
+	// 解锁slave端，并获取其编号
+	if err = unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("伪终端解锁异常: %v", err)
+	}
+	index, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("获取伪终端编号异常: %v", err)
+	}
+	slavePath := fmt.Sprintf("/dev/pts/%d", index)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("打开伪终端 %s 异常: %v", slavePath, err)
+	}
+	return &Terminal{Master: master, slave: slave}, nil
+}
+
+// Attach 将slave端设为进程的标准输入输出，并在新会话中设为控制终端
+func (t *Terminal) Attach(cmd *exec.Cmd) {
+	cmd.Stdin = t.slave
+	cmd.Stdout = t.slave
+	cmd.Stderr = t.slave
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // 子进程中的标准输入
+}
+
+// CloseSlave 进程启动后关闭宿主机持有的slave端，容器进程退出后读取master端才会结束
+func (t *Terminal) CloseSlave() {
+	_ = t.slave.Close()
+}
+
+// Resize 设置伪终端的窗口大小，容器中的前台进程组随之收到SIGWINCH
+// 经由SyscallConn取得文件描述符，避免Fd()将master端切换为阻塞模式
+func (t *Terminal) Resize(height uint16, width uint16) error {
+	rawConn, err := t.Master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	controlErr := rawConn.Control(func(fd uintptr) {
+		err = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: height, Col: width})
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
+
+// ResizeTerminal 设置运行中容器的伪终端窗口大小，由持有伪终端master端的shim设置
+// 容器进程可能重定向了标准输入，因此不经由其标准输入设置
+func ResizeTerminal(containerName string, height uint16, width uint16) error {
+	containerInfo, err := GetContainerInfoByName(containerName)
+	if err != nil {
+		return errdefs.NotFound("容器 %s 不存在", containerName)
+	}
+	if containerInfo.Status != RUNNING || !containerInfo.Tty {
+		return errdefs.Conflict("容器 %s 未运行或未创建终端", containerName)
+	}
+	conn, err := dialShim(containerName, ResizeSockName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(attachDialTimeout))
+	if err = json.NewEncoder(conn).Encode(resizeMessage{Height: height, Width: width}); err != nil {
+		return fmt.Errorf("向容器 %s 的shim发送请求异常 %v", containerName, err)
+	}
+	var reply shimMessage
+	if err = json.NewDecoder(conn).Decode(&reply); err != nil {
+		return fmt.Errorf("读取容器 %s 的shim回复异常 %v", containerName, err)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("%s", reply.Error)
+	}
+	return nil
+}
+
+// AttachTerminal 在宿主机标准输入输出与容器的连接conn之间转发，直到连接断开或输入了分离按键，返回是否为分离退出
+// tty为true且标准输入为终端时将其切换为原始模式，并在窗口大小变化时通过resize同步容器伪终端的窗口大小
+func AttachTerminal(conn io.ReadWriter, tty bool, detachKeys []byte, resize func(height uint16, width uint16)) bool {
+	if tty {
+		if restore, err := setRawTerminal(os.Stdin); err == nil {
+			defer restore()
+			syncSize := func() {
+				if size, err := unix.IoctlGetWinsize(int(os.Stdin.Fd()), unix.TIOCGWINSZ); err == nil && resize != nil {
+					resize(size.Row, size.Col)
+				}
+			}
+			syncSize()
+			winch := make(chan os.Signal, 1)
+			signal.Notify(winch, syscall.SIGWINCH)
+			defer signal.Stop(winch)
+			go func() {
+				for range winch {
+					syncSize()
+				}
+			}()
+		}
+	}
+	detached := make(chan struct{})
+	go func() {
+		// 标准输入结束时不断开连接，继续接收容器的输出
+		if copyInput(conn, os.Stdin, detachKeys) {
+			close(detached)
+		}
+	}()
+	exited := make(chan struct{})
+	go func() {
+		// 容器输出结束后shim断开连接
+		_, _ = io.Copy(os.Stdout, conn)
+		close(exited)
+	}()
+	select {
+	case <-detached:
+		return true
+	case <-exited:
+		return false
+	}
+}
+
+// 将标准输入转发到伪终端，输入分离按键时返回true，标准输入结束时返回false
+func copyInput(dst io.Writer, src io.Reader, detachKeys []byte) bool {
+	buffer := make([]byte, 1024)
+	matched := 0 // 已匹配的分离按键数量
+	for {
+		n, err := src.Read(buffer)
+		if n > 0 {
+			output := make([]byte, 0, n+matched)
+			for _, b := range buffer[:n] {
+				if len(detachKeys) > 0 && b == detachKeys[matched] {
+					matched++
+					if matched == len(detachKeys) {
+						// 分离按键之前的输入照常发送
+						_, _ = dst.Write(output)
+						return true
+					}
+					continue
+				}
+				// 未完整匹配时，已暂存的按键照常发送
+				output = append(output, detachKeys[:matched]...)
+				matched = 0
+				if len(detachKeys) > 0 && b == detachKeys[0] {
+					matched = 1
+					continue
+				}
+				output = append(output, b)
+			}
+			if _, err := dst.Write(output); err != nil {
+				return false
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// 将终端切换为原始模式，返回恢复函数。file不是终端时返回错误
+func setRawTerminal(file *os.File) (func(), error) {
+	fd := int(file.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	original := *termios
+	// 与cfmakeraw一致：关闭回显、行缓冲、信号字符与输入输出转换
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = unix.IoctlSetTermios(fd, unix.TCSETS, &original)
+	}, nil
+}
+
+// ParseDetachKeys 解析分离按键，格式为逗号分隔的按键，如 ctrl-p,ctrl-q；单个字符表示该字符本身，为空时不支持分离
+func ParseDetachKeys(keys string) ([]byte, error) {
+	if keys == "" {
+		return nil, nil
+	}
+	var sequence []byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case len(key) == 1:
+			sequence = append(sequence, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == len("ctrl-")+1:
+			// ctrl-a ~ ctrl-z 以及 ctrl-@ ctrl-[ ctrl-\ ctrl-] ctrl-^ ctrl-_ 对应控制字符 0x00 ~ 0x1f
+			c := key[len("ctrl-")]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			if c < '@' || c > '_' {
+				return nil, errdefs.Invalid("无效的分离按键 %s", key)
+			}
+			sequence = append(sequence, c-'@')
+		default:
+			return nil, errdefs.Invalid("无效的分离按键 %s", key)
+		}
+	}
+	return sequence, nil
+}
@@ -0,0 +1,81 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"fockker/errdefs"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	tests := []struct {
+		keys     string
+		expected []byte
+		invalid  bool
+	}{
+		{"", nil, false},
+		{"ctrl-p,ctrl-q", []byte{0x10, 0x11}, false},
+		{"ctrl-P, ctrl-Q", []byte{0x10, 0x11}, false},
+		{"ctrl-@,ctrl-[,ctrl-_", []byte{0x00, 0x1b, 0x1f}, false},
+		{"a,ctrl-c", []byte{'a', 0x03}, false},
+		{"ctrl-", nil, true},
+		{"ctrl-1", nil, true},
+		{"ctrl-ab", nil, true},
+		{"alt-p", nil, true},
+		{"ctrl-p,", nil, true},
+	}
+	for _, test := range tests {
+		sequence, err := ParseDetachKeys(test.keys)
+		if test.invalid {
+			if !errors.Is(err, errdefs.ErrInvalid) {
+				t.Errorf("%q: 应返回ErrInvalid，实际为 %v", test.keys, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.keys, err)
+			continue
+		}
+		if !bytes.Equal(sequence, test.expected) {
+			t.Errorf("%q: 解析为 %q，应为 %q", test.keys, sequence, test.expected)
+		}
+	}
+}
+
+func TestCopyInput(t *testing.T) {
+	detachKeys := []byte{0x10, 0x11} // ctrl-p,ctrl-q
+	tests := []struct {
+		name       string
+		input      string
+		detachKeys []byte
+		output     string
+		detached   bool
+	}{
+		{"不支持分离", "ab\x10\x11cd", nil, "ab\x10\x11cd", false},
+		{"无分离按键", "abc", detachKeys, "abc", false},
+		{"分离按键之前的输入照常发送", "ab\x10\x11cd", detachKeys, "ab", true},
+		{"只有分离按键", "\x10\x11", detachKeys, "", true},
+		{"部分匹配后不匹配", "a\x10b", detachKeys, "a\x10b", false},
+		{"部分匹配后重新开始匹配", "\x10\x10\x11", detachKeys, "\x10", true},
+		{"顺序相反不分离", "\x11\x10x", detachKeys, "\x11\x10x", false},
+	}
+	for _, test := range tests {
+		// 整块读取与逐字节读取，分离按键跨越多次读取时同样识别
+		for _, oneByte := range []bool{false, true} {
+			var output bytes.Buffer
+			input := strings.NewReader(test.input)
+			var detached bool
+			if oneByte {
+				detached = copyInput(&output, iotest.OneByteReader(input), test.detachKeys)
+			} else {
+				detached = copyInput(&output, input, test.detachKeys)
+			}
+			if detached != test.detached || output.String() != test.output {
+				t.Errorf("%s (逐字节读取: %v): 输出 %q 分离 %v，应为 %q 分离 %v",
+					test.name, oneByte, output.String(), detached, test.output, test.detached)
+			}
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
+	"fockker/errdefs"
+	"fockker/image"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
@@ -13,14 +16,15 @@ import (
 //type OuterFunc interface {
 //	NewWorkSpace()    // 创建容器工作目录
 //	DeleteWorkSpace() // 删除容器工作目录
+//	MountRootfs()     // 只读挂载容器文件系统视图
 //}
 //
 //// InnerFunc 内部调用的主要方法
 //type InnerFunc interface {
-//	CreateReadOnlyLayer() (string, error) // 创建镜像层
-//	CreateWriteLayer() (string, error)    // 创建容器层
-//	CreateWorkLayer() (string, error)     // 创建工作目录
-//	CreateMountPoint() (string, error)    // 创建联合挂载点
+//	CreateReadOnlyLayer() ([]string, error) // 创建镜像层
+//	CreateWriteLayer() (string, error)      // 创建容器层
+//	CreateWorkLayer() (string, error)       // 创建工作目录
+//	CreateMountPoint() (string, error)      // 创建联合挂载点
 //
 //	DeleteWriteLayer()           // 删除容器层
 //	DeleteWorkLayer()            // 删除工作目录
@@ -36,7 +40,7 @@ import (
 // NewWorkSpace 初始化分层文件系统
 func NewWorkSpace(imgName string, containerName string, volume string) error {
 	// 镜像层
-	imgPath, err := CreateReadOnlyLayer(imgName)
+	lowerDirs, err := CreateReadOnlyLayer(imgName)
 	if err != nil {
 		log.Errorf(`镜像层创建失败: %v`, err)
 		return err
@@ -60,7 +64,7 @@ func NewWorkSpace(imgName string, containerName string, volume string) error {
 		log.Errorf("联合挂载目录 %s 创建异常 %v", nowMountPath, err)
 		return err
 	}
-	err = CreateMountPoint(imgPath, writePath, workPath, nowMountPath)
+	err = CreateMountPoint(lowerDirs, writePath, workPath, nowMountPath)
 	if err != nil {
 		log.Errorf(`挂载失败: %v`, err)
 		return err
@@ -129,33 +133,76 @@ func DeleteWorkSpace(volume, containerName string) {
 	// 镜像层由于是只读，此处保留并不删除
 }
 
-// CreateReadOnlyLayer 镜像层，onlyRead
-func CreateReadOnlyLayer(imgName string) (string, error) {
-	// 此处需要先将镜像的tar包放置在rootPath下，代码会解压并创建对应文件系统
-	imgPath := fmt.Sprintf(ImgLayerPath, imgName) // 镜像解压后的路径
-	tarFilePath := imgPath + ".tar"               // 镜像tar所在路径
+// UnmountWorkSpace 卸载容器的联合挂载点与用户挂载，保留容器层，用于重新启动已停止的容器
+func UnmountWorkSpace(containerName string) {
+	nowMountPath := fmt.Sprintf(MountPath, containerName)
+	// 同一挂载点可能存在多次挂载（见DeleteWorkSpace中的TODO），循环卸载直到挂载点释放
+	// MNT_DETACH会一并分离挂载点下的用户挂载
+	for syscall.Unmount(nowMountPath, syscall.MNT_DETACH) == nil {
+	}
+}
 
-	exists, err := PathExists(imgPath)
+// CreateReadOnlyLayer 镜像层，onlyRead
+// 返回镜像各层在镜像存储中的目录，由底层到顶层，作为overlayfs的lowerdir
+func CreateReadOnlyLayer(imgName string) ([]string, error) {
+	img, err := ResolveImage(imgName)
 	if err != nil {
-		log.Infof("镜像目录 %s 判断异常: %v", imgPath, err)
-		return "", err
+		return nil, err
 	}
+	return image.LayerPaths(img)
+}
 
-	// 路径下不存在对应镜像目录
-	if !exists {
-		// 创建一个目录
-		if err := os.MkdirAll(imgPath, 0622); err != nil {
-			log.Errorf("镜像目录 %s 创建异常 %v", imgPath, err)
-			return "", err
+// ResolveImage 根据镜像名或镜像ID获取镜像
+// 镜像存储中不存在时，将RootPath下旧格式的镜像tar包或镜像目录导入为单层镜像，均不存在时返回ErrNotFound
+// 不从镜像仓库拉取，拉取可能耗时较长，由调用方在持有容器状态的锁之前完成，并将进度返回给客户端
+func ResolveImage(imgName string) (*image.Image, error) {
+	if img, err := image.GetImage(imgName); err == nil {
+		return img, nil
+	}
+	if strings.HasPrefix(imgName, image.DigestPrefix) {
+		return nil, errdefs.NotFound("镜像 %s 不存在", imgName)
+	}
+	name, tag := image.ParseReference(imgName)
+	// 旧格式的镜像只有名称，没有标签与仓库地址
+	imgPath := fmt.Sprintf(ImgLayerPath, name) // 旧格式的镜像目录
+	tarFilePath := imgPath + ".tar"            // 镜像tar所在路径
+	if tag != image.DefaultTag || strings.Contains(name, "/") {
+		imgPath, tarFilePath = "", ""
+	}
+	var digest string
+	if exists, _ := PathExists(tarFilePath); tarFilePath != "" && exists {
+		file, err := os.Open(tarFilePath)
+		if err != nil {
+			log.Errorf("镜像文件 %s 打开异常 %v", tarFilePath, err)
+			return nil, err
 		}
-		// 并且从本地路径解压
-		if _, err := exec.Command("tar", "-xvf", tarFilePath, "-C", imgPath).CombinedOutput(); err != nil {
-			log.Errorf("镜像目录 %s 解压异常 %v", imgPath, err)
-			return "", err
+		digest, err = image.CreateLayerFromTar(file)
+		_ = file.Close()
+		if err != nil {
+			log.Errorf("镜像文件 %s 导入异常 %v", tarFilePath, err)
+			return nil, err
+		}
+	} else if exists, _ = PathExists(imgPath); imgPath != "" && exists {
+		var err error
+		if digest, err = image.CreateLayerFromDir(imgPath); err != nil {
+			log.Errorf("镜像目录 %s 导入异常 %v", imgPath, err)
+			return nil, err
 		}
-		// TODO 本地镜像tar包不存在，则走网络获取镜像tar到rootPath
+	} else {
+		return nil, errdefs.NotFound("镜像 %s 不存在", imgName)
+	}
+	// 旧格式镜像的运行配置
+	var config image.ImageConfig
+	if content, err := os.ReadFile(imgPath + ".json"); err == nil {
+		_ = json.Unmarshal(content, &config)
 	}
-	return imgPath, nil
+	img, err := image.CreateImage([]string{digest}, config, name)
+	if err != nil {
+		log.Errorf("镜像 %s 创建异常 %v", name, err)
+		return nil, err
+	}
+	log.Infof("镜像 %s 已导入镜像存储 %s", name, img.ID)
+	return img, nil
 }
 
 // CreateWriteLayer 容器层，Read & Write
@@ -198,13 +245,17 @@ func CreateWorkLayer(containerName string) (string, error) {
 	return workPath, nil
 }
 
-// CreateMountPoint 创建联合文件系统
-func CreateMountPoint(imgPath string, writePath string, workPath string, nowMountPath string) error {
+// CreateMountPoint 创建联合文件系统，lowerDirs为镜像各层目录，由底层到顶层
+func CreateMountPoint(lowerDirs []string, writePath string, workPath string, nowMountPath string) error {
 	// 基于overlayfs实现联合挂载
-	// lowerdir：底层目录，ro
+	// lowerdir：底层目录，ro，多个目录以 : 分隔，靠前的目录位于上层，即 lowerdir=l3:l2:l1
 	// upperdir：上层目录，w
 	// workdir：在此处理需要的文件变更，将结果合并到最终的挂载点
-	dirs := "lowerdir=" + imgPath + ",upperdir=" + writePath + ",workdir=" + workPath
+	layers := make([]string, len(lowerDirs))
+	for i, dir := range lowerDirs {
+		layers[len(lowerDirs)-1-i] = dir
+	}
+	dirs := "lowerdir=" + strings.Join(layers, ":") + ",upperdir=" + writePath + ",workdir=" + workPath
 	_, err := exec.Command("mount", "-t", "overlay", "-o", dirs, "overlay", nowMountPath).CombinedOutput()
 	// 这里用syscall或unix的Mount也可以
 	//err := syscall.Mount("overlay", nowMountPath, "overlay", 0, dirs)
@@ -217,6 +268,48 @@ func CreateMountPoint(imgPath string, writePath string, workPath string, nowMoun
 	return nil
 }
 
+// MountRootfs 将容器的镜像层与容器层以只读方式联合挂载到临时目录，得到不含数据卷的容器文件系统视图
+// 不影响容器自身的挂载，运行中与已停止的容器均可使用。返回挂载点与卸载函数
+func MountRootfs(containerInfo *ContainerInfo) (string, func(), error) {
+	imgName := containerInfo.ImageID
+	if imgName == "" {
+		imgName = containerInfo.Image
+	}
+	lowerDirs, err := CreateReadOnlyLayer(imgName)
+	if err != nil {
+		return "", nil, err
+	}
+	// 容器层作为最上层的lowerdir
+	lowerDirs = append(lowerDirs, fmt.Sprintf(WriteLayerPath, containerInfo.Name))
+	return mountReadOnlyLayers(lowerDirs, containerInfo.Name+"-rootfs-")
+}
+
+// 将多个层只读联合挂载到以prefix开头的临时目录，lowerDirs由底层到顶层，返回挂载点与卸载函数
+// 不指定upperdir时overlayfs为只读挂载，但至少需要两层，只有一层时直接返回该层目录
+func mountReadOnlyLayers(lowerDirs []string, prefix string) (string, func(), error) {
+	if len(lowerDirs) == 1 {
+		return lowerDirs[0], func() {}, nil
+	}
+	mountPath, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	layers := make([]string, len(lowerDirs))
+	for i, dir := range lowerDirs {
+		layers[len(lowerDirs)-1-i] = dir
+	}
+	if err = syscall.Mount("overlay", mountPath, "overlay", syscall.MS_RDONLY, "lowerdir="+strings.Join(layers, ":")); err != nil {
+		_ = os.Remove(mountPath)
+		return "", nil, fmt.Errorf("只读联合挂载异常: %v", err)
+	}
+	return mountPath, func() {
+		if err := syscall.Unmount(mountPath, syscall.MNT_DETACH); err != nil {
+			log.Errorf("卸载挂载点 %s 异常 %v", mountPath, err)
+		}
+		_ = os.Remove(mountPath)
+	}, nil
+}
+
 // DeleteWriteLayer 删除容器层
 func DeleteWriteLayer(containerName string) {
 	writePath := fmt.Sprintf(WriteLayerPath, containerName)
@@ -236,8 +329,8 @@ func DeleteWorkLayer(containerName string) {
 
 // DeleteMountPoint 删除联合挂载点
 func DeleteMountPoint(nowMountPath string) {
-	// 使用延迟卸载，避免因挂载点繁忙导致失败
-	if err := syscall.Unmount(nowMountPath, syscall.MNT_DETACH); err != nil {
+	// 使用延迟卸载，避免因挂载点繁忙导致失败；容器退出时shim已卸载的挂载点返回EINVAL
+	if err := syscall.Unmount(nowMountPath, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
 		log.Errorf("卸载联合挂载点 %s 时异常: %v", nowMountPath, err)
 	}
 	if err := os.RemoveAll(nowMountPath); err != nil {
@@ -247,7 +340,7 @@ func DeleteMountPoint(nowMountPath string) {
 
 // DeleteMountPointWithVolume 删除联合挂载点，排除用户挂载
 func DeleteMountPointWithVolume(volumePaths []string, nowMountPath string) {
-	if err := syscall.Unmount(nowMountPath, syscall.MNT_DETACH); err != nil {
+	if err := syscall.Unmount(nowMountPath, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
 		log.Errorf("卸载联合挂载点 %s 时异常: %v", nowMountPath, err)
 		return
 	}
@@ -0,0 +1,42 @@
+package errdefs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 错误的类型，容器、镜像与网络模块返回的错误可通过 errors.Is(err, errdefs.ErrNotFound) 判断，fockkerd据此返回对应的状态码
+var (
+	ErrNotFound = errors.New("对象不存在")      // 容器、镜像、网络等不存在
+	ErrConflict = errors.New("与对象当前的状态冲突") // 容器未运行、已在运行、名称已存在等
+	ErrInvalid  = errors.New("无效的参数")      // 重启策略、日志选项、信号、镜像名等参数无效
+)
+
+// Error 带有类型的错误，Kind为上述错误类型之一，Error()只返回Message
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// NotFound 返回ErrNotFound类型的错误
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// Conflict 返回ErrConflict类型的错误
+func Conflict(format string, args ...interface{}) error {
+	return &Error{Kind: ErrConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// Invalid 返回ErrInvalid类型的错误
+func Invalid(format string, args ...interface{}) error {
+	return &Error{Kind: ErrInvalid, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fockker/api"
+	"fockker/container"
+	"fockker/errdefs"
+	"fockker/network"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	stateMutex            sync.Mutex                             // 保护容器生命周期、网络与镜像删除等会修改共享状态的操作，同一时刻只有一个请求修改
+	imageMutex            sync.RWMutex                           // 保护镜像存储：构建、拉取、导入、打标签、提交与导出持有读锁，可同时进行；删除与清理持有写锁
+	containerWaitInterval time.Duration = 200 * time.Millisecond // 等待容器退出时检查容器状态的间隔
+)
+
+// RunFockkerd 启动fockkerd，初始化容器网络后在socketPath上提供HTTP/JSON API，直到收到退出信号
+// fockkerd常驻运行，持有网络与IP分配的状态，并回收由它启动的shim进程
+func RunFockkerd(socketPath string) error {
+	// 已有fockkerd在监听时不能删除其套接字
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("fockkerd已在 %s 上运行", socketPath)
+	}
+	network.InitNetwork()
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return fmt.Errorf("套接字目录 %s 创建异常 %v", filepath.Dir(socketPath), err)
+	}
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("套接字 %s 监听异常 %v", socketPath, err)
+	}
+	// 只允许root访问
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	registerContainerRoutes(mux)
+	registerImageRoutes(mux)
+	registerNetworkRoutes(mux)
+	server := &http.Server{Handler: mux}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sigCh
+		log.Infof("收到退出信号，fockkerd即将退出")
+		_ = server.Close()
+	}()
+
+	// 按重启策略重新启动容器，如shim被强制终止后always的容器
+	go func() {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		container.RestartContainers()
+	}()
+
+	log.Infof("fockkerd 已在 %s 上监听", socketPath)
+	err = server.Serve(listener)
+	_ = os.Remove(socketPath)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// 以JSON格式返回响应
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", api.ContentTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("响应写入异常 %v", err)
+	}
+}
+
+// 返回错误信息
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, api.ErrorResponse{Message: err.Error()})
+}
+
+// 容器、镜像与网络模块返回的错误对应的状态码，没有类型的错误为500
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errdefs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errdefs.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errdefs.ErrConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// 解析JSON格式的请求体
+func decodeJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("请求格式错误: %v", err))
+		return false
+	}
+	return true
+}
+
+// 流式输出的响应，每次写入作为一条Message发送并立即刷写，客户端可以实时显示进度
+type messageStream struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	mutex   sync.Mutex
+}
+
+func newMessageStream(w http.ResponseWriter) *messageStream {
+	w.Header().Set("Content-Type", api.ContentTypeStream)
+	w.WriteHeader(http.StatusOK)
+	return &messageStream{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (s *messageStream) Write(p []byte) (int, error) {
+	if err := s.send(api.Message{Stream: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// 操作结束，失败时发送错误消息
+func (s *messageStream) finish(err error) {
+	if err != nil {
+		_ = s.send(api.Message{Error: err.Error()})
+	}
+}
+
+func (s *messageStream) send(message api.Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.encoder.Encode(message); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// 归档等原始内容的响应，第一次写入时才发送响应头，写入之前失败时仍可返回错误信息
+type lazyResponse struct {
+	w           http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (l *lazyResponse) Write(p []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.w.Header().Set("Content-Type", l.contentType)
+		l.w.WriteHeader(http.StatusOK)
+	}
+	return l.w.Write(p)
+}
+
+// 操作结束，未写入任何内容时返回错误信息或空的成功响应；已经开始写入后只能记录错误
+func (l *lazyResponse) finish(err error) {
+	if err != nil {
+		if !l.started {
+			writeError(l.w, errorStatus(err), err)
+			return
+		}
+		log.Errorf("响应写入过程中异常 %v", err)
+		return
+	}
+	if !l.started {
+		_, _ = l.Write(nil)
+	}
+}
+
+// 将HTTP连接升级为原始字节流，用于attach与exec，返回连接与其中已缓冲的读取端
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.Reader, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("连接不支持升级")
+	}
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", api.UpgradeProtocol)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, buffer.Reader, nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"fockker/api"
+	"fockker/container"
+	"fockker/image"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 容器相关的API
+func registerContainerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /containers", handleContainerList)
+	mux.HandleFunc("POST /containers", handleContainerRun)
+	mux.HandleFunc("GET /containers/{name}", handleContainerInspect)
+	mux.HandleFunc("DELETE /containers/{name}", handleContainerRemove)
+	mux.HandleFunc("POST /containers/{name}/start", handleContainerStart)
+	mux.HandleFunc("POST /containers/{name}/stop", handleContainerStop)
+	mux.HandleFunc("POST /containers/{name}/kill", handleContainerKill)
+	mux.HandleFunc("POST /containers/{name}/wait", handleContainerWait)
+	mux.HandleFunc("POST /containers/{name}/resize", handleContainerResize)
+	mux.HandleFunc("POST /containers/{name}/attach", handleContainerAttach)
+	mux.HandleFunc("POST /containers/{name}/exec", handleContainerExec)
+	mux.HandleFunc("GET /containers/{name}/logs", handleContainerLogs)
+	mux.HandleFunc("GET /containers/{name}/changes", handleContainerChanges)
+	mux.HandleFunc("GET /containers/{name}/export", handleContainerExport)
+	mux.HandleFunc("GET /containers/{name}/archive", handleContainerArchive)
+	mux.HandleFunc("HEAD /containers/{name}/archive", handleContainerStatPath)
+	mux.HandleFunc("PUT /containers/{name}/archive", handleContainerExtract)
+	mux.HandleFunc("POST /containers/{name}/commit", handleContainerCommit)
+	mux.HandleFunc("GET /events", handleEvents)
+}
+
+// 获取请求路径中的容器信息，容器不存在时返回404
+func requestContainer(w http.ResponseWriter, r *http.Request) (*container.ContainerInfo, bool) {
+	containerName := r.PathValue("name")
+	containerInfo, err := container.GetContainerInfoByName(containerName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("容器 %s 不存在", containerName))
+		return nil, false
+	}
+	return &containerInfo, true
+}
+
+// 获取请求路径中运行中的容器信息，容器未运行时返回409
+func requestRunningContainer(w http.ResponseWriter, r *http.Request) (*container.ContainerInfo, bool) {
+	containerInfo, ok := requestContainer(w, r)
+	if ok && containerInfo.Status != container.RUNNING {
+		writeError(w, http.StatusConflict, fmt.Errorf("容器 %s 未运行", containerInfo.Name))
+		return nil, false
+	}
+	return containerInfo, ok
+}
+
+func handleContainerList(w http.ResponseWriter, r *http.Request) {
+	containers, err := container.GetContainers()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	if containers == nil {
+		containers = []*container.ContainerInfo{}
+	}
+	writeJSON(w, http.StatusOK, containers)
+}
+
+func handleContainerRun(w http.ResponseWriter, r *http.Request) {
+	var request api.RunRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	if request.Container == nil || request.Container.Image == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少镜像名"))
+		return
+	}
+	stateMutex.Lock()
+	err := container.RunContainer(request.Container, request.Entrypoint)
+	stateMutex.Unlock()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, request.Container)
+}
+
+func handleContainerInspect(w http.ResponseWriter, r *http.Request) {
+	if containerInfo, ok := requestContainer(w, r); ok {
+		writeJSON(w, http.StatusOK, containerInfo)
+	}
+}
+
+func handleContainerRemove(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	if containerInfo.Status == container.RUNNING {
+		writeError(w, http.StatusConflict, fmt.Errorf("无法删除正在运行的容器 %s", containerInfo.Name))
+		return
+	}
+	stateMutex.Lock()
+	err := container.RemoveContainer(containerInfo.Name)
+	stateMutex.Unlock()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleContainerStart(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	if containerInfo.Status == container.RUNNING {
+		writeError(w, http.StatusConflict, fmt.Errorf("容器: %s, ID: %s, 已为%s", containerInfo.Name, containerInfo.Id, containerInfo.Status))
+		return
+	}
+	stateMutex.Lock()
+	err := container.StartContainer(containerInfo.Name)
+	stateMutex.Unlock()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 停止容器，等待容器退出后返回停止后的容器信息；t为等待退出的秒数，超过后强制终止
+// 等待重启中的容器不再重启
+func handleContainerStop(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	if containerInfo.Status != container.RUNNING && containerInfo.Status != container.RESTARTING {
+		writeError(w, http.StatusConflict, fmt.Errorf("容器 %s 未运行", containerInfo.Name))
+		return
+	}
+	timeout := container.DefaultStopTimeout
+	if t := r.URL.Query().Get("t"); t != "" {
+		seconds, err := strconv.Atoi(t)
+		if err != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("无效的等待时间 %s", t))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	// 等待容器退出可能需要较长时间，不持有stateMutex，容器的清理由shim完成
+	if err := container.StopContainer(containerInfo.Name, timeout); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	handleContainerInspect(w, r)
+}
+
+// 向容器进程发送信号，signal为信号名或信号值，默认为SIGKILL
+func handleContainerKill(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestRunningContainer(w, r)
+	if !ok {
+		return
+	}
+	signal := r.URL.Query().Get("signal")
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+	sig, err := container.ParseSignal(signal)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = container.KillContainer(containerInfo.Name, sig); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 等待容器退出，返回退出后的状态；客户端断开时停止等待
+func handleContainerWait(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	for containerInfo.Status == container.RUNNING {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(containerWaitInterval):
+		}
+		current, err := container.GetContainerInfoByName(containerInfo.Name)
+		if err != nil {
+			// 容器已被删除
+			break
+		}
+		containerInfo = &current
+	}
+	writeJSON(w, http.StatusOK, api.WaitResponse{Status: containerInfo.Status})
+}
+
+// 设置容器伪终端的窗口大小，h、w为行数与列数
+func handleContainerResize(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.ParseUint(r.URL.Query().Get("h"), 10, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("无效的窗口高度 %s", r.URL.Query().Get("h")))
+		return
+	}
+	width, err := strconv.ParseUint(r.URL.Query().Get("w"), 10, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("无效的窗口宽度 %s", r.URL.Query().Get("w")))
+		return
+	}
+	if err = container.ResizeTerminal(r.PathValue("name"), uint16(height), uint16(width)); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 升级连接后在客户端与容器的attach套接字之间转发，任意一端断开时结束
+func handleContainerAttach(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestRunningContainer(w, r)
+	if !ok {
+		return
+	}
+	attachConn, err := container.DialAttach(containerInfo.Name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	conn, reader, err := hijack(w)
+	if err != nil {
+		_ = attachConn.Close()
+		log.Errorf("容器 %s 的attach连接升级异常 %v", containerInfo.Name, err)
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(attachConn, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, attachConn)
+		done <- struct{}{}
+	}()
+	<-done
+	_ = conn.Close()
+	_ = attachConn.Close()
+}
+
+// 升级连接后在容器中执行命令，连接的输入作为命令的标准输入
+// 标准输出与标准错误按帧写入连接，最后写入退出码帧，执行异常时写入错误帧
+func handleContainerExec(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestRunningContainer(w, r)
+	if !ok {
+		return
+	}
+	var request api.ExecRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	if len(request.Cmd) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少执行的命令"))
+		return
+	}
+	conn, reader, err := hijack(w)
+	if err != nil {
+		log.Errorf("容器 %s 的exec连接升级异常 %v", containerInfo.Name, err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	frames := api.NewFrameWriter(conn)
+	exitCode, err := container.ExecContainer(containerInfo.Name, request.Cmd, reader, frames.Stream(api.FrameStdout), frames.Stream(api.FrameStderr))
+	if err != nil {
+		_ = frames.WriteFrame(api.FrameError, []byte(err.Error()))
+		return
+	}
+	_ = frames.WriteFrame(api.FrameExit, []byte(strconv.Itoa(exitCode)))
+}
+
+// 以每行一条LogEntry的格式返回容器日志，follow时持续返回新的日志，直到容器退出或客户端断开
+func handleContainerLogs(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	options := container.LogOptions{
+		Follow: query.Get("follow") == "1",
+		Tail:   -1,
+		Stream: query.Get("stream"),
+		Done:   r.Context().Done(),
+	}
+	var err error
+	if tail := query.Get("tail"); tail != "" {
+		if options.Tail, err = strconv.Atoi(tail); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("无效的行数 %s", tail))
+			return
+		}
+	}
+	for key, value := range map[string]*time.Time{"since": &options.Since, "until": &options.Until} {
+		if text := query.Get(key); text != "" {
+			if *value, err = time.Parse(time.RFC3339Nano, text); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("无效的时间 %s", text))
+				return
+			}
+		}
+	}
+	response := &lazyResponse{w: w, contentType: api.ContentTypeStream}
+	encoder := json.NewEncoder(response)
+	flusher, _ := w.(http.Flusher)
+	err = container.ReadLogs(containerInfo.Name, options, func(entry *container.LogEntry) error {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		if flusher != nil && options.Follow {
+			flusher.Flush()
+		}
+		return nil
+	})
+	response.finish(err)
+}
+
+func handleContainerChanges(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	changes, err := container.ContainerChanges(containerInfo.Name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	if changes == nil {
+		changes = []image.Change{}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// 将容器文件系统导出为tar
+func handleContainerExport(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	response := &lazyResponse{w: w, contentType: api.ContentTypeTar}
+	response.finish(container.ExportContainer(containerInfo.Name, response))
+}
+
+// 将容器内的路径path打包为tar返回，tar中的顶层名称为name，未指定时为路径的最后一级
+func handleContainerArchive(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	response := &lazyResponse{w: w, contentType: api.ContentTypeTar}
+	response.finish(container.ContainerArchive(containerInfo.Name, r.URL.Query().Get("path"), r.URL.Query().Get("name"), response))
+}
+
+// 检查容器内的路径path是否存在，并通过响应头返回是否为目录
+func handleContainerStatPath(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	mode, err := container.StatContainerPath(containerInfo.Name, r.URL.Query().Get("path"))
+	if err != nil {
+		// HEAD请求没有响应体，只返回状态码
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+	w.Header().Set(api.HeaderPathIsDir, strconv.FormatBool(mode.IsDir()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// 将请求体中的tar解压到容器内的目录path
+func handleContainerExtract(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	if err := container.ExtractToContainer(containerInfo.Name, r.URL.Query().Get("path"), r.Body); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 将容器提交为新镜像，返回新镜像的ID
+func handleContainerCommit(w http.ResponseWriter, r *http.Request) {
+	containerInfo, ok := requestContainer(w, r)
+	if !ok {
+		return
+	}
+	var request api.CommitRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	id, err := CommitC(containerInfo.Name, request.Ref, request.Author, request.Message, request.Changes, request.Pause)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.IDResponse{ID: id})
+}
+
+// 以每行一条Event的格式返回容器事件，follow时持续返回新的事件，直到超过until或客户端断开
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := container.EventOptions{
+		Follow: query.Get("follow") == "1",
+		Name:   query.Get("name"),
+		Done:   r.Context().Done(),
+	}
+	for key, value := range map[string]*time.Time{"since": &options.Since, "until": &options.Until} {
+		if text := query.Get(key); text != "" {
+			var err error
+			if *value, err = time.Parse(time.RFC3339Nano, text); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("无效的时间 %s", text))
+				return
+			}
+		}
+	}
+	// 持续输出时立即发送响应头，客户端可以在没有事件时确认连接已建立
+	w.Header().Set("Content-Type", api.ContentTypeStream)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	encoder := json.NewEncoder(w)
+	err := container.ReadEvents(options, func(event *container.Event) error {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+		if flusher != nil && options.Follow {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("容器事件输出异常 %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"fockker/api"
+	"fockker/build"
+	"fockker/container"
+	"fockker/image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 镜像与构建相关的API，镜像名中包含 / 与 :，通过查询参数ref传递
+func registerImageRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /images", handleImageList)
+	mux.HandleFunc("GET /images/inspect", handleImageInspect)
+	mux.HandleFunc("DELETE /images", handleImageRemove)
+	mux.HandleFunc("POST /images/tag", handleImageTag)
+	mux.HandleFunc("POST /images/prune", handleImagePrune)
+	mux.HandleFunc("POST /images/pull", handleImagePull)
+	mux.HandleFunc("POST /images/load", handleImageLoad)
+	mux.HandleFunc("POST /images/import", handleImageImport)
+	mux.HandleFunc("GET /images/save", handleImageSave)
+	mux.HandleFunc("POST /build", handleBuild)
+}
+
+func handleImageList(w http.ResponseWriter, r *http.Request) {
+	images, err := image.Images(r.URL.Query().Get("all") == "1")
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	if images == nil {
+		images = []image.ImageSummary{}
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+func handleImageInspect(w http.ResponseWriter, r *http.Request) {
+	detail, err := image.InspectImage(r.URL.Query().Get("ref"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// 依次删除ref指定的镜像，删除结果以流式消息返回
+func handleImageRemove(w http.ResponseWriter, r *http.Request) {
+	refs := r.URL.Query()["ref"]
+	if len(refs) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少镜像名"))
+		return
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	imageMutex.Lock()
+	defer imageMutex.Unlock()
+	stream := newMessageStream(w)
+	users := container.ImageUsers()
+	for _, ref := range refs {
+		if err := image.RemoveImage(ref, users, stream); err != nil {
+			stream.finish(fmt.Errorf("镜像删除异常: %v", err))
+			return
+		}
+	}
+}
+
+func handleImageTag(w http.ResponseWriter, r *http.Request) {
+	var request api.TagRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	if err := image.Tag(request.Source, request.Target); err != nil {
+		writeError(w, errorStatus(err), fmt.Errorf("镜像标签设置异常: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleImagePrune(w http.ResponseWriter, r *http.Request) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	imageMutex.Lock()
+	defer imageMutex.Unlock()
+	stream := newMessageStream(w)
+	if err := image.PruneImages(container.ImageUsers(), stream); err != nil {
+		stream.finish(fmt.Errorf("镜像清理异常: %v", err))
+	}
+}
+
+// 拉取镜像，拉取进度以流式消息返回
+func handleImagePull(w http.ResponseWriter, r *http.Request) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少镜像名"))
+		return
+	}
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	stream := newMessageStream(w)
+	if _, err := image.Pull(ref, r.URL.Query().Get("insecure") == "1", stream); err != nil {
+		stream.finish(fmt.Errorf("镜像拉取异常: %v", err))
+	}
+}
+
+// 从请求体中的镜像归档导入镜像
+func handleImageLoad(w http.ResponseWriter, r *http.Request) {
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	stream := newMessageStream(w)
+	if err := image.Load(r.Body, stream); err != nil {
+		stream.finish(fmt.Errorf("镜像导入异常: %v", err))
+	}
+}
+
+// 将请求体中的文件系统tar包导入为单层镜像，change为对镜像配置的修改，可指定多个
+func handleImageImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	config, err := build.ApplyChanges(image.ImageConfig{}, query["change"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var refs []string
+	if ref := query.Get("ref"); ref != "" {
+		refs = append(refs, ref)
+	}
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	img, err := image.Import(r.Body, config, query.Get("source"), query.Get("message"), refs...)
+	if err != nil {
+		writeError(w, errorStatus(err), fmt.Errorf("镜像导入异常: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, api.IDResponse{ID: img.ID})
+}
+
+// 将ref指定的镜像导出为归档
+func handleImageSave(w http.ResponseWriter, r *http.Request) {
+	refs := r.URL.Query()["ref"]
+	if len(refs) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少镜像名"))
+		return
+	}
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	response := &lazyResponse{w: w, contentType: api.ContentTypeTar}
+	response.finish(image.Save(refs, response))
+}
+
+// 请求体为构建上下文的tar，解压到临时目录后构建，f为Fockerfile在上下文中的相对路径，构建输出以流式消息返回
+func handleBuild(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("t") == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少镜像名"))
+		return
+	}
+	fockerfile := query.Get("f")
+	if fockerfile == "" {
+		fockerfile = build.DefaultFockerfile
+	}
+	if !filepath.IsLocal(fockerfile) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Fockerfile %s 不在构建上下文中", fockerfile))
+		return
+	}
+	contextDir, err := os.MkdirTemp("", "fockker-build-")
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(contextDir)
+	}()
+	if err = image.ExtractTar(r.Body, contextDir); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("构建上下文解压异常: %v", err))
+		return
+	}
+	imageMutex.RLock()
+	defer imageMutex.RUnlock()
+	builder := build.NewBuilder(contextDir, filepath.Join(contextDir, fockerfile), query.Get("t"), query.Get("nocache") == "1")
+	stream := newMessageStream(w)
+	builder.Out = stream
+	if err = builder.Build(); err != nil {
+		// 构建错误中的临时目录对用户没有意义
+		stream.finish(fmt.Errorf("%s", strings.ReplaceAll(err.Error(), contextDir+"/", "")))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"fockker/api"
+	"fockker/container"
+	"fockker/network"
+	"net/http"
+	"strings"
+)
+
+// 网络与数据卷相关的API
+func registerNetworkRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /networks", handleNetworkList)
+	mux.HandleFunc("POST /networks", handleNetworkCreate)
+	mux.HandleFunc("DELETE /networks/{name}", handleNetworkRemove)
+	mux.HandleFunc("GET /volumes", handleVolumeList)
+}
+
+func handleNetworkList(w http.ResponseWriter, r *http.Request) {
+	stateMutex.Lock()
+	networks := network.Networks()
+	stateMutex.Unlock()
+	if networks == nil {
+		networks = []*network.Network{}
+	}
+	writeJSON(w, http.StatusOK, networks)
+}
+
+// 创建网络，创建结果以流式消息返回
+func handleNetworkCreate(w http.ResponseWriter, r *http.Request) {
+	var request api.NetworkCreateRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	if request.Name == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("缺少网络名称"))
+		return
+	}
+	// 未指定网络类型则使用bridge
+	networkType := network.Bridge
+	if request.Type != "" {
+		networkType = network.NetworkType(request.Type)
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	// 开始流式输出后无法再修改状态码，先检查名称与参数
+	if err := network.ValidateCreateNetwork(request.Name, networkType, request.Subnet); err != nil {
+		writeError(w, errorStatus(err), fmt.Errorf("网络创建异常: %w", err))
+		return
+	}
+	stream := newMessageStream(w)
+	if err := network.CreateNetwork(request.Name, networkType, request.Subnet, stream); err != nil {
+		stream.finish(fmt.Errorf("网络创建异常: %w", err))
+	}
+}
+
+func handleNetworkRemove(w http.ResponseWriter, r *http.Request) {
+	networkName := r.PathValue("name")
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if err := network.ValidateDistoryNetwork(networkName); err != nil {
+		writeError(w, errorStatus(err), fmt.Errorf("网络删除异常: %w", err))
+		return
+	}
+	stream := newMessageStream(w)
+	if err := network.DistoryNetwork(networkName, stream); err != nil {
+		stream.finish(fmt.Errorf("网络删除异常: %w", err))
+	}
+}
+
+// 列出容器通过 -v 挂载的数据卷
+func handleVolumeList(w http.ResponseWriter, r *http.Request) {
+	containers, err := container.GetContainers()
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	volumes := []api.Volume{}
+	for _, containerInfo := range containers {
+		if containerInfo.Volume == "" {
+			continue
+		}
+		source, destination, _ := strings.Cut(containerInfo.Volume, ":")
+		volumes = append(volumes, api.Volume{
+			Container:   containerInfo.Name,
+			Source:      source,
+			Destination: destination,
+		})
+	}
+	writeJSON(w, http.StatusOK, volumes)
+}
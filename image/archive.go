@@ -0,0 +1,463 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 需要在tar中保留的扩展属性前缀，overlayfs内部使用的trusted.overlay.*不保留
+const paxXattrPrefix = "SCHILY.xattr."
+
+// DecompressStream 自动识别gzip压缩的层，返回解压后的数据流
+func DecompressStream(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// TarLayer 将overlayfs格式的目录打包为OCI层
+// 字符设备0/0的whiteout转换为 .wh.<name>，opaque目录转换为 .wh..wh..opq
+func TarLayer(dir string, writer io.Writer) error {
+	tw := tar.NewWriter(writer)
+	// 记录已写入的inode，实现硬链接
+	inodes := map[uint64]string{}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, filePath)
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if isWhiteout(info) {
+			parent, base := filepath.Split(name)
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     parent + whiteoutPrefix + base,
+				Mode:     0600,
+				ModTime:  info.ModTime(),
+				Format:   tar.FormatPAX,
+			})
+		}
+		if err = writeTarEntry(tw, filePath, name, info, inodes); err != nil {
+			return err
+		}
+		// opaque目录后紧跟 .wh..wh..opq 标记
+		if info.IsDir() && isOpaque(filePath) {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name + "/" + whiteoutOpaque,
+				Mode:     0600,
+				ModTime:  info.ModTime(),
+				Format:   tar.FormatPAX,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// TarDir 将普通目录打包为tar，保留属主、扩展属性、硬链接与设备文件
+func TarDir(dir string, writer io.Writer) error {
+	tw := tar.NewWriter(writer)
+	inodes := map[uint64]string{}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, filePath)
+		if rel == "." {
+			return nil
+		}
+		return writeTarEntry(tw, filePath, filepath.ToSlash(rel), info, inodes)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// TarPath 将文件或目录打包为tar，tar中的顶层名称为name，保留属主、扩展属性、硬链接与设备文件
+// path为符号链接时打包链接本身
+func TarPath(path string, name string, writer io.Writer) error {
+	tw := tar.NewWriter(writer)
+	inodes := map[uint64]string{}
+	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(path, filePath)
+		return writeTarEntry(tw, filePath, filepath.ToSlash(filepath.Join(name, rel)), info, inodes)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// 写入单个文件的tar记录
+func writeTarEntry(tw *tar.Writer, filePath string, name string, info os.FileInfo, inodes map[uint64]string) error {
+	// socket文件无法打包，直接跳过
+	if info.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return err
+		}
+		link = target
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	header.Format = tar.FormatPAX
+	// 去除本机用户名、组名，只保留uid、gid
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if info.Mode()&os.ModeDevice != 0 {
+			header.Devmajor = int64((stat.Rdev >> 8) & 0xfff)
+			header.Devminor = int64((stat.Rdev & 0xff) | ((stat.Rdev >> 12) & 0xfff00))
+		}
+		// 多个硬链接的普通文件，只有第一次出现时写入内容
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, exists := inodes[stat.Ino]; exists {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				inodes[stat.Ino] = name
+			}
+		}
+	}
+	if link == "" {
+		header.PAXRecords = readXattrs(filePath)
+	}
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeReg && header.Size > 0 {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyLayer 将OCI层解压到目录，.wh.<name> 转换为overlayfs的whiteout，.wh..wh..opq 转换为opaque目录
+func ApplyLayer(reader io.Reader, dir string) error {
+	return extractTar(reader, dir, true)
+}
+
+// ExtractTar 将普通tar解压到目录，不处理whiteout
+func ExtractTar(reader io.Reader, dir string) error {
+	return extractTar(reader, dir, false)
+}
+
+func extractTar(reader io.Reader, dir string, whiteout bool) error {
+	tr := tar.NewReader(reader)
+	// 目录的修改时间需要在其内容全部写入后再设置
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirTimes []dirTime
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		target, err := safeJoin(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		parent, base := filepath.Split(target)
+		if err = checkSymlink(dir, parent); err != nil {
+			return err
+		}
+		if err = os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+		if whiteout && strings.HasPrefix(base, whiteoutPrefix) {
+			if base == whiteoutOpaque {
+				if err = syscall.Setxattr(parent, overlayOpaqueXattr, []byte("y"), 0); err != nil {
+					return fmt.Errorf("设置opaque目录 %s 异常: %v", parent, err)
+				}
+				continue
+			}
+			deleted := filepath.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))
+			_ = os.RemoveAll(deleted)
+			if err = syscall.Mknod(deleted, syscall.S_IFCHR, 0); err != nil {
+				return fmt.Errorf("创建whiteout %s 异常: %v", deleted, err)
+			}
+			continue
+		}
+		// 非目录的同名文件直接替换
+		if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && header.Typeflag == tar.TypeDir) {
+			if err = os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		mode := uint32(header.Mode & 07777)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirTimes = append(dirTimes, dirTime{path: target, modTime: header.ModTime})
+		case tar.TypeReg:
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			_ = file.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err = os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := safeJoin(dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err = checkSymlink(dir, filepath.Dir(source)); err != nil {
+				return err
+			}
+			if err = os.Link(source, target); err != nil {
+				return err
+			}
+			// 硬链接与源文件共享属性，无需再次设置
+			continue
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			fileType := uint32(syscall.S_IFIFO)
+			if header.Typeflag == tar.TypeChar {
+				fileType = syscall.S_IFCHR
+			} else if header.Typeflag == tar.TypeBlock {
+				fileType = syscall.S_IFBLK
+			}
+			dev := int((header.Devminor & 0xff) | (header.Devmajor&0xfff)<<8 | (header.Devminor&^0xff)<<12)
+			if err = syscall.Mknod(target, fileType|mode, dev); err != nil {
+				return fmt.Errorf("创建设备文件 %s 异常: %v", target, err)
+			}
+		default:
+			// 其他类型（如pax全局头）忽略
+			continue
+		}
+		if err = os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return err
+		}
+		// 符号链接的权限与时间无意义，扩展属性的系统调用会跟随链接，因此均跳过
+		if header.Typeflag == tar.TypeSymlink {
+			continue
+		}
+		for key, value := range header.PAXRecords {
+			if strings.HasPrefix(key, paxXattrPrefix) {
+				_ = syscall.Setxattr(target, strings.TrimPrefix(key, paxXattrPrefix), []byte(value), 0)
+			}
+		}
+		// chown会清除setuid位，因此在chown之后设置权限
+		if err = os.Chmod(target, os.FileMode(mode)|modeBits(mode)); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeDir {
+			_ = os.Chtimes(target, header.ModTime, header.ModTime)
+		}
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		_ = os.Chtimes(dirTimes[i].path, dirTimes[i].modTime, dirTimes[i].modTime)
+	}
+	return nil
+}
+
+// 将unix权限中的setuid、setgid、sticky位转换为os.FileMode
+func modeBits(mode uint32) os.FileMode {
+	var bits os.FileMode
+	if mode&syscall.S_ISUID != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
+
+// 拼接解压路径，禁止通过 .. 或绝对路径写到目录之外
+func safeJoin(dir string, name string) (string, error) {
+	// dir可能以 / 结尾或为根目录
+	dir = filepath.Clean(dir)
+	target := filepath.Join(dir, filepath.Clean("/"+name))
+	if target != dir && !strings.HasPrefix(target, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", fmt.Errorf("非法的路径 %s", name)
+	}
+	return target, nil
+}
+
+// 确认dir下的路径中不存在符号链接，防止经由tar中或目录中已有的符号链接写到目录之外
+func checkSymlink(dir string, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	current := dir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." || part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("非法的路径 %s，经过符号链接 %s", path, current)
+		}
+	}
+	return nil
+}
+
+// 读取文件的扩展属性，转换为PAX记录
+func readXattrs(filePath string) map[string]string {
+	size, err := syscall.Listxattr(filePath, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buffer := make([]byte, size)
+	size, err = syscall.Listxattr(filePath, buffer)
+	if err != nil {
+		return nil
+	}
+	records := map[string]string{}
+	for _, key := range strings.Split(strings.TrimRight(string(buffer[:size]), "\x00"), "\x00") {
+		if key == "" || strings.HasPrefix(key, "trusted.overlay.") {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(filePath, key, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = syscall.Getxattr(filePath, key, value); err != nil {
+			continue
+		}
+		records[paxXattrPrefix+key] = string(value[:valueSize])
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return records
+}
+
+// 判断是否为overlayfs的whiteout文件
+func isWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// 判断目录是否为overlayfs的opaque目录
+func isOpaque(dirPath string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(dirPath, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// Changes 统计overlayfs上层目录相对下层的文件变更，lowerRoot为下层各层的合并视图
+// 字符设备0/0的whiteout与 .wh.<name> 均视为删除，opaque目录中下层独有的文件视为删除
+func Changes(upperDir string, lowerRoot string) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(upperDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(upperDir, filePath)
+		if rel == "." {
+			return nil
+		}
+		name := "/" + filepath.ToSlash(rel)
+		parent, base := filepath.Split(name)
+		switch {
+		case isWhiteout(info):
+			changes = append(changes, Change{Kind: ChangeDelete, Path: name})
+			return nil
+		case base == whiteoutOpaque:
+			return nil
+		case strings.HasPrefix(base, whiteoutPrefix):
+			changes = append(changes, Change{Kind: ChangeDelete, Path: parent + strings.TrimPrefix(base, whiteoutPrefix)})
+			return nil
+		}
+		lowerInfo, err := os.Lstat(filepath.Join(lowerRoot, rel))
+		if err != nil {
+			changes = append(changes, Change{Kind: ChangeAdd, Path: name})
+			return nil
+		}
+		changes = append(changes, Change{Kind: ChangeModify, Path: name})
+		if !info.IsDir() || !lowerInfo.IsDir() {
+			return nil
+		}
+		// opaque目录遮盖了下层目录的全部内容，上层中不存在的文件均已被删除
+		if _, err := os.Lstat(filepath.Join(filePath, whiteoutOpaque)); err == nil || isOpaque(filePath) {
+			entries, err := os.ReadDir(filepath.Join(lowerRoot, rel))
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if _, err := os.Lstat(filepath.Join(filePath, entry.Name())); os.IsNotExist(err) {
+					changes = append(changes, Change{Kind: ChangeDelete, Path: name + "/" + entry.Name()})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
@@ -0,0 +1,185 @@
+package image
+
+import "time"
+
+// 镜像存储路径，StoreRoot可通过全局参数 --image-root 修改
+var (
+	StoreRoot          string = "/root/image"
+	layersDirName      string = "layers/sha256" // 层存储目录，按sha256摘要存放
+	imagesDirName      string = "images/sha256" // 镜像清单存储目录，按镜像ID存放
+	tmpDirName         string = "tmp"           // 导入层时的临时目录
+	emptyDirName       string = "empty"         // 无层镜像使用的空lowerdir
+	layerDiffName      string = "diff"          // 层内容目录名
+	repositoriesName   string = "repositories.json"
+	DefaultTag         string = "latest"
+	DigestPrefix       string = "sha256:"
+	shortIDLength      int    = 12                       // 镜像ID的显示长度，也是按前缀查找镜像的最短长度
+	createdFormat      string = "2006-01-02 15:04:05"    // 镜像创建时间格式
+	overlayOpaqueXattr string = "trusted.overlay.opaque" // overlayfs标记opaque目录的扩展属性
+	whiteoutPrefix     string = ".wh."                   // OCI层中表示删除文件的前缀
+	whiteoutOpaque     string = ".wh..wh..opq"           // OCI层中表示opaque目录的文件名
+)
+
+// 镜像仓库相关配置
+var (
+	defaultRegistry string = "registry-1.docker.io" // 未指定镜像仓库时使用Docker Hub
+	downloadDirName string = "download"             // 层blob下载的临时目录名，中断的下载保留在此处以便继续
+	maxManifestSize int64  = 4 << 20                // 镜像清单与配置的最大长度
+)
+
+// 文件变更类型，与docker diff的输出一致
+const (
+	ChangeAdd    string = "A" // 新增
+	ChangeModify string = "C" // 修改
+	ChangeDelete string = "D" // 删除
+)
+
+// Change 容器层相对镜像的一项文件变更
+type Change struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// ImageConfig 镜像的运行配置，字段与OCI镜像配置中的config一致
+type ImageConfig struct {
+	Entrypoint   []string            `json:"Entrypoint"`
+	Cmd          []string            `json:"Cmd"`
+	Env          []string            `json:"Env"`
+	WorkingDir   string              `json:"WorkingDir"`
+	User         string              `json:"User"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"` // 暴露的端口，如 80/tcp
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	Healthcheck  *HealthConfig       `json:"Healthcheck,omitempty"` // 健康检查，未设置时不检查
+	StopSignal   string              `json:"StopSignal,omitempty"`  // 停止容器时发送的信号，如 SIGQUIT，未设置时为SIGTERM
+}
+
+// HealthConfig 健康检查配置，字段与Docker镜像配置中的Healthcheck一致，时间为纳秒，为0时使用默认值
+type HealthConfig struct {
+	Test     []string      `json:"Test,omitempty"`     // ["CMD-SHELL", "命令"]、["CMD", "参数"...]，["NONE"]表示禁用镜像的健康检查
+	Interval time.Duration `json:"Interval,omitempty"` // 检查间隔
+	Timeout  time.Duration `json:"Timeout,omitempty"`  // 单次检查的超时时间
+	Retries  int           `json:"Retries,omitempty"`  // 连续失败该次数后为unhealthy
+}
+
+// Image 镜像清单，由有序的层与运行配置组成
+type Image struct {
+	ID      string      `json:"id"`                // 镜像ID，为层列表、配置与构建记录的sha256摘要
+	Created string      `json:"created"`           // 创建时间
+	Layers  []string    `json:"layers"`            // 层摘要列表，由底层到顶层
+	Config  ImageConfig `json:"config"`            // 运行配置
+	Author  string      `json:"author,omitempty"`  // 作者
+	History []History   `json:"history,omitempty"` // 构建记录，如commit的作者与说明
+}
+
+// ImageSummary 镜像列表中的一项，镜像有多个标签时每个标签为一项
+type ImageSummary struct {
+	ID         string `json:"id"`
+	Repository string `json:"repository"` // 未打标签的中间镜像为 <none>
+	Tag        string `json:"tag"`
+	Created    string `json:"created"`
+	Size       int64  `json:"size"` // 各层大小之和
+}
+
+// ImageDetail 镜像的标签、运行配置与各层信息
+type ImageDetail struct {
+	ID       string        `json:"id"`
+	RepoTags []string      `json:"repoTags"`
+	Created  string        `json:"created"`
+	Size     int64         `json:"size"`
+	Config   ImageConfig   `json:"config"`
+	Author   string        `json:"author,omitempty"`
+	History  []History     `json:"history,omitempty"`
+	Layers   []LayerDetail `json:"layers"`
+}
+
+// LayerDetail 镜像层的摘要与大小
+type LayerDetail struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// History 镜像的一条构建记录，与OCI镜像配置中的history一致
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"` // 该记录是否没有产生新层
+}
+
+// OCI与Docker镜像格式的媒体类型
+const (
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIConfig      = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer       = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip   = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig   = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// OCI镜像布局中的文件名与注解
+const (
+	ociLayoutFile       = "oci-layout"
+	ociIndexFile        = "index.json"
+	ociBlobsDir         = "blobs/sha256"
+	dockerManifestFile  = "manifest.json"
+	annotationRefName   = "org.opencontainers.image.ref.name" // 镜像标签
+	annotationImageName = "io.containerd.image.name"          // 完整的镜像名，docker save生成的index.json中包含
+	ociLayoutVersion    = `{"imageLayoutVersion":"1.0.0"}`
+	ociImageOS          = "linux"
+)
+
+// Descriptor OCI内容描述符，通过摘要引用清单、配置与层
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform 清单适用的平台
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Index OCI镜像索引，也用于Docker的多平台清单列表
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest OCI镜像清单，由一个配置与有序的层组成
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// OCIConfig OCI镜像配置
+type OCIConfig struct {
+	Created      string      `json:"created,omitempty"`
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Config       ImageConfig `json:"config"`
+	Author       string      `json:"author,omitempty"`
+	History      []History   `json:"history,omitempty"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// DockerManifest docker save归档中manifest.json的一项
+type DockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
@@ -0,0 +1,250 @@
+package image
+
+import (
+	"fmt"
+	"fockker/errdefs"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Images 获取镜像存储中的镜像，all为true时同时包括构建产生的未打标签的中间镜像
+func Images(all bool) ([]ImageSummary, error) {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, fmt.Errorf("读取镜像标签异常 %v", err)
+	}
+	ids, err := listImageIDs()
+	if err != nil {
+		return nil, fmt.Errorf("读取镜像列表异常 %v", err)
+	}
+	refs := imageRefs(repositories)
+	var images []ImageSummary
+	for _, id := range ids {
+		img, err := GetImageByID(id)
+		if err != nil {
+			log.Errorf("读取镜像 %s 异常 %v", id, err)
+			continue
+		}
+		names := refs[id]
+		if len(names) == 0 {
+			if !all {
+				continue
+			}
+			names = []string{"<none>:<none>"}
+		}
+		size := imageSize(img)
+		for _, ref := range names {
+			name, tag := ParseReference(ref)
+			images = append(images, ImageSummary{ID: id, Repository: name, Tag: tag, Created: img.Created, Size: size})
+		}
+	}
+	return images, nil
+}
+
+// PrintImages 以表格格式输出镜像列表
+func PrintImages(writer io.Writer, images []ImageSummary) {
+	w := tabwriter.NewWriter(writer, 12, 1, 3, ' ', 0)
+	_, err := fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, item := range images {
+		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			item.Repository,
+			item.Tag,
+			ShortID(item.ID),
+			item.Created,
+			humanSize(item.Size))
+	}
+	if err = w.Flush(); err != nil {
+		log.Errorf("镜像信息刷写异常 %v", err)
+	}
+}
+
+// InspectImage 获取镜像的标签、运行配置与各层信息
+func InspectImage(ref string) (*ImageDetail, error) {
+	img, err := GetImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var layers []LayerDetail
+	for _, digest := range img.Layers {
+		layers = append(layers, LayerDetail{Digest: digest, Size: dirSize(LayerDiffPath(digest))})
+	}
+	return &ImageDetail{
+		ID:       img.ID,
+		RepoTags: imageRefs(repositories)[img.ID],
+		Created:  img.Created,
+		Size:     imageSize(img),
+		Config:   img.Config,
+		Author:   img.Author,
+		History:  img.History,
+		Layers:   layers,
+	}, nil
+}
+
+// Tag 为已有镜像打上新的 name[:tag] 标签
+func Tag(source string, target string) error {
+	img, err := GetImage(source)
+	if err != nil {
+		return err
+	}
+	return TagImage(img.ID, target)
+}
+
+// RemoveImage 删除镜像。ref为标签且镜像还有其他标签时只删除该标签；
+// 否则删除镜像清单及其全部标签，镜像层由prune清理
+// users为容器使用的镜像ID与容器名的对应关系，被容器使用的镜像拒绝删除。删除的标签与镜像写入out
+func RemoveImage(ref string, users map[string][]string, out io.Writer) error {
+	img, err := GetImage(ref)
+	if err != nil {
+		return err
+	}
+	repositoriesMutex.Lock()
+	defer repositoriesMutex.Unlock()
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	refs := imageRefs(repositories)[img.ID]
+	normalized := NormalizeReference(ref)
+	if len(refs) > 1 && repositories[normalized] == img.ID {
+		delete(repositories, normalized)
+		if err = writeJSON(filepath.Join(StoreRoot, repositoriesName), repositories); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Untagged: %s\n", normalized)
+		return nil
+	}
+	if containers := users[img.ID]; len(containers) > 0 {
+		return errdefs.Conflict("镜像 %s 正在被容器 %s 使用，无法删除", ref, strings.Join(containers, ", "))
+	}
+	for _, name := range refs {
+		delete(repositories, name)
+		fmt.Fprintf(out, "Untagged: %s\n", name)
+	}
+	if err = writeJSON(filepath.Join(StoreRoot, repositoriesName), repositories); err != nil {
+		return err
+	}
+	if err = os.Remove(imagePath(img.ID)); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Deleted: %s\n", img.ID)
+	return nil
+}
+
+// PruneImages 删除未打标签且未被容器使用的镜像，以及不再被任何镜像引用的层
+// users为容器使用的镜像ID与容器名的对应关系。删除的镜像、层与释放的空间写入out
+func PruneImages(users map[string][]string, out io.Writer) error {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	refs := imageRefs(repositories)
+	ids, err := listImageIDs()
+	if err != nil {
+		return err
+	}
+	// 仍被引用的层
+	usedLayers := map[string]bool{}
+	for _, id := range ids {
+		img, err := GetImageByID(id)
+		if err != nil {
+			log.Errorf("读取镜像 %s 异常 %v", id, err)
+			continue
+		}
+		if len(refs[id]) == 0 && len(users[id]) == 0 {
+			if err = os.Remove(imagePath(id)); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Deleted: %s\n", id)
+			continue
+		}
+		for _, digest := range img.Layers {
+			usedLayers[digest] = true
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(StoreRoot, layersDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var reclaimed int64
+	for _, entry := range entries {
+		digest := DigestPrefix + entry.Name()
+		if usedLayers[digest] {
+			continue
+		}
+		reclaimed += dirSize(LayerDiffPath(digest))
+		if err = os.RemoveAll(layerPath(digest)); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Deleted layer: %s\n", digest)
+	}
+	// 清理导入、下载中断残留的临时目录
+	_ = os.RemoveAll(filepath.Join(StoreRoot, tmpDirName))
+	fmt.Fprintf(out, "共释放空间: %s\n", humanSize(reclaimed))
+	return nil
+}
+
+// ShortID 镜像ID的短格式，用于显示
+func ShortID(id string) string {
+	id = strings.TrimPrefix(id, DigestPrefix)
+	if len(id) > shortIDLength {
+		return id[:shortIDLength]
+	}
+	return id
+}
+
+// 镜像ID与其全部标签的对应关系，标签按名称排序
+func imageRefs(repositories map[string]string) map[string][]string {
+	refs := map[string][]string{}
+	for ref, id := range repositories {
+		refs[id] = append(refs[id], ref)
+	}
+	for _, names := range refs {
+		sort.Strings(names)
+	}
+	return refs
+}
+
+// 镜像各层的磁盘占用之和
+func imageSize(img *Image) int64 {
+	var size int64
+	for _, digest := range img.Layers {
+		size += dirSize(LayerDiffPath(digest))
+	}
+	return size
+}
+
+// 目录下全部文件的大小之和
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// 将字节数转换为便于阅读的格式，如 1.5MB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
@@ -0,0 +1,417 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"fockker/errdefs"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RemoteReference 远程镜像引用，如 registry:5000/repo:tag
+type RemoteReference struct {
+	Registry   string // 镜像仓库地址，如 registry-1.docker.io、localhost:5000
+	Repository string // 仓库内的镜像名，如 library/busybox
+	Reference  string // 标签或摘要
+}
+
+// ParseRemoteReference 解析远程镜像引用
+// 第一段包含 . 或 : 或为localhost时视为镜像仓库地址，否则使用Docker Hub，单段镜像名补全 library/ 前缀
+func ParseRemoteReference(ref string) (*RemoteReference, error) {
+	name, reference := ParseReference(ref)
+	if name == "" || reference == "" {
+		return nil, errdefs.Invalid("镜像名 %s 不合法", ref)
+	}
+	remote := &RemoteReference{Registry: defaultRegistry, Reference: reference}
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		remote.Registry = first
+		remote.Repository = rest
+	} else {
+		remote.Repository = name
+	}
+	if remote.Registry == defaultRegistry && !strings.Contains(remote.Repository, "/") {
+		remote.Repository = "library/" + remote.Repository
+	}
+	return remote, nil
+}
+
+// 本地回环地址的镜像仓库默认允许使用HTTP
+func (r *RemoteReference) isLocal() bool {
+	host := r.Registry
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Registry OCI distribution API客户端
+type Registry struct {
+	Host     string // 镜像仓库地址
+	Insecure bool   // 使用HTTP而非HTTPS
+
+	client *http.Client
+	token  string    // 通过WWW-Authenticate质询获取的Bearer token
+	out    io.Writer // 拉取进度的输出
+}
+
+// NewRegistry 创建镜像仓库客户端，拉取进度写入out
+func NewRegistry(host string, insecure bool, out io.Writer) *Registry {
+	return &Registry{
+		Host:     host,
+		Insecure: insecure,
+		client:   &http.Client{},
+		out:      out,
+	}
+}
+
+// Pull 从镜像仓库拉取镜像并导入镜像存储，以ref作为镜像标签，拉取进度写入out
+// insecure为true时使用HTTP访问镜像仓库，localhost等回环地址默认允许
+func Pull(ref string, insecure bool, out io.Writer) (*Image, error) {
+	remote, err := ParseRemoteReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	registry := NewRegistry(remote.Registry, insecure || remote.isLocal(), out)
+	fmt.Fprintf(out, "%s: Pulling from %s/%s\n", remote.Reference, remote.Registry, remote.Repository)
+
+	manifest, err := registry.fetchManifest(remote.Repository, remote.Reference)
+	if err != nil {
+		return nil, err
+	}
+	configBytes, err := registry.fetchBlob(remote.Repository, manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("镜像配置下载异常: %v", err)
+	}
+	var config OCIConfig
+	if err = json.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("镜像配置解析异常: %v", err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("镜像配置中的层数 %d 与清单中的层数 %d 不一致", len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+
+	var layers []string
+	for i, layer := range manifest.Layers {
+		diffID := config.RootFS.DiffIDs[i]
+		if LayerExists(diffID) {
+			fmt.Fprintf(out, "%s: Already exists\n", ShortID(layer.Digest))
+			layers = append(layers, diffID)
+			continue
+		}
+		blobFile, err := registry.downloadBlob(remote.Repository, layer)
+		if err != nil {
+			return nil, fmt.Errorf("层 %s 下载异常: %v", layer.Digest, err)
+		}
+		file, err := os.Open(blobFile)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := CreateLayerFromTar(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("层 %s 导入异常: %v", layer.Digest, err)
+		}
+		if digest != diffID {
+			return nil, fmt.Errorf("层 %s 的摘要 %s 与镜像配置不一致", diffID, digest)
+		}
+		_ = os.Remove(blobFile)
+		fmt.Fprintf(out, "%s: Pull complete\n", ShortID(layer.Digest))
+		layers = append(layers, diffID)
+	}
+	img, err := SaveImage(&Image{Layers: layers, Config: config.Config, Author: config.Author, History: config.History}, ref)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Image ID: %s\n", img.ID)
+	fmt.Fprintf(out, "Status: Downloaded image for %s\n", NormalizeReference(ref))
+	return img, nil
+}
+
+// 获取镜像清单，清单为多平台索引时选择与本机架构匹配的清单
+func (r *Registry) fetchManifest(repository string, reference string) (*Manifest, error) {
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, reference)
+	req, err := r.newRequest(http.MethodGet, path)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		MediaTypeOCIIndex, MediaTypeOCIManifest, MediaTypeDockerList, MediaTypeDockerManifest,
+	}, ", "))
+	resp, err := r.do(req, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errdefs.NotFound("镜像清单 %s:%s 获取失败: %s", repository, reference, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("镜像清单 %s:%s 获取失败: %s", repository, reference, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	// 按摘要获取时校验内容摘要，按标签获取时校验仓库返回的摘要
+	expected := resp.Header.Get("Docker-Content-Digest")
+	if strings.HasPrefix(reference, DigestPrefix) {
+		expected = reference
+	}
+	if expected != "" && expected != sha256Digest(content) {
+		return nil, fmt.Errorf("镜像清单 %s 摘要校验失败", expected)
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	var probe struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		MediaType     string `json:"mediaType"`
+		Manifests     []Descriptor
+	}
+	if err = json.Unmarshal(content, &probe); err != nil {
+		return nil, fmt.Errorf("镜像清单解析异常: %v", err)
+	}
+	if probe.MediaType != "" {
+		mediaType = probe.MediaType
+	}
+	if probe.SchemaVersion != 2 {
+		return nil, fmt.Errorf("不支持的镜像清单版本 %d", probe.SchemaVersion)
+	}
+	if mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerList || probe.Manifests != nil {
+		matched, err := MatchPlatform(probe.Manifests)
+		if err != nil {
+			return nil, err
+		}
+		return r.fetchManifest(repository, matched.Digest)
+	}
+	manifest := &Manifest{}
+	if err = json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("镜像清单解析异常: %v", err)
+	}
+	return manifest, nil
+}
+
+// 下载较小的blob（如镜像配置）到内存，并校验摘要
+func (r *Registry) fetchBlob(repository string, descriptor Descriptor) ([]byte, error) {
+	req, err := r.newRequest(http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repository, descriptor.Digest))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.do(req, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("blob %s 获取失败: %s", descriptor.Digest, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+	if sha256Digest(content) != descriptor.Digest {
+		return nil, fmt.Errorf("blob %s 摘要校验失败", descriptor.Digest)
+	}
+	return content, nil
+}
+
+// 下载层blob到临时文件并校验摘要，返回文件路径
+// 上次中断的下载通过Range请求从已下载的位置继续
+func (r *Registry) downloadBlob(repository string, descriptor Descriptor) (string, error) {
+	hexDigest, ok := strings.CutPrefix(descriptor.Digest, DigestPrefix)
+	if !ok || strings.ContainsAny(hexDigest, "/.") {
+		return "", fmt.Errorf("不支持的摘要 %s", descriptor.Digest)
+	}
+	downloadDir := filepath.Join(StoreRoot, tmpDirName, downloadDirName)
+	if err := os.MkdirAll(downloadDir, 0700); err != nil {
+		return "", err
+	}
+	blobFile := filepath.Join(downloadDir, hexDigest)
+	file, err := os.OpenFile(blobFile, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	if descriptor.Size == 0 || offset < descriptor.Size {
+		req, err := r.newRequest(http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repository, descriptor.Digest))
+		if err != nil {
+			return "", err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		}
+		resp, err := r.do(req, repository)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			fmt.Fprintf(r.out, "%s: Resuming download from %d bytes\n", ShortID(descriptor.Digest), offset)
+		case http.StatusOK:
+			// 仓库不支持Range请求，重新下载
+			if err = file.Truncate(0); err != nil {
+				return "", err
+			}
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+		case http.StatusRequestedRangeNotSatisfiable:
+			// 已下载完整，直接校验
+		default:
+			return "", fmt.Errorf("blob %s 获取失败: %s", descriptor.Digest, resp.Status)
+		}
+		if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+			fmt.Fprintf(r.out, "%s: Downloading\n", ShortID(descriptor.Digest))
+			if _, err = io.Copy(file, resp.Body); err != nil {
+				// 保留已下载的部分，下次拉取时继续
+				return "", err
+			}
+		}
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if DigestPrefix+hex.EncodeToString(hash.Sum(nil)) != descriptor.Digest {
+		_ = os.Remove(blobFile)
+		return "", fmt.Errorf("blob %s 摘要校验失败", descriptor.Digest)
+	}
+	fmt.Fprintf(r.out, "%s: Verifying Checksum\n", ShortID(descriptor.Digest))
+	return blobFile, nil
+}
+
+func (r *Registry) newRequest(method string, path string) (*http.Request, error) {
+	scheme := "https"
+	if r.Insecure {
+		scheme = "http"
+	}
+	return http.NewRequest(method, scheme+"://"+r.Host+path, nil)
+}
+
+// 发送请求，收到401时根据WWW-Authenticate质询获取token后重试
+func (r *Registry) do(req *http.Request, repository string) (*http.Response, error) {
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || r.token != "" {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_ = resp.Body.Close()
+	if err = r.authorize(challenge, repository); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+r.token)
+	return r.client.Do(retry)
+}
+
+// 处理Bearer质询：向realm请求具有仓库pull权限的匿名token
+func (r *Registry) authorize(challenge string, repository string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("不支持的认证方式 %q", challenge)
+	}
+	values := parseChallenge(params)
+	realm := values["realm"]
+	if realm == "" {
+		return fmt.Errorf("认证质询缺少realm: %q", challenge)
+	}
+	query := url.Values{}
+	if service := values["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	resp, err := r.client.Get(realm + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("token获取异常: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token获取失败: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("token解析异常: %v", err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("token为空")
+	}
+	return nil
+}
+
+// 解析质询参数，如 realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(params string) map[string]string {
+	values := map[string]string{}
+	for len(params) > 0 {
+		key, rest, found := strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if !found {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, params = rest[1:end+1], rest[end+2:]
+		} else {
+			value, params, _ = strings.Cut(rest, ",")
+		}
+		values[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return values
+}
+
+// 计算内容的sha256摘要
+func sha256Digest(content []byte) string {
+	hash := sha256.Sum256(content)
+	return DigestPrefix + hex.EncodeToString(hash[:])
+}
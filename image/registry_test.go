@@ -0,0 +1,37 @@
+package image
+
+import (
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		params   string
+		expected map[string]string
+	}{
+		{
+			`realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/busybox:pull"`,
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/busybox:pull"},
+		},
+		{`realm="https://r/token", service="r"`, map[string]string{"realm": "https://r/token", "service": "r"}},
+		{`scope="repository:a:pull,push",service="r"`, map[string]string{"scope": "repository:a:pull,push", "service": "r"}},
+		{`Realm=https://r/token,Service=r`, map[string]string{"realm": "https://r/token", "service": "r"}},
+		{`realm=""`, map[string]string{"realm": ""}},
+		{``, map[string]string{}},
+		{`realm`, map[string]string{}},
+		{`service="r",realm="https://r/to`, map[string]string{"service": "r"}},
+	}
+	for _, test := range tests {
+		values := parseChallenge(test.params)
+		if len(values) != len(test.expected) {
+			t.Errorf("%s: 解析为 %q，应为 %q", test.params, values, test.expected)
+			continue
+		}
+		for key, value := range test.expected {
+			if values[key] != value {
+				t.Errorf("%s: 解析为 %q，应为 %q", test.params, values, test.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fockker/errdefs"
+	"fockker/ioutils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 保护repositories.json的读取-修改-写入，同一进程中同时打标签、删除标签时不丢失修改
+var repositoriesMutex sync.Mutex
+
+// ParseReference 解析 name[:tag] 或 name@digest 格式的镜像引用，未指定tag时为latest
+func ParseReference(ref string) (string, string) {
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		return name, digest
+	}
+	// 仓库地址中可能带有端口，只有最后一个 / 之后的 : 才是tag分隔符
+	slash := strings.LastIndex(ref, "/")
+	if colon := strings.LastIndex(ref, ":"); colon > slash {
+		return ref[:colon], ref[colon+1:]
+	}
+	return ref, DefaultTag
+}
+
+// NormalizeReference 将镜像引用统一为 name:tag 格式，name@digest 格式保持不变
+func NormalizeReference(ref string) string {
+	name, tag := ParseReference(ref)
+	if strings.Contains(ref, "@") {
+		return name + "@" + tag
+	}
+	return name + ":" + tag
+}
+
+// 层存储目录
+func layerPath(digest string) string {
+	return filepath.Join(StoreRoot, layersDirName, strings.TrimPrefix(digest, DigestPrefix))
+}
+
+// LayerDiffPath 层内容所在目录，即overlayfs的lowerdir
+func LayerDiffPath(digest string) string {
+	return filepath.Join(layerPath(digest), layerDiffName)
+}
+
+// 镜像清单文件路径
+func imagePath(id string) string {
+	return filepath.Join(StoreRoot, imagesDirName, strings.TrimPrefix(id, DigestPrefix)+".json")
+}
+
+// LayerExists 判断层是否已存在
+func LayerExists(digest string) bool {
+	_, err := os.Stat(LayerDiffPath(digest))
+	return err == nil
+}
+
+// CreateLayerFromTar 将层的tar数据流导入存储，返回未压缩tar的sha256摘要
+// 相同摘要的层只保存一份
+func CreateLayerFromTar(reader io.Reader) (string, error) {
+	tmpRoot := filepath.Join(StoreRoot, tmpDirName)
+	if err := os.MkdirAll(tmpRoot, 0700); err != nil {
+		return "", err
+	}
+	tmpDir, err := os.MkdirTemp(tmpRoot, "layer-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	stream, err := DecompressStream(reader)
+	if err != nil {
+		return "", errdefs.Invalid("层数据解压异常: %v", err)
+	}
+	hash := sha256.New()
+	diffPath := filepath.Join(tmpDir, layerDiffName)
+	if err = os.Mkdir(diffPath, 0755); err != nil {
+		return "", err
+	}
+	// 解压的同时计算摘要
+	if err = ApplyLayer(io.TeeReader(stream, hash), diffPath); err != nil {
+		// 数据不是有效的tar时为参数错误
+		if errors.Is(err, tar.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", errdefs.Invalid("层解压异常: %v", err)
+		}
+		return "", fmt.Errorf("层解压异常: %v", err)
+	}
+	// tar结束标记之后可能还有填充数据，同样计入摘要
+	if _, err = io.Copy(hash, stream); err != nil {
+		return "", err
+	}
+	digest := DigestPrefix + hex.EncodeToString(hash.Sum(nil))
+	if err = os.MkdirAll(filepath.Dir(layerPath(digest)), 0700); err != nil {
+		return "", err
+	}
+	// 先检查再重命名不是原子的，同时导入相同的层时可能都通过检查；因此直接重命名，层已存在导致失败时沿用已保存的层
+	if err = os.Rename(tmpDir, layerPath(digest)); err != nil {
+		if LayerExists(digest) {
+			return digest, nil
+		}
+		return "", fmt.Errorf("层 %s 保存异常: %v", digest, err)
+	}
+	return digest, nil
+}
+
+// CreateLayerFromDir 将overlayfs格式的目录（如容器层）打包导入为新层
+func CreateLayerFromDir(dir string) (string, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(TarLayer(dir, writer))
+	}()
+	digest, err := CreateLayerFromTar(reader)
+	_ = reader.CloseWithError(err)
+	return digest, err
+}
+
+// CreateImage 根据层与配置生成镜像清单，并为其打上refs标签
+func CreateImage(layers []string, config ImageConfig, refs ...string) (*Image, error) {
+	return SaveImage(&Image{Layers: layers, Config: config}, refs...)
+}
+
+// SaveImage 保存镜像清单，并为其打上refs标签
+// 镜像ID由层列表、配置、作者与构建记录计算，相同内容的镜像ID相同，已存在时直接复用
+func SaveImage(img *Image, refs ...string) (*Image, error) {
+	for _, digest := range img.Layers {
+		if !LayerExists(digest) {
+			return nil, fmt.Errorf("层 %s 不存在", digest)
+		}
+	}
+	content, err := json.Marshal(struct {
+		Layers  []string    `json:"layers"`
+		Config  ImageConfig `json:"config"`
+		Author  string      `json:"author,omitempty"`
+		History []History   `json:"history,omitempty"`
+	}{img.Layers, img.Config, img.Author, img.History})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(content)
+	id := DigestPrefix + hex.EncodeToString(hash[:])
+	saved, err := GetImageByID(id)
+	if err != nil {
+		saved = &Image{
+			ID:      id,
+			Created: time.Now().Format(createdFormat),
+			Layers:  img.Layers,
+			Config:  img.Config,
+			Author:  img.Author,
+			History: img.History,
+		}
+		if err = writeJSON(imagePath(id), saved); err != nil {
+			return nil, fmt.Errorf("镜像清单保存异常: %v", err)
+		}
+	}
+	for _, ref := range refs {
+		if err = TagImage(id, ref); err != nil {
+			return nil, err
+		}
+	}
+	return saved, nil
+}
+
+// GetImageByID 根据镜像ID读取镜像清单
+func GetImageByID(id string) (*Image, error) {
+	content, err := os.ReadFile(imagePath(id))
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{}
+	if err = json.Unmarshal(content, img); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// GetImage 根据 name[:tag] 或镜像ID获取镜像清单
+func GetImage(ref string) (*Image, error) {
+	if strings.HasPrefix(ref, DigestPrefix) {
+		return GetImageByID(ref)
+	}
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	if id, exists := repositories[NormalizeReference(ref)]; exists {
+		return GetImageByID(id)
+	}
+	// 按镜像ID前缀匹配，如 image ls 中显示的短ID
+	if len(ref) >= shortIDLength && strings.Trim(ref, "0123456789abcdef") == "" {
+		ids, err := listImageIDs()
+		if err != nil {
+			return nil, err
+		}
+		var matched []string
+		for _, id := range ids {
+			if strings.HasPrefix(strings.TrimPrefix(id, DigestPrefix), ref) {
+				matched = append(matched, id)
+			}
+		}
+		if len(matched) == 1 {
+			return GetImageByID(matched[0])
+		}
+		if len(matched) > 1 {
+			return nil, errdefs.Invalid("镜像ID前缀 %s 匹配到多个镜像", ref)
+		}
+	}
+	return nil, errdefs.NotFound("镜像 %s 不存在", ref)
+}
+
+// 获取镜像存储中全部镜像的ID
+func listImageIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(StoreRoot, imagesDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			ids = append(ids, DigestPrefix+name)
+		}
+	}
+	return ids, nil
+}
+
+// TagImage 为镜像打上 name[:tag] 标签，已存在的同名标签指向新的镜像
+func TagImage(id string, ref string) error {
+	repositoriesMutex.Lock()
+	defer repositoriesMutex.Unlock()
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repositories[NormalizeReference(ref)] = id
+	return writeJSON(filepath.Join(StoreRoot, repositoriesName), repositories)
+}
+
+// LayerPaths 获取镜像各层的lowerdir路径，由底层到顶层
+func LayerPaths(img *Image) ([]string, error) {
+	if len(img.Layers) == 0 {
+		// 无层镜像（如FROM scratch）使用空目录作为lowerdir
+		emptyPath := filepath.Join(StoreRoot, emptyDirName)
+		if err := os.MkdirAll(emptyPath, 0755); err != nil {
+			return nil, err
+		}
+		return []string{emptyPath}, nil
+	}
+	var paths []string
+	for _, digest := range img.Layers {
+		if !LayerExists(digest) {
+			return nil, fmt.Errorf("镜像 %s 的层 %s 不存在", img.ID, digest)
+		}
+		paths = append(paths, LayerDiffPath(digest))
+	}
+	return paths, nil
+}
+
+// 读取镜像标签与镜像ID的对应关系
+func loadRepositories() (map[string]string, error) {
+	repositories := map[string]string{}
+	content, err := os.ReadFile(filepath.Join(StoreRoot, repositoriesName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &repositories); err != nil {
+		return nil, err
+	}
+	return repositories, nil
+}
+
+// 先写入临时文件再重命名，避免写入中断导致文件损坏
+func writeJSON(path string, value interface{}) error {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutils.WriteFileAtomic(path, bytes.NewReader(content))
+}
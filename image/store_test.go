@@ -0,0 +1,67 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// 生成只含一个文件的层
+func testLayerTar(t *testing.T) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	content := []byte("hello\n")
+	if err := writer.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// 依次与同时导入相同的层，均应成功并得到相同的摘要
+func TestCreateLayerFromTarExisting(t *testing.T) {
+	StoreRoot = t.TempDir()
+	layer := testLayerTar(t)
+	first, err := CreateLayerFromTar(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatalf("导入层失败: %v", err)
+	}
+	digests := make([]string, 32)
+	errs := make([]error, len(digests))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range digests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			digests[i], errs[i] = CreateLayerFromTar(bytes.NewReader(layer))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for i := range digests {
+		if errs[i] != nil {
+			t.Fatalf("第%d次导入失败: %v", i, errs[i])
+		}
+		if digests[i] != first {
+			t.Errorf("第%d次导入的摘要为 %s，应为 %s", i, digests[i], first)
+		}
+	}
+	if !LayerExists(first) {
+		t.Errorf("层 %s 不存在", first)
+	}
+	// 未使用的临时目录均已删除
+	if entries, _ := os.ReadDir(filepath.Join(StoreRoot, tmpDirName)); len(entries) != 0 {
+		t.Errorf("临时目录中残留 %d 项", len(entries))
+	}
+}
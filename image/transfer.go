@@ -0,0 +1,428 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Load 导入OCI镜像布局（index.json、blobs/sha256）或docker save格式（manifest.json）的镜像归档
+// 同时包含两种格式时（如新版本docker save生成的归档）优先使用manifest.json中的镜像标签，导入进度写入out
+func Load(reader io.Reader, out io.Writer) error {
+	tmpRoot := filepath.Join(StoreRoot, tmpDirName)
+	if err := os.MkdirAll(tmpRoot, 0700); err != nil {
+		return err
+	}
+	archiveDir, err := os.MkdirTemp(tmpRoot, "load-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(archiveDir)
+	}()
+	stream, err := DecompressStream(reader)
+	if err != nil {
+		return err
+	}
+	if err = ExtractTar(stream, archiveDir); err != nil {
+		return fmt.Errorf("镜像归档解压异常: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(archiveDir, dockerManifestFile)); err == nil {
+		return loadDocker(archiveDir, out)
+	}
+	if _, err = os.Stat(filepath.Join(archiveDir, ociIndexFile)); err == nil {
+		return loadOCI(archiveDir, out)
+	}
+	return fmt.Errorf("无法识别的镜像归档，缺少 %s 或 %s", dockerManifestFile, ociIndexFile)
+}
+
+// 导入docker save格式的镜像归档
+func loadDocker(archiveDir string, out io.Writer) error {
+	var manifests []DockerManifest
+	if err := readJSONFile(filepath.Join(archiveDir, dockerManifestFile), &manifests); err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		configPath, err := safeJoin(archiveDir, manifest.Config)
+		if err != nil {
+			return err
+		}
+		var layerPaths []string
+		for _, layer := range manifest.Layers {
+			layerPath, err := safeJoin(archiveDir, layer)
+			if err != nil {
+				return err
+			}
+			layerPaths = append(layerPaths, layerPath)
+		}
+		if err = loadImage(configPath, layerPaths, manifest.RepoTags, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 导入OCI镜像布局
+func loadOCI(archiveDir string, out io.Writer) error {
+	var index Index
+	if err := readJSONFile(filepath.Join(archiveDir, ociIndexFile), &index); err != nil {
+		return err
+	}
+	for _, descriptor := range index.Manifests {
+		var refs []string
+		if ref := ociReference(descriptor.Annotations); ref != "" {
+			refs = append(refs, ref)
+		}
+		manifest, err := resolveManifest(archiveDir, descriptor)
+		if err != nil {
+			return err
+		}
+		configPath, err := blobPath(archiveDir, manifest.Config)
+		if err != nil {
+			return err
+		}
+		var layerPaths []string
+		for _, layer := range manifest.Layers {
+			layerPath, err := blobPath(archiveDir, layer)
+			if err != nil {
+				return err
+			}
+			layerPaths = append(layerPaths, layerPath)
+		}
+		if err = loadImage(configPath, layerPaths, refs, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 根据描述符读取镜像清单，描述符指向多平台索引时选择与本机架构匹配的清单
+func resolveManifest(archiveDir string, descriptor Descriptor) (*Manifest, error) {
+	path, err := blobPath(archiveDir, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if descriptor.MediaType == MediaTypeOCIIndex || descriptor.MediaType == MediaTypeDockerList {
+		var index Index
+		if err = json.Unmarshal(content, &index); err != nil {
+			return nil, err
+		}
+		matched, err := MatchPlatform(index.Manifests)
+		if err != nil {
+			return nil, err
+		}
+		return resolveManifest(archiveDir, *matched)
+	}
+	manifest := &Manifest{}
+	if err = json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// MatchPlatform 从多平台清单中选择与本机架构匹配的清单
+func MatchPlatform(manifests []Descriptor) (*Descriptor, error) {
+	for i, descriptor := range manifests {
+		if descriptor.Platform == nil {
+			continue
+		}
+		if descriptor.Platform.OS == ociImageOS && descriptor.Platform.Architecture == runtime.GOARCH {
+			return &manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("没有适用于 %s/%s 的镜像", ociImageOS, runtime.GOARCH)
+}
+
+// 导入一个镜像：按顺序导入各层，校验层摘要与配置中的diff_ids一致，生成镜像并打上标签
+func loadImage(configPath string, layerPaths []string, refs []string, out io.Writer) error {
+	var config OCIConfig
+	if err := readJSONFile(configPath, &config); err != nil {
+		return fmt.Errorf("镜像配置读取异常: %v", err)
+	}
+	if len(config.RootFS.DiffIDs) != 0 && len(config.RootFS.DiffIDs) != len(layerPaths) {
+		return fmt.Errorf("镜像配置中的层数 %d 与清单中的层数 %d 不一致", len(config.RootFS.DiffIDs), len(layerPaths))
+	}
+	var layers []string
+	for i, layerPath := range layerPaths {
+		file, err := os.Open(layerPath)
+		if err != nil {
+			return err
+		}
+		digest, err := CreateLayerFromTar(file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+		if len(config.RootFS.DiffIDs) != 0 && config.RootFS.DiffIDs[i] != digest {
+			return fmt.Errorf("层 %s 的摘要 %s 与镜像配置不一致", config.RootFS.DiffIDs[i], digest)
+		}
+		fmt.Fprintf(out, "Loaded layer: %s\n", digest)
+		layers = append(layers, digest)
+	}
+	img, err := SaveImage(&Image{Layers: layers, Config: config.Config, Author: config.Author, History: config.History}, refs...)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		fmt.Fprintf(out, "Loaded image ID: %s\n", img.ID)
+	}
+	for _, ref := range refs {
+		fmt.Fprintf(out, "Loaded image: %s\n", NormalizeReference(ref))
+	}
+	return nil
+}
+
+// 从索引注解中获取镜像名，只有标签没有镜像名时无法使用
+func ociReference(annotations map[string]string) string {
+	if name := annotations[annotationImageName]; name != "" {
+		return name
+	}
+	ref := annotations[annotationRefName]
+	if ref == "" {
+		return ""
+	}
+	if !strings.ContainsAny(ref, ":/") {
+		log.Warnf("镜像标签 %s 不包含镜像名，导入后请通过 image tag 设置镜像名", ref)
+		return ""
+	}
+	return ref
+}
+
+// 获取描述符对应的blob路径，并校验其摘要
+func blobPath(archiveDir string, descriptor Descriptor) (string, error) {
+	hexDigest, ok := strings.CutPrefix(descriptor.Digest, DigestPrefix)
+	if !ok || strings.ContainsAny(hexDigest, "/.") {
+		return "", fmt.Errorf("不支持的摘要 %s", descriptor.Digest)
+	}
+	path := filepath.Join(archiveDir, ociBlobsDir, hexDigest)
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("blob %s 不存在", descriptor.Digest)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != hexDigest {
+		return "", fmt.Errorf("blob %s 摘要校验失败", descriptor.Digest)
+	}
+	return path, nil
+}
+
+// Import 将文件系统tar包（如export导出的容器文件系统）导入为单层镜像，支持gzip压缩
+// source为tar包来源，与message一同记录在镜像的构建记录中
+func Import(reader io.Reader, config ImageConfig, source string, message string, refs ...string) (*Image, error) {
+	layer, err := CreateLayerFromTar(reader)
+	if err != nil {
+		return nil, fmt.Errorf("文件系统导入异常: %w", err)
+	}
+	return SaveImage(&Image{
+		Layers: []string{layer},
+		Config: config,
+		History: []History{{
+			Created:   time.Now().UTC().Format(time.RFC3339),
+			CreatedBy: "fockker import " + source,
+			Comment:   message,
+		}},
+	}, refs...)
+}
+
+// Save 将镜像导出为同时符合OCI镜像布局与docker save格式的归档
+func Save(refs []string, writer io.Writer) error {
+	tw := tar.NewWriter(writer)
+	// 多个镜像共用的blob只写入一次
+	written := map[string]bool{}
+	// 同一层只打包一次，记录打包后的层描述符
+	layerCache := map[string]Descriptor{}
+	var index Index
+	index.SchemaVersion = 2
+	index.MediaType = MediaTypeOCIIndex
+	var dockerManifests []DockerManifest
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		img, err := GetImage(ref)
+		if err != nil {
+			return err
+		}
+		// 通过标签导出的镜像保留标签，通过镜像ID导出的镜像不带标签
+		var repoTags []string
+		if repositories[NormalizeReference(ref)] == img.ID {
+			repoTags = append(repoTags, NormalizeReference(ref))
+		}
+		config := OCIConfig{
+			Architecture: runtime.GOARCH,
+			OS:           ociImageOS,
+			Config:       img.Config,
+			Author:       img.Author,
+			History:      img.History,
+		}
+		if created, err := time.ParseInLocation(createdFormat, img.Created, time.Local); err == nil {
+			config.Created = created.UTC().Format(time.RFC3339)
+		}
+		config.RootFS.Type = "layers"
+		manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest}
+		dockerManifest := DockerManifest{RepoTags: repoTags}
+		for _, digest := range img.Layers {
+			layer, exists := layerCache[digest]
+			if !exists {
+				if layer, err = writeLayerBlob(tw, digest, written); err != nil {
+					return fmt.Errorf("层 %s 导出异常: %v", digest, err)
+				}
+				layerCache[digest] = layer
+			}
+			// 导出的层未压缩，层摘要即diff_id
+			config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, layer.Digest)
+			manifest.Layers = append(manifest.Layers, layer)
+			dockerManifest.Layers = append(dockerManifest.Layers, ociBlobsDir+"/"+strings.TrimPrefix(layer.Digest, DigestPrefix))
+		}
+		if config.RootFS.DiffIDs == nil {
+			config.RootFS.DiffIDs = []string{}
+		}
+		if manifest.Config, err = writeJSONBlob(tw, MediaTypeOCIConfig, config, written); err != nil {
+			return err
+		}
+		dockerManifest.Config = ociBlobsDir + "/" + strings.TrimPrefix(manifest.Config.Digest, DigestPrefix)
+		manifestDescriptor, err := writeJSONBlob(tw, MediaTypeOCIManifest, manifest, written)
+		if err != nil {
+			return err
+		}
+		if len(repoTags) > 0 {
+			_, tag := ParseReference(repoTags[0])
+			manifestDescriptor.Annotations = map[string]string{
+				annotationImageName: repoTags[0],
+				annotationRefName:   tag,
+			}
+		}
+		index.Manifests = append(index.Manifests, manifestDescriptor)
+		dockerManifests = append(dockerManifests, dockerManifest)
+	}
+
+	for name, value := range map[string]interface{}{ociIndexFile: index, dockerManifestFile: dockerManifests} {
+		content, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if err = writeTarFile(tw, name, content); err != nil {
+			return err
+		}
+	}
+	if err := writeTarFile(tw, ociLayoutFile, []byte(ociLayoutVersion)); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// 将层打包到临时文件并计算摘要，再写入归档的blobs目录
+func writeLayerBlob(tw *tar.Writer, digest string, written map[string]bool) (Descriptor, error) {
+	tmpRoot := filepath.Join(StoreRoot, tmpDirName)
+	if err := os.MkdirAll(tmpRoot, 0700); err != nil {
+		return Descriptor{}, err
+	}
+	file, err := os.CreateTemp(tmpRoot, "save-")
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	hash := sha256.New()
+	if err = TarLayer(LayerDiffPath(digest), io.MultiWriter(file, hash)); err != nil {
+		return Descriptor{}, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	descriptor := Descriptor{
+		MediaType: MediaTypeOCILayer,
+		Digest:    DigestPrefix + hex.EncodeToString(hash.Sum(nil)),
+		Size:      size,
+	}
+	if written[descriptor.Digest] {
+		return descriptor, nil
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return Descriptor{}, err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    ociBlobsDir + "/" + strings.TrimPrefix(descriptor.Digest, DigestPrefix),
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if _, err = io.Copy(tw, file); err != nil {
+		return Descriptor{}, err
+	}
+	written[descriptor.Digest] = true
+	return descriptor, nil
+}
+
+// 将JSON对象写入归档的blobs目录
+func writeJSONBlob(tw *tar.Writer, mediaType string, value interface{}, written map[string]bool) (Descriptor, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	hash := sha256.Sum256(content)
+	descriptor := Descriptor{
+		MediaType: mediaType,
+		Digest:    DigestPrefix + hex.EncodeToString(hash[:]),
+		Size:      int64(len(content)),
+	}
+	if !written[descriptor.Digest] {
+		if err = writeTarFile(tw, ociBlobsDir+"/"+hex.EncodeToString(hash[:]), content); err != nil {
+			return Descriptor{}, err
+		}
+		written[descriptor.Digest] = true
+	}
+	return descriptor, nil
+}
+
+// 向归档写入一个文件
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// 读取JSON文件
+func readJSONFile(path string, value interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, value)
+}
@@ -0,0 +1,30 @@
+package ioutils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic 将content写入同一目录下的临时文件，成功后重命名为path
+// 读取方不会读到写入一半的内容，写入失败时不会留下不完整的文件；临时文件名唯一，同时写入同一文件时不会互相覆盖临时文件
+func WriteFileAtomic(path string, content io.Reader) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmpFile, content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+	}
+	return err
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"fmt"
+	"fockker/api"
+	"fockker/client"
 	"fockker/constants"
-	"fockker/network"
+	"fockker/image"
 	_ "fockker/nsenter" // nsenter引用(必要)
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 func main() {
@@ -18,28 +21,67 @@ func main() {
 	app.Usage = constants.Usage
 
 	app.Commands = []cli.Command{
-		InitCommand,    // 容器初始化
-		RunCommand,     // 容器启动
-		ListCommand,    // 容器状态信息
-		StopCommand,    // 容器停止
-		RemoveCommand,  // 容器删除
-		ExecCommand,    // 容器执行
-		LogCommand,     // 容器日志
-		NetwormCommand, // 容器网络
-		DaemonCommand,  // Daemon进程
-		// TODO BuildCommand 容器构建
+		InitCommand,     // 容器初始化
+		RunCommand,      // 容器启动
+		ListCommand,     // 容器状态信息
+		InspectCommand,  // 容器详细信息
+		StartCommand,    // 容器重新启动
+		StopCommand,     // 容器停止
+		KillCommand,     // 容器信号
+		RemoveCommand,   // 容器删除
+		ExecCommand,     // 容器执行
+		AttachCommand,   // 容器连接
+		LogCommand,      // 容器日志
+		NetwormCommand,  // 容器网络
+		ImageCommand,    // 镜像管理
+		PullCommand,     // 镜像拉取
+		LoadCommand,     // 镜像导入
+		SaveCommand,     // 镜像导出
+		ShimCommand,     // 容器的shim进程
+		ArchiveCommand,  // 容器文件复制的辅助进程
+		BuildCommand,    // 镜像构建
+		CommitCommand,   // 容器提交
+		DiffCommand,     // 容器文件变更
+		CopyCommand,     // 容器文件复制
+		ExportCommand,   // 容器导出
+		ImportCommand,   // 文件系统导入
+		VolumeCommand,   // 数据卷
+		EventsCommand,   // 容器事件
+		FockkerdCommand, // 常驻的fockkerd
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "host",
+			Usage:  "fockkerd监听的unix套接字",
+			Value:  api.DefaultSocketPath,
+			EnvVar: "FOCKKER_HOST",
+		},
+		cli.StringFlag{
+			Name:   "image-root",
+			Usage:  "镜像存储根目录",
+			Value:  image.StoreRoot,
+			EnvVar: "FOCKKER_IMAGE_ROOT",
+		},
 	}
 
 	// 设置日志输出
 	app.Before = func(ctx *cli.Context) error {
 		// 设置异常日志输出格式
 		logInit()
-		// 容器网络初始化
-		network.InitNetwork()
+		// 镜像存储根目录
+		image.StoreRoot = ctx.GlobalString("image-root")
+		// 客户端命令连接的fockkerd
+		fockkerClient = client.NewDaemonClient(ctx.GlobalString("host"))
 		return nil
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	// 以fockkerd为名运行（如 ln -s fockker fockkerd）且未指定子命令时，启动fockkerd
+	args := os.Args
+	if filepath.Base(args[0]) == "fockkerd" && (len(args) == 1 || strings.HasPrefix(args[1], "-")) {
+		args = append([]string{args[0], FockkerdCommand.Name}, args[1:]...)
+	}
+	if err := app.Run(args); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -18,10 +18,12 @@ const (
 
 const (
 	DefaultBridgeName          string = "fockker0"                        // 默认的Bridge类型驱动名
+	DefaultHostName            string = "host"                            // 默认的Host类型网络名，与宿主机共享网络栈
+	DefaultNoneName            string = "none"                            // 默认的None类型网络名，仅有回环接口
 	defaultSubnet              string = "192.168.0.0/24"                  // 默认网段
 	defaultNetworkConfigName   string = "config.json"                     // 网络配置文件名称
 	defaultAllocatorConfigName string = "subnet.json"                     // IP分配文件名称
-	networkPath                string = constants.RunPath + "/network/%s" // 网络配置存储路径，%s为网络名
+	networkPath                string = constants.LibPath + "/network/%s" // 网络配置存储路径，%s为网络名
 )
 
 var (
@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"fockker/network/iptables"
 	log "github.com/sirupsen/logrus"
@@ -60,6 +61,11 @@ func (bridge *Driver) DisconnectBridge(linkID string) error {
 	// 获取指定名称的虚拟以太网接口
 	device, err := netlink.LinkByName(linkID)
 	if err != nil {
+		// 容器的net namespace销毁时veth设备对随之删除
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
 		return fmt.Errorf("无法找到设备 %s: %v", linkID, err)
 	}
 
@@ -2,10 +2,11 @@ package driver
 
 type DriverType string
 
-// 驱动类型目前仅支持bridge、host
+// 驱动类型目前支持bridge、host、none
 const (
 	Bridge DriverType = "bridge"
 	Host   DriverType = "host"
+	None   DriverType = "none"
 )
 
 type Driver struct {
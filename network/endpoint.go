@@ -50,6 +50,16 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, containerPid string) error {
 	return nil
 }
 
+// 进入容器net namespace，仅启动回环接口 (lo)
+func configLoopback(containerPid string) error {
+	defer enterContainerNetns(nil, containerPid)()
+	if err := driver.SetInterfaceUP("lo"); err != nil {
+		return fmt.Errorf("启动%s接口时异常 %v", "lo", err)
+	}
+	return nil
+}
+
+// 进入容器net namespace；enLink不为空时，先将该接口移动到容器net namespace中
 func enterContainerNetns(enLink *netlink.Link, containerPid string) func() {
 	// 根据进程ID获取net namespace
 	f, err := os.OpenFile(fmt.Sprintf("/proc/%s/ns/net", containerPid), os.O_RDONLY, 0)
@@ -63,8 +73,10 @@ func enterContainerNetns(enLink *netlink.Link, containerPid string) func() {
 	runtime.LockOSThread()
 
 	// 设置 虚拟以太网接口的netns 为目标容器的netns
-	if err = netlink.LinkSetNsFd(*enLink, int(nsFD)); err != nil {
-		log.Errorf("error set link netns , %v", err)
+	if enLink != nil {
+		if err = netlink.LinkSetNsFd(*enLink, int(nsFD)); err != nil {
+			log.Errorf("error set link netns , %v", err)
+		}
 	}
 
 	// 获取当前的网络namespace，用于退出函数后恢复
@@ -31,3 +31,14 @@ func OuterToInner(leftPort string, rightPort string, ipAddress string) error {
 	}
 	return err
 }
+
+// RemoveOuterToInner 删除OuterToInner添加的端口映射规则，容器退出后调用
+func RemoveOuterToInner(leftPort string, rightPort string, ipAddress string) error {
+	iptablesCmd := fmt.Sprintf("-t nat -D PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s", leftPort, ipAddress, rightPort)
+	cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Errorf("iptables规则删除异常: %s", output)
+	}
+	return err
+}
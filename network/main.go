@@ -2,14 +2,17 @@ package network
 
 import (
 	"fmt"
+	"fockker/errdefs"
 	"fockker/network/driver"
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"io"
 	"io/fs"
 	nw "net"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/tabwriter"
 )
@@ -113,22 +116,66 @@ func InitNetwork() {
 		}
 	} else {
 
+	}
+	// 判断是否存在默认的host、none网络，不存在则创建
+	for networkName, networkType := range map[string]NetworkType{DefaultHostName: Host, DefaultNoneName: None} {
+		if _, exists := networks[networkName]; exists {
+			continue
+		}
+		net := &Network{
+			Name:        networkName,
+			NetworkType: networkType,
+		}
+		if err := net.createNetwork(""); err != nil {
+			log.Errorf("默认%s网络创建失败", networkName)
+			continue
+		}
+		if err := net.InfoDump(); err != nil {
+			log.Errorf("默认%s网络配置初始化失败", networkName)
+		}
 	}
 	loadConfig()
+	// 网络配置在宿主机重启后保留，网桥与iptables规则不再存在，按配置重新创建
+	for _, net := range networks {
+		if net.NetworkType != Bridge {
+			continue
+		}
+		if exists, _ := checkBridgeExists(net.Driver.DriverName); !exists {
+			if err := net.Driver.Create(net.IpRange); err != nil {
+				log.Errorf("%s网络的网桥重新创建失败: %v", net.Name, err)
+			}
+		}
+	}
 }
 
-// ListNetwork 列出当前所有已创建的网络及其信息。
-func ListNetwork() {
-	// 从本地配置文件加载到实例中
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+// Networks 获取当前所有已创建的网络，按网络名排序
+func Networks() []*Network {
+	var list []*Network
+	for _, net := range networks {
+		list = append(list, net)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// PrintNetworks 以表格格式输出网络及其信息
+func PrintNetworks(writer io.Writer, list []*Network) {
+	w := tabwriter.NewWriter(writer, 12, 1, 3, ' ', 0)
 	_, err := fmt.Fprint(w, "Name\tIpRange\tType\n")
 	if err != nil {
 		return
 	}
-	for _, net := range networks {
+	for _, net := range list {
+		// host、none网络没有网段
+		ipRange := "-"
+		if net.IpRange != nil {
+			ipRange = net.IpRange.String()
+		}
 		_, err = fmt.Fprintf(w, "%s\t%s\t%s\n",
 			net.Name,
-			net.IpRange.String(),
+			ipRange,
 			net.NetworkType,
 		)
 	}
@@ -138,8 +185,47 @@ func ListNetwork() {
 	}
 }
 
-// CreateNetwork 创建网络
-func CreateNetwork(networkName string, networkType NetworkType, subnet string) error {
+// ValidateCreateNetwork 检查网络能否创建：名称未被占用、类型受支持、网段格式正确
+func ValidateCreateNetwork(networkName string, networkType NetworkType, subnet string) error {
+	if _, exists := networks[networkName]; exists {
+		return errdefs.Conflict("网络%s 已存在", networkName)
+	}
+	switch networkType {
+	case Bridge:
+		if subnet == "" {
+			return nil
+		}
+		if _, _, err := nw.ParseCIDR(subnet); err != nil {
+			return errdefs.Invalid("网段格式错误 %s", subnet)
+		}
+	case Host, None:
+	default:
+		return errdefs.Invalid("不支持的网络类型 %s", networkType)
+	}
+	return nil
+}
+
+// CreateNetwork 创建网络，创建结果写入out
+func CreateNetwork(networkName string, networkType NetworkType, subnet string, out io.Writer) error {
+	if err := ValidateCreateNetwork(networkName, networkType, subnet); err != nil {
+		return err
+	}
+	// host、none网络不需要网段
+	if networkType == Host || networkType == None {
+		net := &Network{
+			Name:        networkName,
+			NetworkType: networkType,
+		}
+		if err := net.createNetwork(""); err != nil {
+			return fmt.Errorf("%s网络创建失败: %v", networkName, err)
+		}
+		if err := net.InfoDump(); err != nil {
+			return fmt.Errorf("%s网络配置写入失败: %v", networkName, err)
+		}
+		networks[networkName] = net
+		fmt.Fprintf(out, "网络: %s, 类型:%s, 创建成功\n", networkName, networkType)
+		return nil
+	}
 	// 未指定网段则按照默认网络位增量添加
 	if subnet == "" {
 		subnet = defaultSubnet
@@ -148,13 +234,17 @@ func CreateNetwork(networkName string, networkType NetworkType, subnet string) e
 	_, baseNet, _ := nw.ParseCIDR(subnet)
 	// 网段冲突判断，如果用户输入重复则增量添加网络位
 	for _, net := range networks {
+		// host、none网络没有网段，无需判断冲突
+		if net.IpRange == nil {
+			continue
+		}
 		// 解析网络配置，取网络位
 		_, targetNet, _ := nw.ParseCIDR(net.IpRange.String())
 		// 判断网络位是否相同
 		if isSameNetwork(baseNet, targetNet) {
 			// 生成新网络配置
 			subnet = incrementNetwork(baseNet)
-			fmt.Printf("该网段与 %s网络 重复, 已为%s重新生成网段: %s\n", net.Name, networkName, subnet)
+			fmt.Fprintf(out, "该网段与 %s网络 重复, 已为%s重新生成网段: %s\n", net.Name, networkName, subnet)
 		}
 	}
 	net := &Network{
@@ -173,29 +263,59 @@ func CreateNetwork(networkName string, networkType NetworkType, subnet string) e
 		if err != nil {
 			return fmt.Errorf("%s网络配置写入失败: %v", networkName, err)
 		}
-	case Host:
-		log.Infof("TODO")
-	case None:
-		log.Infof("TODO")
 	default:
-		return fmt.Errorf("不支持的网络类型 %s", networkType)
+		return errdefs.Invalid("不支持的网络类型 %s", networkType)
 	}
-	fmt.Printf("网络: %s, 网段:%s, 创建成功\n", networkName, subnet)
+	networks[networkName] = net
+	fmt.Fprintf(out, "网络: %s, 网段:%s, 创建成功\n", networkName, subnet)
 	return nil
 }
 
-// ConnectToNetwork 连接容器到网络
-func ConnectToNetwork(networkName string, containerID string, containerPortMapping []string, containerPID string) {
+// GetNetworkType 获取网络类型，用于判断容器是否需要独立的net namespace
+func GetNetworkType(networkName string) (NetworkType, error) {
+	net, exists := networks[networkName]
+	if !exists {
+		return "", errdefs.NotFound("网络%s 不存在", networkName)
+	}
+	return net.NetworkType, nil
+}
+
+// ConnectToNetwork 连接容器到网络，返回容器分配到的IP地址（host、none网络为空）
+func ConnectToNetwork(networkName string, containerID string, containerPortMapping []string, containerPID string) (string, error) {
 	net, exists := networks[networkName]
 	if !exists {
 		log.Errorf("连接失败，网络%s 不存在", networkName)
-		return
+		return "", errdefs.NotFound("网络%s 不存在", networkName)
 	}
-	err := net.connect(containerID, containerPortMapping, containerPID)
+	ip, err := net.connect(containerID, containerPortMapping, containerPID, nil)
 	if err != nil {
 		log.Errorf("%s网络连接失败", networkName)
-		return
+		return "", err
+	}
+	if ip == nil {
+		return "", nil
 	}
+	return ip.String(), nil
+}
+
+// ReconnectToNetwork 重新启动的容器再次连接到网络，沿用之前分配的IP地址
+func ReconnectToNetwork(networkName string, containerID string, containerPortMapping []string, containerPID string, ipAddress string) (string, error) {
+	net, exists := networks[networkName]
+	if !exists {
+		log.Errorf("连接失败，网络%s 不存在", networkName)
+		return "", errdefs.NotFound("网络%s 不存在", networkName)
+	}
+	// 之前分配的IP在容器删除前不会被释放，可以直接复用
+	ip := nw.ParseIP(ipAddress).To4()
+	ip, err := net.connect(containerID, containerPortMapping, containerPID, ip)
+	if err != nil {
+		log.Errorf("%s网络连接失败", networkName)
+		return "", err
+	}
+	if ip == nil {
+		return "", nil
+	}
+	return ip.String(), nil
 }
 
 // DisconnectFromNetwork 容器断开网络
@@ -212,16 +332,64 @@ func DisconnectFromNetwork(networkName string, containerID string) {
 	}
 }
 
-// DistoryNetwork 删除网络
-func DistoryNetwork(networkName string) error {
-	net, exists := networks[networkName]
-	if !exists {
-		return fmt.Errorf("删除失败，网络%s 不存在", networkName)
+// ReleaseFromNetwork 容器退出后清理其网络端点与端口映射，保留分配的IP地址以便重新启动时沿用
+// 由容器的shim进程调用，只加载容器所在网络的配置，不修改IP分配
+func ReleaseFromNetwork(networkName string, containerID string, containerPortMapping []string, ipAddress string) error {
+	net := &Network{Name: networkName}
+	if err := net.InfoLoad(); err != nil {
+		return fmt.Errorf("网络%s 配置加载失败: %v", networkName, err)
+	}
+	if err := net.disconnect(containerID); err != nil {
+		return fmt.Errorf("%s网络断开失败: %v", networkName, err)
+	}
+	if net.NetworkType == Bridge && ipAddress != "" {
+		net.removePortMapping(containerPortMapping, ipAddress)
+	}
+	return nil
+}
+
+// ReleaseIP 容器删除时释放其在网络中分配的IP地址，host、none网络没有分配IP
+func ReleaseIP(networkName string, ipAddress string) error {
+	if ipAddress == "" {
+		return nil
+	}
+	net := &Network{Name: networkName}
+	if err := net.InfoLoad(); err != nil {
+		return fmt.Errorf("网络%s 配置加载失败: %v", networkName, err)
+	}
+	if net.NetworkType != Bridge {
+		return nil
+	}
+	ip := nw.ParseIP(ipAddress).To4()
+	if ip == nil {
+		return fmt.Errorf("无效的IP地址 %s", ipAddress)
+	}
+	return net.IpAllocator.Release(net.IpRange, &ip)
+}
+
+// ValidateDistoryNetwork 检查网络能否删除：网络存在且不是预置网络
+func ValidateDistoryNetwork(networkName string) error {
+	if _, exists := networks[networkName]; !exists {
+		return errdefs.NotFound("删除失败，网络%s 不存在", networkName)
+	}
+	if networkName == DefaultHostName || networkName == DefaultNoneName {
+		return errdefs.Conflict("删除失败，%s为预置网络", networkName)
+	}
+	return nil
+}
+
+// DistoryNetwork 删除网络，删除结果写入out
+func DistoryNetwork(networkName string, out io.Writer) error {
+	if err := ValidateDistoryNetwork(networkName); err != nil {
+		return err
 	}
+	net := networks[networkName]
 	err := net.deleteNetwork()
 	if err != nil {
 		return fmt.Errorf("网络删除异常: %v", err)
 	}
-	fmt.Printf("网络: %s, 删除成功\n", networkName)
+	delete(networks, networkName)
+	delete(drivers, net.Driver.DriverName)
+	fmt.Fprintf(out, "网络: %s, 删除成功\n", networkName)
 	return nil
 }
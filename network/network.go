@@ -120,7 +120,13 @@ func (net *Network) createNetwork(subnet string) error {
 			// 将创建好的driver加入到drivers
 			drivers[net.Driver.DriverName] = &net.Driver
 		case Host:
-			return nil
+			// host网络直接复用宿主机网络栈，无需创建网桥与分配IP
+			net.Driver.DriverType = driver.Host
+			drivers[net.Driver.DriverName] = &net.Driver
+		case None:
+			// none网络仅保留容器自身的回环接口
+			net.Driver.DriverType = driver.None
+			drivers[net.Driver.DriverName] = &net.Driver
 		}
 	}
 
@@ -129,7 +135,8 @@ func (net *Network) createNetwork(subnet string) error {
 
 // 删除指定的网络、驱动和分配的IP。
 func (net *Network) deleteNetwork() error {
-	if net.NetworkType != None {
+	// 只有bridge网络存在需要删除的网桥设备
+	if net.NetworkType == Bridge {
 		if err := net.Driver.Delete(); err != nil {
 			return fmt.Errorf("删除网络驱动时异常: %v", err)
 		}
@@ -140,13 +147,32 @@ func (net *Network) deleteNetwork() error {
 	return nil
 }
 
-// 连接容器到指定网络
-func (net *Network) connect(containerID string, containerPortMapping []string, containerPID string) error {
-	// 分配容器IP地址
-	ip, err := net.IpAllocator.Allocate(net.IpRange)
-	if err != nil {
-		log.Errorf("%v", err)
-		return err
+// 连接容器到指定网络，返回容器在该网络中的IP地址；ip为空时从网段中重新分配
+func (net *Network) connect(containerID string, containerPortMapping []string, containerPID string, ip nw.IP) (nw.IP, error) {
+	switch net.NetworkType {
+	case Host:
+		// host网络的容器未创建net namespace，直接使用宿主机的网络设备与端口
+		if len(containerPortMapping) > 0 {
+			log.Warnf("%s网络下端口映射 %q 无效, 容器直接使用宿主机端口", net.Name, containerPortMapping)
+		}
+		return nil, nil
+	case None:
+		// none网络的容器只启动回环接口
+		if err := configLoopback(containerPID); err != nil {
+			log.Errorf("容器回环接口异常 %v", err)
+			return nil, err
+		}
+		return nil, nil
+	}
+	// 分配容器IP地址，连接失败时释放本次分配的地址
+	var err error
+	allocated := ip == nil
+	if allocated {
+		ip, err = net.IpAllocator.Allocate(net.IpRange)
+		if err != nil {
+			log.Errorf("%v", err)
+			return nil, err
+		}
 	}
 	// 创建网络端点
 	endpointId := fmt.Sprintf("%s-%s", containerID, net.Name)
@@ -159,18 +185,41 @@ func (net *Network) connect(containerID string, containerPortMapping []string, c
 	// 调用网络驱动挂载和配置网络端点
 	if err = net.Driver.ConnectBridge(ep.ID[:5], &ep.Device); err != nil {
 		log.Errorf("网桥连接异常 %v", err)
-		return err
+		net.unwindConnect(containerID, ip, allocated)
+		return nil, err
 	}
 	// 进入容器namespace配置容器网络设备IP地址
 	if err = configEndpointIpAddressAndRoute(ep, containerPID); err != nil {
 		log.Errorf("容器接口异常 %v", err)
-		return err
+		net.unwindConnect(containerID, ip, allocated)
+		return nil, err
+	}
+	if err = net.configPortMapping(ep); err != nil {
+		net.unwindConnect(containerID, ip, allocated)
+		return nil, err
+	}
+	return ip, nil
+}
+
+// 连接失败时删除已创建的veth设备，ip为本次分配时一并释放，清理失败只记录日志
+func (net *Network) unwindConnect(containerID string, ip nw.IP, allocated bool) {
+	if err := net.disconnect(containerID); err != nil {
+		log.Errorf("%s网络断开失败: %v", net.Name, err)
+	}
+	if !allocated {
+		return
+	}
+	if err := net.IpAllocator.Release(net.IpRange, &ip); err != nil {
+		log.Errorf("IP地址 %s 释放异常 %v", ip, err)
 	}
-	return net.configPortMapping(ep)
 }
 
 // 断开容器与网络的连接
 func (net *Network) disconnect(containerID string) error {
+	// host、none网络没有veth设备
+	if net.NetworkType != Bridge {
+		return nil
+	}
 	endpointId := fmt.Sprintf("%s-%s", containerID, net.Name)
 	err := net.Driver.DisconnectBridge(endpointId[:5])
 	if err != nil {
@@ -181,20 +230,34 @@ func (net *Network) disconnect(containerID string) error {
 
 // 配置宿主机到容器的端口映射
 func (net *Network) configPortMapping(ep *Endpoint) error {
+	var applied []string
 	for _, pm := range ep.PortMapping {
 		portMapping := strings.Split(pm, ":")
 		if len(portMapping) != 2 {
 			log.Errorf("端口映射格式错误 %v", pm)
 			continue
 		}
-		err := iptables.OuterToInner(portMapping[0], ep.IPAddress.String(), portMapping[1])
-		if err != nil {
-			continue
+		// 添加失败时删除已添加的规则
+		if err := iptables.OuterToInner(portMapping[0], portMapping[1], ep.IPAddress.String()); err != nil {
+			net.removePortMapping(applied, ep.IPAddress.String())
+			return fmt.Errorf("端口映射 %s 配置异常 %v", pm, err)
 		}
+		applied = append(applied, pm)
 	}
 	return nil
 }
 
+// 删除宿主机到容器的端口映射
+func (net *Network) removePortMapping(containerPortMapping []string, ipAddress string) {
+	for _, pm := range containerPortMapping {
+		portMapping := strings.Split(pm, ":")
+		if len(portMapping) != 2 {
+			continue
+		}
+		_ = iptables.RemoveOuterToInner(portMapping[0], portMapping[1], ipAddress)
+	}
+}
+
 // 判断两个网络是否属于同一网络位
 func isSameNetwork(net1, net2 *nw.IPNet) bool {
 	// 比较掩码和网络地址
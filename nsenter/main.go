@@ -13,6 +13,7 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <sys/wait.h>
 
 __attribute__((constructor)) void enter_namespace(void) {
 	// 从环境变量中获取需要进入的PID
@@ -45,8 +46,15 @@ __attribute__((constructor)) void enter_namespace(void) {
 		}
 		close(fd);
 	}
+	// 以命令的退出码退出，命令被信号终止时为128+信号值
 	int res = system(TARGET_CMD);
-	exit(0);
+	if (res == -1) {
+		exit(127);
+	}
+	if (WIFSIGNALED(res)) {
+		exit(128 + WTERMSIG(res));
+	}
+	exit(WEXITSTATUS(res));
 	return;
 }
 */